@@ -0,0 +1,177 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// PR_CAPBSET_DROP, which the syscall package doesn't have
+const prCapbsetDrop = 24
+
+// the capabilities by name, see capabilities(7)
+var capabilities = map[string]int{
+	"CHOWN":              0,
+	"DAC_OVERRIDE":       1,
+	"DAC_READ_SEARCH":    2,
+	"FOWNER":             3,
+	"FSETID":             4,
+	"KILL":               5,
+	"SETGID":             6,
+	"SETUID":             7,
+	"SETPCAP":            8,
+	"LINUX_IMMUTABLE":    9,
+	"NET_BIND_SERVICE":   10,
+	"NET_BROADCAST":      11,
+	"NET_ADMIN":          12,
+	"NET_RAW":            13,
+	"IPC_LOCK":           14,
+	"IPC_OWNER":          15,
+	"SYS_MODULE":         16,
+	"SYS_RAWIO":          17,
+	"SYS_CHROOT":         18,
+	"SYS_PTRACE":         19,
+	"SYS_PACCT":          20,
+	"SYS_ADMIN":          21,
+	"SYS_BOOT":           22,
+	"SYS_NICE":           23,
+	"SYS_RESOURCE":       24,
+	"SYS_TIME":           25,
+	"SYS_TTY_CONFIG":     26,
+	"MKNOD":              27,
+	"LEASE":              28,
+	"AUDIT_WRITE":        29,
+	"AUDIT_CONTROL":      30,
+	"SETFCAP":            31,
+	"MAC_OVERRIDE":       32,
+	"MAC_ADMIN":          33,
+	"SYSLOG":             34,
+	"WAKE_ALARM":         35,
+	"BLOCK_SUSPEND":      36,
+	"AUDIT_READ":         37,
+	"PERFMON":            38,
+	"BPF":                39,
+	"CHECKPOINT_RESTORE": 40,
+}
+
+// the capabilities that the command keeps by default, the same as docker's. they're enough for
+// what root usually does in a container (installing packages, dropping to other users, binding
+// to low ports etc.) but not for what affects the host, like loading kernel modules (SYS_MODULE),
+// mounting (SYS_ADMIN) or reconfiguring the network (NET_ADMIN)
+var defaultCapabilities = []string{
+	"CHOWN", "DAC_OVERRIDE", "FSETID", "FOWNER", "MKNOD", "NET_RAW", "SETGID", "SETUID", "SETFCAP",
+	"SETPCAP", "NET_BIND_SERVICE", "SYS_CHROOT", "KILL", "AUDIT_WRITE",
+}
+
+// caps prints the capabilities that focker knows, whether the command keeps each of them by
+// default & whether it would keep it with the --cap-add & --cap-drop flags in args, like run
+func caps(args []string) int {
+	var add, drop []string
+	for _, arg := range args {
+		flag, value, _ := strings.Cut(arg, "=")
+		if flag != "--cap-add" && flag != "--cap-drop" {
+			log.Fatalf("caps: unknown flag %s (expected --cap-add=<capability> or --cap-drop=<capability>)", arg)
+		}
+
+		capability, err := parseCapability(value)
+		exitIfError(err, "caps: "+flag)
+		if flag == "--cap-add" {
+			add = append(add, capability)
+		} else {
+			drop = append(drop, capability)
+		}
+	}
+
+	names := make([]string, 0, len(capabilities))
+	for name := range capabilities {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return capabilities[names[i]] < capabilities[names[j]] })
+
+	isDefault := containerCapabilities(nil, nil)
+	kept := containerCapabilities(add, drop)
+	yesNo := map[bool]string{true: "yes", false: "no"}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CAPABILITY\tNUMBER\tDEFAULT\tGRANTED")
+	for _, name := range names {
+		fmt.Fprintf(w, "CAP_%s\t%d\t%s\t%s\n", name, capabilities[name], yesNo[isDefault[name]], yesNo[kept[name]])
+	}
+
+	w.Flush()
+	return 0
+}
+
+// parseCapability parses a capability name like NET_ADMIN, CAP_NET_ADMIN or net_admin, or ALL
+func parseCapability(name string) (string, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "CAP_")
+	if _, ok := capabilities[name]; !ok && name != "ALL" {
+		return "", fmt.Errorf("unknown capability %q", name)
+	}
+
+	return name, nil
+}
+
+// containerCapabilities returns the capabilities that the command keeps: the default ones, minus
+// the dropped ones, plus the added ones. ALL stands for every capability
+func containerCapabilities(add []string, drop []string) map[string]bool {
+	kept := map[string]bool{}
+	for _, name := range defaultCapabilities {
+		kept[name] = true
+	}
+
+	for _, name := range drop {
+		if name == "ALL" {
+			kept = map[string]bool{}
+			break
+		}
+
+		delete(kept, name)
+	}
+
+	for _, name := range add {
+		if name == "ALL" {
+			for name := range capabilities {
+				kept[name] = true
+			}
+
+			break
+		}
+
+		kept[name] = true
+	}
+
+	return kept
+}
+
+// dropCapabilities removes every capability but the kept ones from the bounding set of the calling
+// thread. the bounding set is inherited by the processes that the thread forks & caps what they
+// can have after execve, even as root. our own capabilities aren't affected, so we can still
+// unmount things afterwards
+func dropCapabilities(kept map[string]bool) error {
+	lastCap := len(capabilities) - 1
+	if data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		lastCap, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+
+	for name, capability := range capabilities {
+		if kept[name] || capability > lastCap {
+			continue
+		}
+
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(capability), 0, 0, 0, 0)
+		if errno != 0 {
+			return fmt.Errorf("drop CAP_%s: %w", name, errno)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,694 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// focker only supports cgroup v2, which is expected to be mounted here
+const cgroupRoot = "/sys/fs/cgroup"
+
+// all of the containers' cgroups are created under this cgroup
+const cgroupParent = "focker"
+
+// checkCgroupV2 makes sure that a cgroup v2 filesystem is mounted at cgroupRoot by looking for
+// it in /proc/mounts. without this check, using a cgroup option on a host with cgroup v1 (or
+// no cgroups at all) fails with an errno from writing to a file that doesn't exist
+func checkCgroupV2() error {
+	data, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return fmt.Errorf("check for cgroup v2: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// <source> <mount point> <fs type> <options> ...
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[1] == cgroupRoot && fields[2] == "cgroup2" {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"cgroup v2 is not mounted at %s, so the resource limits can't be applied. focker needs a host that uses the unified cgroup hierarchy (e.g. booted with systemd.unified_cgroup_hierarchy=1)",
+		cgroupRoot,
+	)
+}
+
+func containerCgroupDir(containerId string) string {
+	return filepath.Join(cgroupRoot, cgroupParent, containerId)
+}
+
+// createContainerCgroup creates a cgroup for the container under the focker cgroup, delegating
+// all of the available controllers to it
+func createContainerCgroup(containerId string) error {
+	parentDir := filepath.Join(cgroupRoot, cgroupParent)
+	if err := os.MkdirAll(parentDir, 0755); err != nil {
+		return fmt.Errorf("create cgroup %s: %w", parentDir, err)
+	}
+
+	// a controller can only be used in a cgroup if it's enabled in the subtree_control of all of
+	// its ancestors, so enable them at the root & in the focker cgroup
+	for _, dir := range []string{cgroupRoot, parentDir} {
+		enableCgroupControllers(dir)
+	}
+
+	cgroupDir := containerCgroupDir(containerId)
+	if err := os.Mkdir(cgroupDir, 0755); err != nil {
+		return fmt.Errorf("create cgroup %s: %w", cgroupDir, err)
+	}
+
+	return nil
+}
+
+// enableCgroupControllers enables all controllers available in a cgroup for its children. it's
+// best effort because some controllers can't be enabled in some setups, in which case writing
+// to a file of that controller will fail later
+func enableCgroupControllers(cgroupDir string) {
+	for _, controller := range readCgroupControllers(cgroupDir) {
+		writeCgroupInterfaceFile(filepath.Join(cgroupDir, "cgroup.subtree_control"), "+"+controller)
+	}
+}
+
+// readCgroupControllers returns the controllers that are available in a cgroup
+func readCgroupControllers(cgroupDir string) []string {
+	data, err := os.ReadFile(filepath.Join(cgroupDir, "cgroup.controllers"))
+	if err != nil {
+		return nil
+	}
+
+	return strings.Fields(string(data))
+}
+
+func writeCgroupFile(containerId string, file string, value string) error {
+	path := filepath.Join(containerCgroupDir(containerId), file)
+	if err := writeCgroupInterfaceFile(path, value); err != nil {
+		return fmt.Errorf("write %q to cgroup file %s: %w", value, file, err)
+	}
+
+	return nil
+}
+
+// writeCgroupInterfaceFile writes to a file that the kernel created in a cgroup. unlike
+// os.WriteFile, it never creates the file, which would just be a regular file if cgroupRoot
+// isn't actually a cgroup filesystem
+func writeCgroupInterfaceFile(path string, value string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteString(value)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func addToContainerCgroup(containerId string, pid int) error {
+	return writeCgroupFile(containerId, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// limits returns the resource limits that are recorded in the container's config
+func (options *runOptions) limits() *containerLimits {
+	limits := &containerLimits{
+		Memory:     options.memoryMax,
+		MemoryHigh: options.memoryHigh,
+		Cpus:       options.cpus,
+		Pids:       options.pidsLimit,
+		IoWeight:   options.ioWeight,
+		CgroupConf: options.cgroupConf,
+	}
+
+	for _, throttle := range options.ioThrottles {
+		limits.Io = append(limits.Io, fmt.Sprintf("%s %s=%d", throttle.device, throttle.key, throttle.value))
+	}
+
+	return limits
+}
+
+// setupContainerCgroup creates the container's cgroup & applies all of its limits, with a warning
+// for those that the kernel adjusted (see verifyCgroupLimits). the cgroup is removed again if a
+// limit can't be applied, otherwise removeContainerCgroup removes it once the container exits
+func setupContainerCgroup(containerId string, options *runOptions) error {
+	if err := createContainerCgroup(containerId); err != nil {
+		return err
+	}
+
+	err := applyMemoryLimits(containerId, options.memoryMax, options.memoryHigh)
+	if err == nil {
+		err = applyCpuLimit(containerId, options.cpus)
+	}
+
+	if err == nil {
+		err = applyPidsLimit(containerId, options.pidsLimit)
+	}
+
+	if err == nil {
+		err = applyIoWeight(containerId, options.ioWeight)
+	}
+
+	if err == nil {
+		err = applyIoThrottles(containerId, options.ioThrottles)
+	}
+
+	if err == nil {
+		err = applyCgroupConf(containerId, options.cgroupConf)
+	}
+
+	if err == nil {
+		verifyCgroupLimits(containerId, options)
+	}
+
+	if err == nil && options.mountCgroup == "rw" {
+		err = delegateContainerCgroup(containerId, options.containerRootId())
+	}
+
+	if err != nil {
+		removeContainerCgroup(containerId)
+		return err
+	}
+
+	return nil
+}
+
+// a value that setupContainerCgroup wrote to a file of the container's cgroup
+type cgroupLimit struct {
+	file  string
+	value string
+}
+
+// verifyCgroupLimits reads back the limits that setupContainerCgroup wrote & warns about those
+// that the kernel didn't take as they were. it only rejects values that are out of range, others
+// are adjusted silently, e.g. memory limits are rounded down to a multiple of the page size
+func verifyCgroupLimits(containerId string, options *runOptions) {
+	var limits []cgroupLimit
+	if options.memoryHigh > 0 {
+		limits = append(limits, cgroupLimit{"memory.high", strconv.FormatUint(options.memoryHigh, 10)})
+	}
+
+	if options.memoryMax > 0 {
+		limits = append(limits, cgroupLimit{"memory.max", strconv.FormatUint(options.memoryMax, 10)})
+	}
+
+	if options.cpus > 0 {
+		limits = append(limits, cgroupLimit{"cpu.max", cpuMaxValue(options.cpus)})
+	}
+
+	if options.pidsLimit > 0 {
+		limits = append(limits, cgroupLimit{"pids.max", strconv.FormatInt(options.pidsLimit, 10)})
+	}
+
+	if options.ioWeight > 0 {
+		limits = append(limits, cgroupLimit{"io.weight", ioWeightValue(options.ioWeight)})
+	}
+
+	// they were written already, so the devices exist
+	lines, _ := ioMaxLines(options.ioThrottles)
+	for _, line := range lines {
+		limits = append(limits, cgroupLimit{"io.max", line})
+	}
+
+	for _, limit := range limits {
+		content, err := os.ReadFile(filepath.Join(containerCgroupDir(containerId), limit.file))
+		if err != nil {
+			warnf("can't read back %s of container %s: %v", limit.file, containerId, err)
+			continue
+		}
+
+		if effective := effectiveCgroupValue(limit, string(content)); effective != limit.value {
+			warnf("%s of container %s is %q instead of the requested %q", limit.file, containerId, effective, limit.value)
+		}
+	}
+}
+
+// effectiveCgroupValue returns what content (that of limit.file) says about what was written to
+// it, in the format that it was written in. io.weight & io.max have a line per device, & io.max
+// also has the keys that weren't written, e.g. "8:0 rbps=1048576 wbps=max riops=max wiops=max"
+func effectiveCgroupValue(limit cgroupLimit, content string) string {
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+
+	switch limit.file {
+	case "io.weight":
+		for _, line := range lines {
+			if strings.HasPrefix(line, "default ") {
+				return line
+			}
+		}
+
+		return ""
+	case "io.max":
+		requested := strings.Fields(limit.value)
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || fields[0] != requested[0] {
+				continue
+			}
+
+			values := map[string]string{}
+			for _, field := range fields[1:] {
+				key, value, _ := strings.Cut(field, "=")
+				values[key] = value
+			}
+
+			effective := []string{fields[0]}
+			for _, field := range requested[1:] {
+				key, _, _ := strings.Cut(field, "=")
+				effective = append(effective, key+"="+values[key])
+			}
+
+			return strings.Join(effective, " ")
+		}
+
+		return ""
+	default:
+		return strings.TrimSpace(content)
+	}
+}
+
+// removeContainerCgroup removes the container's cgroup, which can only be done once all of its
+// processes have exited. a delegated cgroup (see --mount-cgroup) can have cgroups that the
+// container created under it, which are removed first, the deepest ones first
+func removeContainerCgroup(containerId string) error {
+	var dirs []string
+	filepath.WalkDir(containerCgroupDir(containerId), func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			dirs = append(dirs, path)
+		}
+
+		return nil
+	})
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(dirs[i]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove cgroup of container %s: %w", containerId, err)
+		}
+	}
+
+	return nil
+}
+
+// the files of a cgroup that its owner needs to manage the cgroups under it, see "Delegation
+// Containment" in the cgroup v2 docs of the kernel
+var cgroupDelegationFiles = []string{"cgroup.procs", "cgroup.threads", "cgroup.subtree_control"}
+
+// delegateContainerCgroup hands the container's cgroup over to root of the container, so that it
+// can create cgroups under it & move its processes between them, like systemd does. with user
+// namespaces, that's done by chowning the cgroup & its delegation files. the container can't
+// change its own limits, the files of which stay owned by root of the host
+func delegateContainerCgroup(containerId string, rootId int) error {
+	cgroupDir := containerCgroupDir(containerId)
+	for _, file := range append([]string{""}, cgroupDelegationFiles...) {
+		if err := os.Chown(filepath.Join(cgroupDir, file), rootId, rootId); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("delegate cgroup: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// mountContainerCgroup mounts a cgroup2 filesystem at cgroupRoot in the container (after
+// pivot_root), which shows the root of its cgroup namespace, i.e. its own cgroup
+func mountContainerCgroup(readOnly bool) error {
+	if err := os.MkdirAll(cgroupRoot, 0555); err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+
+	return syscall.Mount("cgroup2", cgroupRoot, "cgroup2", flags, "")
+}
+
+// applyCgroupConf writes arbitrary controller files given with --cgroup-conf=<file>=<value>. the
+// core interface files (cgroup.*) are off-limits because writing to them could move processes
+// around or break the hierarchy, so only files of controllers enabled for the cgroup are allowed
+func applyCgroupConf(containerId string, conf map[string]string) error {
+	cgroupDir := containerCgroupDir(containerId)
+	controllers := readCgroupControllers(cgroupDir)
+
+	for file, value := range conf {
+		controller, _, ok := strings.Cut(file, ".")
+		if !ok || strings.ContainsRune(file, '/') {
+			return fmt.Errorf("--cgroup-conf: %s is not a cgroup controller file", file)
+		}
+
+		if controller == "cgroup" {
+			return fmt.Errorf("--cgroup-conf: writing to %s is not allowed", file)
+		}
+
+		enabled := false
+		for _, c := range controllers {
+			if c == controller {
+				enabled = true
+				break
+			}
+		}
+
+		if !enabled {
+			return fmt.Errorf("--cgroup-conf: controller %s is not enabled for the container's cgroup", controller)
+		}
+
+		if _, err := os.Stat(filepath.Join(cgroupDir, file)); err != nil {
+			return fmt.Errorf("--cgroup-conf: %s is not a file of the %s controller", file, controller)
+		}
+
+		if err := writeCgroupFile(containerId, file, value); err != nil {
+			return fmt.Errorf("--cgroup-conf: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parseMemorySize parses a size in bytes with an optional b, k, m or g suffix, like 256m
+func parseMemorySize(value string) (uint64, error) {
+	number := strings.ToLower(value)
+	var unit uint64 = 1
+
+	if len(number) > 0 {
+		switch number[len(number)-1] {
+		case 'b':
+			number = number[:len(number)-1]
+		case 'k':
+			unit = 1 << 10
+			number = number[:len(number)-1]
+		case 'm':
+			unit = 1 << 20
+			number = number[:len(number)-1]
+		case 'g':
+			unit = 1 << 30
+			number = number[:len(number)-1]
+		}
+	}
+
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid memory size %q: expected a positive number of bytes with an optional b, k, m or g suffix", value)
+	}
+
+	return n * unit, nil
+}
+
+// applyMemoryLimits writes the hard limit (memory.max), over which the container is OOM killed,
+// & the throttling limit (memory.high), over which its processes are throttled & put under heavy
+// reclaim pressure. a limit of 0 isn't written
+func applyMemoryLimits(containerId string, max uint64, high uint64) error {
+	if high > 0 {
+		if err := writeCgroupFile(containerId, "memory.high", strconv.FormatUint(high, 10)); err != nil {
+			return err
+		}
+	}
+
+	if max > 0 {
+		if err := writeCgroupFile(containerId, "memory.max", strconv.FormatUint(max, 10)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the cfs period that cpu.max quotas are relative to, in microseconds. it's the kernel's default
+const cpuPeriod = 100000
+
+// the kernel rejects quotas under 1ms
+const minCpuQuota = 1000
+
+// parseCpus parses the value of --cpus, the number of cpus' worth of time that the container may
+// use, e.g. 1.5
+func parseCpus(value string) (float64, error) {
+	cpus, err := strconv.ParseFloat(value, 64)
+	if err != nil || cpus <= 0 || math.IsInf(cpus, 0) || math.IsNaN(cpus) {
+		return 0, fmt.Errorf("invalid number of cpus %q: expected a positive number, e.g. 1.5", value)
+	}
+
+	return cpus, nil
+}
+
+// applyCpuLimit writes cpu.max so that the container gets at most cpus cpus' worth of time in each
+// period, e.g. "150000 100000" for 1.5 cpus. a limit of 0 isn't written
+func applyCpuLimit(containerId string, cpus float64) error {
+	if cpus == 0 {
+		return nil
+	}
+
+	return writeCgroupFile(containerId, "cpu.max", cpuMaxValue(cpus))
+}
+
+func cpuMaxValue(cpus float64) string {
+	quota := int64(math.Round(cpus * cpuPeriod))
+	return fmt.Sprintf("%d %d", quota, cpuPeriod)
+}
+
+// parsePidsLimit parses the value of --pids-limit, the number of processes (& threads) that the
+// container may have at once
+func parsePidsLimit(value string) (int64, error) {
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit %q, it must be a positive integer", value)
+	}
+
+	return limit, nil
+}
+
+// applyPidsLimit writes pids.max, so that forking fails with EAGAIN once the container has limit
+// processes, which stops fork bombs. a limit of 0 isn't written
+func applyPidsLimit(containerId string, limit int64) error {
+	if limit == 0 {
+		return nil
+	}
+
+	return writeCgroupFile(containerId, "pids.max", strconv.FormatInt(limit, 10))
+}
+
+// the smallest hard memory limit that makes sense, like docker's. a container with less memory
+// than this gets OOM killed while it's still starting up
+const minMemoryLimit = 6 << 20
+
+// checkResourceLimits makes sure that the resource limits can actually be satisfied on this
+// host, so that mistakes like -m=999g are caught before the container is even created rather
+// than being rejected by the kernel in the middle of the setup
+func checkResourceLimits(options *runOptions) error {
+	hostMemory, err := readHostMemory()
+	if err != nil {
+		return err
+	}
+
+	if options.memoryMax > hostMemory {
+		return fmt.Errorf("--memory of %d bytes is more than the host's memory (%d bytes)", options.memoryMax, hostMemory)
+	}
+
+	if options.memoryMax > 0 && options.memoryMax < minMemoryLimit {
+		return fmt.Errorf("--memory of %d bytes is too low, the minimum is %d bytes", options.memoryMax, minMemoryLimit)
+	}
+
+	if options.memoryHigh > hostMemory {
+		warnf("--memory-high of %d bytes is more than the host's memory (%d bytes), so it'll never kick in", options.memoryHigh, hostMemory)
+	}
+
+	if options.pidsLimit > 0 {
+		pidMax, err := readPidMax()
+		if err != nil {
+			return err
+		}
+
+		if options.pidsLimit > pidMax {
+			return fmt.Errorf("--pids-limit of %d is more than the host's pid_max (%d)", options.pidsLimit, pidMax)
+		}
+	}
+
+	if options.cpus > float64(runtime.NumCPU()) {
+		return fmt.Errorf("--cpus of %g is more than the host's %d cpus", options.cpus, runtime.NumCPU())
+	}
+
+	if options.cpus > 0 && options.cpus*cpuPeriod < minCpuQuota {
+		return fmt.Errorf("--cpus of %g is too low, the minimum is %g", options.cpus, float64(minCpuQuota)/cpuPeriod)
+	}
+
+	for _, throttle := range options.ioThrottles {
+		if _, err := blockDeviceNumber(throttle.device); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readHostMemory returns the total memory of the host in bytes, from /proc/meminfo
+func readHostMemory() (uint64, error) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// MemTotal:       16318480 kB
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			kib, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				break
+			}
+
+			return kib << 10, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+}
+
+// readPidMax returns the highest pid of the host plus one, which no pids limit can go past, from
+// /proc/sys/kernel/pid_max
+func readPidMax() (int64, error) {
+	data, err := os.ReadFile("/proc/sys/kernel/pid_max")
+	if err != nil {
+		return 0, err
+	}
+
+	pidMax, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad /proc/sys/kernel/pid_max: %q", data)
+	}
+
+	return pidMax, nil
+}
+
+// a limit on a block device, written to io.max as <major>:<minor> <key>=<value>
+type ioThrottle struct {
+	device string
+	key    string // riops, wiops, rbps or wbps
+	value  uint64
+}
+
+// parseIoThrottle parses a flag like --device-read-iops=/dev/sda:1000 into a limit of type key.
+// the bytes per second of rbps & wbps can have a size suffix like --memory, e.g. /dev/sda:10m
+func parseIoThrottle(arg string, key string) ioThrottle {
+	flag, spec, _ := strings.Cut(arg, "=")
+
+	// the device path can't contain a colon, but split at the last one anyway
+	sep := strings.LastIndexByte(spec, ':')
+	if sep <= 0 {
+		log.Fatalf("invalid %s (expected <device>:<value>): %s", flag, spec)
+	}
+
+	if key == "rbps" || key == "wbps" {
+		value, err := parseMemorySize(spec[sep+1:])
+		if err != nil {
+			log.Fatalf("invalid %s: value must be a positive number of bytes with an optional b, k, m or g suffix: %s", flag, spec[sep+1:])
+		}
+
+		return ioThrottle{device: spec[:sep], key: key, value: value}
+	}
+
+	value, err := strconv.ParseUint(spec[sep+1:], 10, 64)
+	if err != nil || value == 0 {
+		log.Fatalf("invalid %s: value must be a positive integer: %s", flag, spec[sep+1:])
+	}
+
+	return ioThrottle{device: spec[:sep], key: key, value: value}
+}
+
+// the range of io.weight, the default being 100
+const (
+	minIoWeight = 1
+	maxIoWeight = 10000
+)
+
+// parseIoWeight parses the value of --blkio-weight
+func parseIoWeight(value string) (uint64, error) {
+	weight, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || weight < minIoWeight || weight > maxIoWeight {
+		return 0, fmt.Errorf("invalid weight %q, it must be an integer from %d to %d", value, minIoWeight, maxIoWeight)
+	}
+
+	return weight, nil
+}
+
+// applyIoWeight writes io.weight, which divides the block io time between the cgroups that compete
+// for a device in proportion to their weights. a weight of 0 isn't written
+func applyIoWeight(containerId string, weight uint64) error {
+	if weight == 0 {
+		return nil
+	}
+
+	return writeCgroupFile(containerId, "io.weight", ioWeightValue(weight))
+}
+
+func ioWeightValue(weight uint64) string {
+	return fmt.Sprintf("default %d", weight)
+}
+
+// applyIoThrottles writes the block io limits to io.max, see ioMaxLines
+func applyIoThrottles(containerId string, throttles []ioThrottle) error {
+	lines, err := ioMaxLines(throttles)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if err := writeCgroupFile(containerId, "io.max", line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ioMaxLines returns the lines of io.max for the block io limits, one per device so that multiple
+// limits on the same device are combined, e.g. "8:0 riops=1000 wiops=500"
+func ioMaxLines(throttles []ioThrottle) ([]string, error) {
+	var devices []string
+	limits := map[string][]string{}
+
+	for _, throttle := range throttles {
+		device, err := blockDeviceNumber(throttle.device)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := limits[device]; !ok {
+			devices = append(devices, device)
+		}
+
+		limits[device] = append(limits[device], fmt.Sprintf("%s=%d", throttle.key, throttle.value))
+	}
+
+	var lines []string
+	for _, device := range devices {
+		lines = append(lines, device+" "+strings.Join(limits[device], " "))
+	}
+
+	return lines, nil
+}
+
+// blockDeviceNumber returns the <major>:<minor> of a block device
+func blockDeviceNumber(devicePath string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(devicePath, &stat); err != nil {
+		return "", fmt.Errorf("device %s: %w", devicePath, err)
+	}
+
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return "", fmt.Errorf("%s is not a block device", devicePath)
+	}
+
+	// see major() & minor() in glibc's sys/sysmacros.h
+	rdev := uint64(stat.Rdev)
+	major := (rdev>>8)&0xfff | (rdev>>32)&^0xfff
+	minor := rdev&0xff | (rdev>>12)&^0xff
+
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func TestEffectiveCgroupValue(t *testing.T) {
+	tests := []struct {
+		limit   cgroupLimit
+		content string
+		want    string
+	}{
+		{cgroupLimit{"memory.max", "268435456"}, "268435456\n", "268435456"},
+
+		// rounded down to a multiple of the page size
+		{cgroupLimit{"memory.max", "100000001"}, "99999744\n", "99999744"},
+		{cgroupLimit{"memory.high", "1048576"}, "max\n", "max"},
+		{cgroupLimit{"cpu.max", "150000 100000"}, "150000 100000\n", "150000 100000"},
+		{cgroupLimit{"pids.max", "100"}, "100\n", "100"},
+
+		{cgroupLimit{"io.weight", "default 500"}, "default 500\n", "default 500"},
+		{cgroupLimit{"io.weight", "default 500"}, "default 500\n8:0 200\n", "default 500"},
+		{cgroupLimit{"io.weight", "default 500"}, "default 100\n", "default 100"},
+		{cgroupLimit{"io.weight", "default 500"}, "", ""},
+
+		// only the keys that were written are compared
+		{cgroupLimit{"io.max", "8:0 rbps=1048576"}, "8:0 rbps=1048576 wbps=max riops=max wiops=max\n", "8:0 rbps=1048576"},
+		{cgroupLimit{"io.max", "8:16 wbps=2097152 riops=1000"}, "8:0 rbps=1048576 wbps=max riops=max wiops=max\n8:16 rbps=max wbps=2097152 riops=1000 wiops=max\n", "8:16 wbps=2097152 riops=1000"},
+		{cgroupLimit{"io.max", "8:0 riops=1000"}, "8:0 rbps=max wbps=max riops=max wiops=max\n", "8:0 riops=max"},
+
+		// a device that the kernel doesn't have a line for
+		{cgroupLimit{"io.max", "8:0 rbps=1048576"}, "", ""},
+	}
+
+	for _, test := range tests {
+		if got := effectiveCgroupValue(test.limit, test.content); got != test.want {
+			t.Errorf("effectiveCgroupValue(%v, %q) = %q, want %q", test.limit, test.content, got, test.want)
+		}
+	}
+}
+
+func TestCpuMaxValue(t *testing.T) {
+	tests := []struct {
+		cpus float64
+		want string
+	}{
+		{1, "100000 100000"},
+		{1.5, "150000 100000"},
+		{0.01, "1000 100000"},
+		{0.333333, "33333 100000"},
+	}
+
+	for _, test := range tests {
+		if got := cpuMaxValue(test.cpus); got != test.want {
+			t.Errorf("cpuMaxValue(%v) = %q, want %q", test.cpus, got, test.want)
+		}
+	}
+}
+
+func TestCheckResourceLimitsPidsLimit(t *testing.T) {
+	pidMax, err := readPidMax()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		limit   int64
+		wantErr bool
+	}{
+		{0, false},
+		{100, false},
+		{pidMax, false},
+		{pidMax + 1, true},
+	}
+
+	for _, test := range tests {
+		err := checkResourceLimits(&runOptions{pidsLimit: test.limit})
+		if (err != nil) != test.wantErr {
+			t.Errorf("checkResourceLimits(pidsLimit=%d) = %v, want error: %v", test.limit, err, test.wantErr)
+		}
+	}
+}
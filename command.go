@@ -0,0 +1,194 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// the fd of the pipe that the _exec helper waits on before executing the command, if it was
+// started with --wait
+const execWaitFd = 3
+
+// the fd of focker's binary, which focker exec starts the _exec helper from (see containerCommand).
+// that helper isn't started with --wait, & the command mustn't inherit the fd
+const execBinaryFd = 3
+
+// execHelperCommand returns the command that starts the user's command (already looked up at path,
+// with argv as its arguments, including the name it was invoked as) through the _exec helper, i.e.
+// this binary again. the helper restricts itself & then executes the command in its place, so the
+// command keeps the pid, the environment & the user namespace of the helper.
+//
+// this is needed because the capability bounding set is reset to full in a new user namespace, so
+// dropping capabilities from here wouldn't reach a command that's cloned into one. after
+// pivot_root the binary is only reachable through /proc/self/exe
+func execHelperCommand(path string, argv []string, options runOptions) *exec.Cmd {
+	helperArgs := []string{"_exec"}
+	for _, name := range options.capAdd {
+		helperArgs = append(helperArgs, "--cap-add="+name)
+	}
+
+	for _, name := range options.capDrop {
+		helperArgs = append(helperArgs, "--cap-drop="+name)
+	}
+
+	if options.noNewPrivileges {
+		helperArgs = append(helperArgs, "--no-new-privileges")
+	}
+
+	if len(options.seccomp) > 0 {
+		helperArgs = append(helperArgs, "--seccomp="+options.seccomp)
+	}
+
+	if options.noProc {
+		helperArgs = append(helperArgs, "--wait")
+	}
+
+	for _, limit := range options.ulimits {
+		helperArgs = append(helperArgs, "--ulimit="+limit.String())
+	}
+
+	// by now the user is resolved to <uid>:<gid>[:<groups>]. it isn't the helper's Credential
+	// because the helper needs to be root to restrict itself
+	if len(options.user) > 0 {
+		helperArgs = append(helperArgs, "--user="+options.user)
+	}
+
+	helperArgs = append(append(helperArgs, "--", path), argv...)
+
+	return &exec.Cmd{
+		Path: "/proc/self/exe",
+		Args: append([]string{argv[0]}, helperArgs...),
+	}
+}
+
+// execHelper is the _exec command. it drops the capabilities that the command doesn't get, sets
+// no_new_privs if asked to, installs the seccomp filter unless it's unconfined, sets the limits of
+// --ulimit, switches to the user of -u & then executes the command. it returns only if that fails, with the exit code that
+// focker should exit with
+func execHelper(args []string) int {
+	var capAdd, capDrop []string
+	noNewPrivileges, unconfined, wait := false, false, false
+	var user *containerUser
+	var ulimits []ulimit
+	for len(args) > 0 && args[0] != "--" {
+		arg := args[0]
+		args = args[1:]
+
+		switch {
+		case strings.HasPrefix(arg, "--cap-add="):
+			capAdd = append(capAdd, strings.TrimPrefix(arg, "--cap-add="))
+		case strings.HasPrefix(arg, "--cap-drop="):
+			capDrop = append(capDrop, strings.TrimPrefix(arg, "--cap-drop="))
+		case arg == "--no-new-privileges":
+			noNewPrivileges = true
+		case arg == "--seccomp=unconfined":
+			unconfined = true
+		case arg == "--wait":
+			wait = true
+		case strings.HasPrefix(arg, "--ulimit="):
+			limit, err := parseUlimit(strings.TrimPrefix(arg, "--ulimit="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "_exec: %v\n", err)
+				return 126
+			}
+
+			ulimits = append(ulimits, limit)
+		case strings.HasPrefix(arg, "--user="):
+			parsed, err := parseHelperUser(strings.TrimPrefix(arg, "--user="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "_exec: %v\n", err)
+				return 126
+			}
+
+			user = &parsed
+		default:
+			fmt.Fprintf(os.Stderr, "_exec: unknown flag %s\n", arg)
+			return 126
+		}
+	}
+
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "_exec: a command is required")
+		return 126
+	}
+
+	path, argv := args[1], args[2:]
+
+	// focker exec leaves it to us to look the command up, since only we see the container's files
+	if !strings.Contains(path, "/") {
+		found, err := exec.LookPath(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, explainLookPathError(path, err))
+			if isExecNotFound(err) {
+				return 127
+			}
+
+			return 126
+		}
+
+		path = found
+	}
+
+	// the bounding set & no_new_privs are per thread, & execve keeps the thread that calls it
+	runtime.LockOSThread()
+	if err := dropCapabilities(containerCapabilities(capAdd, capDrop)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 126
+	}
+
+	if noNewPrivileges {
+		if err := setNoNewPrivileges(); err != nil {
+			fmt.Fprintf(os.Stderr, "no_new_privs: %v\n", err)
+			return 126
+		}
+	}
+
+	// the parent closes the pipe once it has removed what the command mustn't see, i.e. /proc
+	// when it was only mounted for starting us (& for reading cap_last_cap above)
+	if wait {
+		waitPipe := os.NewFile(execWaitFd, "wait-pipe")
+		io.Copy(io.Discard, waitPipe)
+		waitPipe.Close()
+	}
+
+	// everything that we do afterwards goes through the filter too, which allows switching users
+	if !unconfined {
+		if err := installSeccompFilter(); err != nil {
+			fmt.Fprintf(os.Stderr, "seccomp: %v\n", err)
+			return 126
+		}
+	}
+
+	// the limits are set as late as possible, since the threads that we may still need count
+	// towards nproc
+	if err := applyUlimits(ulimits); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 126
+	}
+
+	// this comes last, since the capabilities that the above needs are lost along with root
+	if user != nil {
+		if err := switchUser(*user); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 126
+		}
+	}
+
+	syscall.CloseOnExec(execBinaryFd)
+	err := syscall.Exec(path, argv, os.Environ())
+	if isExecNotFound(err) {
+		// exit with 127 like shells do when a command can't be found
+		fmt.Fprintln(os.Stderr, explainExecNotFound(path))
+		return 127
+	}
+
+	fmt.Fprintf(os.Stderr, "cannot execute %s in container: %v\n", path, err)
+	return 126
+}
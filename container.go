@@ -0,0 +1,411 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// each container gets a directory under containersDir with this layout:
+//
+//	<containerId>/
+//	├── config.json (metadata about the container, see writeContainerConfig)
+//	├── output.log  (the container's stdout & stderr, with --log)
+//	├── rootfs/     (the container's root filesystem, an overlay of the image & upper/)
+//	├── upper/      (the container's changes to the image)
+//	└── work/       (overlayfs' work dir)
+
+const containerConfigFile = "config.json"
+
+type containerConfig struct {
+	Id string `json:"id"`
+
+	// the name given with --name, if any
+	Name string `json:"name,omitempty"`
+
+	// the labels given with -l, key -> value
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// pid of the container's init process (the _child process) on the host
+	Pid int `json:"pid"`
+
+	// pid of the focker run (or _monitor) process that waits for the container's init & cleans
+	// up after it, & when it started (in clock ticks after boot, see proc(5)), since pids get reused
+	ParentPid     int    `json:"parentPid,omitempty"`
+	ParentStarted uint64 `json:"parentStarted,omitempty"`
+
+	State containerState `json:"state"`
+
+	// the command that the container runs & its args
+	Command []string `json:"command"`
+
+	// the extracted image that's the lower layer of the container's rootfs overlay, or its
+	// extracted layers from the top one to the bottom one, separated by colons, or the squashfs
+	// image of --rootfs-squashfs
+	Rootfs string `json:"rootfs,omitempty"`
+
+	Created time.Time `json:"created"`
+
+	Hostname string `json:"hostname"`
+
+	// the directory that the command started in (-w), which is also where exec runs commands
+	Workdir string `json:"workdir,omitempty"`
+
+	// the restrictions of the command, which exec's commands get too: the user (-u), the
+	// capabilities added & dropped (--cap-add & --cap-drop), --no-new-privileges, --seccomp & the
+	// --ulimit limits, in the format of the flags
+	User            string   `json:"user,omitempty"`
+	CapAdd          []string `json:"capAdd,omitempty"`
+	CapDrop         []string `json:"capDrop,omitempty"`
+	NoNewPrivileges bool     `json:"noNewPrivileges,omitempty"`
+	Seccomp         string   `json:"seccomp,omitempty"`
+	Ulimits         []string `json:"ulimits,omitempty"`
+
+	// address of the container on the focker0 bridge, if it's connected to it
+	Ip string `json:"ip,omitempty"`
+
+	// the -p port mappings of the container, in the format of -p
+	Ports []string `json:"ports,omitempty"`
+
+	// the container's bind & tmpfs mounts, in the format of --mount
+	Mounts []string `json:"mounts,omitempty"`
+
+	// the resource limits of the container's cgroup, if it has one
+	Limits *containerLimits `json:"limits,omitempty"`
+
+	// the --health-cmd check of the container & its result, if it has one
+	Health *containerHealth `json:"health,omitempty"`
+
+	// the --restart policy of the container & how many times it has been restarted
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+	RestartCount  int    `json:"restartCount,omitempty"`
+
+	// the code that the container exited with, once focker run has recorded it
+	ExitCode *int `json:"exitCode,omitempty"`
+
+	// the container was stopped with focker stop, so it isn't restarted
+	ManuallyStopped bool `json:"manuallyStopped,omitempty"`
+
+	// when prune removes the container, whatever its state (--ttl)
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// the resource limits of a container as given to run, see the flags of run
+type containerLimits struct {
+	Memory     uint64  `json:"memory,omitempty"`
+	MemoryHigh uint64  `json:"memoryHigh,omitempty"`
+	Cpus       float64 `json:"cpus,omitempty"`
+	Pids       int64   `json:"pids,omitempty"`
+	IoWeight   uint64  `json:"ioWeight,omitempty"`
+
+	// block io limits as <device> <key>=<value>, e.g. "/dev/sda riops=1000" or "/dev/sda rbps=1048576"
+	Io []string `json:"io,omitempty"`
+
+	// the files written with --cgroup-conf
+	CgroupConf map[string]string `json:"cgroupConf,omitempty"`
+}
+
+// how many times createContainerDir tries another id when the directory of one already exists
+const maxContainerIdAttempts = 10
+
+func newContainerId() string {
+	return "b-" + randomString(16)
+}
+
+// createContainerDir picks an id for a new container & creates its directory, with another id if
+// a container with that id already exists, so that two containers never share a directory
+func createContainerDir() (string, error) {
+	return createContainerDirWithIds(newContainerId)
+}
+
+// createContainerDirWithIds is createContainerDir with the ids that newId returns
+func createContainerDirWithIds(newId func() string) (string, error) {
+	for attempt := 0; attempt < maxContainerIdAttempts; attempt++ {
+		containerId := newId()
+		err := os.Mkdir(containerDir(containerId), 0700)
+		if err == nil {
+			return containerId, nil
+		}
+
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("no unused container id after %d attempts", maxContainerIdAttempts)
+}
+
+// resolveContainerId returns the id of the container that ref refers to, which is either its id
+// or its name. names are only unique among running containers, so a name that several containers
+// have refers to the running one, or else to the newest one
+func resolveContainerId(ref string) (string, error) {
+	if _, err := os.Stat(filepath.Join(containerDir(ref), containerConfigFile)); err == nil {
+		return ref, nil
+	}
+
+	files, err := os.ReadDir(containersDir)
+	if err != nil {
+		return "", err
+	}
+
+	var match *containerConfig
+	for _, file := range files {
+		config, err := readContainerConfig(file.Name())
+		if err != nil || config.Name != ref {
+			continue
+		}
+
+		if match == nil || isContainerRunning(config) && !isContainerRunning(match) ||
+			isContainerRunning(config) == isContainerRunning(match) && config.Created.After(match.Created) {
+			match = config
+		}
+	}
+
+	if match == nil {
+		return "", fmt.Errorf("no such container: %s", ref)
+	}
+
+	return match.Id, nil
+}
+
+// checkContainerName checks that name is a valid name for a container. whether another container
+// has it is only checked by claimContainerName
+func checkContainerName(name string) error {
+	if !containerNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid name %q, only letters, digits, _, . & - are allowed (& it must start with a letter or a digit)", name)
+	}
+
+	return nil
+}
+
+// claimContainerName checks that no other container has the name of config, i.e. that none that
+// is running, being set up or about to be restarted has it. with replace, the other containers
+// that have the name are stopped (see stopContainer) & removed instead, after waiting for those
+// that are being set up to start. it's called with lockContainers held until config is written
+func claimContainerName(config *containerConfig, replace bool) error {
+	files, err := os.ReadDir(containersDir)
+	if err != nil {
+		return err
+	}
+
+containers:
+	for _, file := range files {
+		other, err := readContainerConfig(file.Name())
+		if err != nil || other.Id == config.Id || other.Name != config.Name {
+			continue
+		}
+
+		settingUp := other.State == stateCreated && isParentRunning(other)
+		if replace {
+			// e.g. the container of another --replace that took the name just before us. its
+			// focker run would fail halfway through if it was removed now
+			for settingUp {
+				time.Sleep(100 * time.Millisecond)
+				if other, err = readContainerConfig(file.Name()); err != nil {
+					// its setup failed & it was discarded
+					continue containers
+				}
+
+				settingUp = other.State == stateCreated && isParentRunning(other)
+			}
+
+			infof("replacing container %s", other.Id)
+			if err := stopContainer(other.Id, defaultStopTimeout); err != nil {
+				return fmt.Errorf("replace container %s: %w", other.Id, err)
+			}
+
+			if err := removeContainer(other.Id); err != nil {
+				return fmt.Errorf("replace container %s: %w", other.Id, err)
+			}
+
+			continue
+		}
+
+		if isContainerRunning(other) || settingUp || awaitsRestartPolicy(other) && isParentRunning(other) {
+			return fmt.Errorf("the name %s is already used by container %s (see --replace)", config.Name, other.Id)
+		}
+	}
+
+	return nil
+}
+
+// lockContainers takes an exclusive lock on the containers dir, which focker run holds while it
+// gives a container its name (see claimContainerName), & returns the function that releases it
+func lockContainers() (func(), error) {
+	dir, err := os.Open(containersDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, err
+	}
+
+	return func() { dir.Close() }, nil
+}
+
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func containerDir(containerId string) string {
+	return filepath.Join(containersDir, containerId)
+}
+
+func containerLogPath(containerId string) string {
+	return filepath.Join(containerDir(containerId), "output.log")
+}
+
+func containerRootfsDir(containerId string) string {
+	return filepath.Join(containerDir(containerId), "rootfs")
+}
+
+// writeContainerConfig replaces the config.json of a container. other focker processes read it
+// while it's being written (e.g. focker wait every 100ms), so it's written to a temporary file
+// that is then renamed over it, & they always see either the old or the new config in full
+func writeContainerConfig(config *containerConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the temporary file is created with mode 0600
+	file, err := os.CreateTemp(containerDir(config.Id), ".config-*.json")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(containerDir(config.Id), containerConfigFile))
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+func readContainerConfig(containerId string) (*containerConfig, error) {
+	data, err := os.ReadFile(filepath.Join(containerDir(containerId), containerConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such container: %s", containerId)
+		}
+
+		return nil, err
+	}
+
+	var config containerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("bad config of container %s: %w", containerId, err)
+	}
+
+	return &config, nil
+}
+
+// isContainerRunning checks that the container is running (or paused or being stopped) according
+// to its state & that the recorded pid is still alive & is still the _child process of this
+// container, since the state can't be updated if focker gets killed & pids get reused once a
+// process exits
+func isContainerRunning(config *containerConfig) bool {
+	if config.State != stateRunning && config.State != statePaused && config.State != stateStopped {
+		return false
+	}
+
+	if config.Pid <= 0 {
+		return false
+	}
+
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", config.Pid))
+	if err != nil {
+		return false
+	}
+
+	// the cmdline is <focker> _child <containerId> ...
+	args := strings.Split(string(cmdline), "\x00")
+	return len(args) > 2 && args[1] == "_child" && args[2] == config.Id
+}
+
+// isParentRunning tells whether the focker run process of a container is still around, in which
+// case it's the one that records the exit of the container & cleans up after it
+func isParentRunning(config *containerConfig) bool {
+	if config.ParentPid <= 0 {
+		return false
+	}
+
+	started, err := processStartTime(config.ParentPid)
+	return err == nil && started == config.ParentStarted
+}
+
+// processStartTime returns when a process started, in clock ticks after boot (the starttime
+// field of /proc/<pid>/stat), which tells it apart from a later process with the same pid
+func processStartTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// comm may contain spaces & parentheses, see readContainerProcess
+	commEnd := strings.LastIndexByte(string(stat), ')')
+	fields := strings.Fields(string(stat[commEnd+1:]))
+	if commEnd < 0 || len(fields) < 20 {
+		return 0, fmt.Errorf("bad /proc/%d/stat", pid)
+	}
+
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// refreshContainerState marks a container whose process is gone as exited. the state can't be
+// updated by focker itself if it gets killed before the container exits. while focker run is
+// still around, it's about to record the exit itself
+func refreshContainerState(config *containerConfig) {
+	switch config.State {
+	case stateRunning, statePaused, stateStopped:
+		if !isContainerRunning(config) && !isParentRunning(config) {
+			// it's still shown as exited if this fails, & fixed the next time
+			setContainerState(config, stateExited)
+		}
+	}
+}
+
+// readNsPids returns the pids of a process in each pid namespace it belongs to, from the host's
+// namespace to the innermost one (the NSpid line of /proc/<pid>/status)
+func readNsPids(pid int) ([]int, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+
+		var pids []int
+		for _, field := range strings.Fields(strings.TrimPrefix(line, "NSpid:")) {
+			nsPid, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("bad NSpid line in /proc/%d/status: %q", pid, line)
+			}
+
+			pids = append(pids, nsPid)
+		}
+
+		return pids, nil
+	}
+
+	return nil, fmt.Errorf("no NSpid line in /proc/%d/status", pid)
+}
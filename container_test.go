@@ -0,0 +1,234 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// useTempContainersDir points containersDir to an empty temporary dir for the rest of the test
+func useTempContainersDir(t *testing.T) {
+	previous := containersDir
+	containersDir = t.TempDir()
+	t.Cleanup(func() { containersDir = previous })
+}
+
+func TestNewContainerIdsAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100000; i++ {
+		containerId := newContainerId()
+		if seen[containerId] {
+			t.Fatalf("id %s was generated twice", containerId)
+		}
+
+		seen[containerId] = true
+	}
+}
+
+func TestCreateContainerDirRetriesOnCollision(t *testing.T) {
+	useTempContainersDir(t)
+
+	if err := os.Mkdir(containerDir("b-taken"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{"b-taken", "b-taken", "b-free"}
+	attempts := 0
+	containerId, err := createContainerDirWithIds(func() string {
+		attempts++
+		return ids[attempts-1]
+	})
+
+	if err != nil || containerId != "b-free" || attempts != 3 {
+		t.Fatalf("createContainerDirWithIds() = %q, %v after %d attempts, want b-free after 3", containerId, err, attempts)
+	}
+
+	if info, err := os.Stat(containerDir("b-free")); err != nil || !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Errorf("the dir of b-free: %v, %v, want a dir with mode 0700", info, err)
+	}
+}
+
+func TestCreateContainerDirGivesUp(t *testing.T) {
+	useTempContainersDir(t)
+
+	if err := os.Mkdir(containerDir("b-taken"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	_, err := createContainerDirWithIds(func() string {
+		attempts++
+		return "b-taken"
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "no unused container id") || attempts != maxContainerIdAttempts {
+		t.Errorf("createContainerDirWithIds() = %v after %d attempts, want to give up after %d", err, attempts, maxContainerIdAttempts)
+	}
+}
+
+func TestCreateContainerDir(t *testing.T) {
+	useTempContainersDir(t)
+
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		containerId, err := createContainerDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if seen[containerId] || !strings.HasPrefix(containerId, "b-") || len(containerId) != 18 {
+			t.Fatalf("createContainerDir() = %q, want a new id like b-<16 characters>", containerId)
+		}
+
+		seen[containerId] = true
+	}
+}
+
+func TestIsParentRunning(t *testing.T) {
+	started, err := processStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a process that has exited, whose pid may be reused later on
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config containerConfig
+		want   bool
+	}{
+		{containerConfig{ParentPid: os.Getpid(), ParentStarted: started}, true},
+
+		// another process that got our pid
+		{containerConfig{ParentPid: os.Getpid(), ParentStarted: started + 1}, false},
+
+		{containerConfig{ParentPid: exited.Process.Pid, ParentStarted: started}, false},
+
+		// containers created before the parent was recorded
+		{containerConfig{}, false},
+	}
+
+	for _, test := range tests {
+		if got := isParentRunning(&test.config); got != test.want {
+			t.Errorf("isParentRunning(pid %d, started %d) = %v, want %v", test.config.ParentPid, test.config.ParentStarted, got, test.want)
+		}
+	}
+}
+
+func TestRefreshContainerStateLeavesItToTheParent(t *testing.T) {
+	useTempContainersDir(t)
+
+	started, err := processStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the container's init is gone, but focker run (us) hasn't recorded it yet
+	config := &containerConfig{Id: "b-parent", State: stateStopped, ParentPid: os.Getpid(), ParentStarted: started}
+	refreshContainerState(config)
+	if config.State != stateStopped {
+		t.Errorf("state = %s while focker run is still around, want %s", config.State, stateStopped)
+	}
+}
+
+func TestWriteContainerConfigIsAtomic(t *testing.T) {
+	useTempContainersDir(t)
+
+	config := &containerConfig{Id: "b-atomic", State: stateRunning, Command: []string{"/bin/sleep", "60"}}
+	if err := os.Mkdir(containerDir(config.Id), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeContainerConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// a writer like the health checks, while we read the config like focker wait does
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 2000; i++ {
+			config.RestartCount = i
+			if err := writeContainerConfig(config); err != nil {
+				done <- err
+				return
+			}
+		}
+
+		done <- nil
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, _ := os.ReadDir(containerDir(config.Id))
+			if len(entries) != 1 {
+				t.Errorf("the container's dir has %d files, want only its config", len(entries))
+			}
+
+			return
+		default:
+		}
+
+		if _, err := readContainerConfig(config.Id); err != nil {
+			t.Fatalf("readContainerConfig() while it's being written: %v", err)
+		}
+	}
+}
+
+func TestClaimContainerName(t *testing.T) {
+	useTempContainersDir(t)
+
+	// an exited container doesn't keep its name
+	writeTestContainer(t, &containerConfig{Id: "b-exited", Name: "web", State: stateExited})
+	config := &containerConfig{Id: "b-new", Name: "web", State: stateCreated}
+	if err := claimContainerName(config, false); err != nil {
+		t.Errorf("claimContainerName() with an exited container of the name = %v", err)
+	}
+
+	// but one that another focker run is still setting up does
+	parentStarted, err := processStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestContainer(t, &containerConfig{Id: "b-setup", Name: "api", State: stateCreated, ParentPid: os.Getpid(), ParentStarted: parentStarted})
+	config = &containerConfig{Id: "b-new", Name: "api", State: stateCreated}
+	if err := claimContainerName(config, false); err == nil {
+		t.Error("claimContainerName() succeeded while another container with the name is being set up")
+	}
+}
+
+func TestClaimContainerNameReplaces(t *testing.T) {
+	useTempContainersDir(t)
+
+	failed := 1
+	writeTestContainer(t, &containerConfig{Id: "b-old", Name: "web", State: stateExited})
+	writeTestContainer(t, &containerConfig{Id: "b-restarting", Name: "web", State: stateExited, RestartPolicy: "always", ExitCode: &failed})
+	writeTestContainer(t, &containerConfig{Id: "b-other", Name: "api", State: stateExited})
+
+	config := &containerConfig{Id: "b-new", Name: "web", State: stateCreated}
+	if err := claimContainerName(config, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for containerId, removed := range map[string]bool{"b-old": true, "b-restarting": true, "b-other": false} {
+		_, err := os.Stat(containerDir(containerId))
+		if os.IsNotExist(err) != removed {
+			t.Errorf("%s: removed = %v, want %v", containerId, os.IsNotExist(err), removed)
+		}
+	}
+
+	if containerId, err := resolveContainerId("web"); err == nil {
+		t.Errorf("the name web still refers to %s after it was replaced", containerId)
+	}
+}
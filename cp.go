@@ -0,0 +1,319 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// the most symlinks that resolveInRoot follows for a path, like the kernel's limit
+const maxSymlinks = 40
+
+// cp copies a file or directory between the host & a running container & returns the exit code
+// that focker should exit with. args are the source & the destination, one of which is a path in
+// a container as <containerId>:<path>
+func cp(args []string) int {
+	if len(args) != 2 {
+		log.Print("usage: focker cp <src> <containerId>:<dest> | <containerId>:<src> <dest>")
+		return 1
+	}
+
+	srcRef, srcPath, srcInContainer := parseContainerPath(args[0])
+	destRef, destPath, destInContainer := parseContainerPath(args[1])
+	if srcInContainer == destInContainer {
+		log.Print("cp: exactly one of the source & the destination must be <containerId>:<path>")
+		return 1
+	}
+
+	ref := srcRef
+	if destInContainer {
+		ref = destRef
+	}
+
+	config, err := runningContainer(ref)
+	if err != nil {
+		log.Printf("cp: %v", err)
+		return 1
+	}
+
+	// the rootfs (& the container's mounts) are only mounted in the container's mount namespace,
+	// so the host sees them through the root of its init rather than containers/<id>/rootfs
+	root := fmt.Sprintf("/proc/%d/root", config.Pid)
+	if destInContainer {
+		var uid, gid int
+		uid, gid, err = containerRootOwner(config.Pid)
+		if err == nil {
+			err = copyIntoContainer(args[0], root, destPath, uid, gid)
+		}
+	} else {
+		err = copyFromContainer(root, srcPath, args[1])
+	}
+
+	if err != nil {
+		log.Printf("cp: %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+// parseContainerPath splits arg of cp into a container & a path in it, if it's <containerId>:<path>.
+// like docker, anything with a slash before the first colon is a path on the host, so e.g.
+// ./a:b can be used for a host file with a colon in its name
+func parseContainerPath(arg string) (string, string, bool) {
+	ref, path, ok := strings.Cut(arg, ":")
+	if !ok || len(ref) == 0 || strings.ContainsRune(ref, '/') {
+		return "", "", false
+	}
+
+	return ref, path, true
+}
+
+// runningContainer returns the config of a container that's running
+func runningContainer(ref string) (*containerConfig, error) {
+	containerId, err := resolveContainerId(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := readContainerConfig(containerId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isContainerRunning(config) {
+		return nil, fmt.Errorf("container %s is not running", containerId)
+	}
+
+	return config, nil
+}
+
+// resolveInRoot returns the path on the host of path in the container whose root is root,
+// following symlinks like the container would, i.e. relative to its root. .. can't go above the
+// root, so neither a crafted path nor a symlink in the container can lead out of it. the path
+// doesn't have to exist
+func resolveInRoot(root string, path string) (string, error) {
+	resolved := "/"
+	remaining := path
+	links := 0
+	for len(remaining) > 0 {
+		var part string
+		part, remaining, _ = strings.Cut(remaining, "/")
+		if part == "" || part == "." {
+			continue
+		}
+
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		hostPath := filepath.Join(root, next)
+		info, err := os.Lstat(hostPath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("%s: too many levels of symbolic links", path)
+		}
+
+		target, err := os.Readlink(hostPath)
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+
+		remaining = target + "/" + remaining
+	}
+
+	return filepath.Join(root, resolved), nil
+}
+
+// copyIntoContainer copies src from the host to dest in the container whose root is root. the
+// copied files are owned by uid & gid, i.e. by root of the container (see containerRootOwner), so
+// with a user namespace they're owned by the host ids that root in the container is mapped to
+func copyIntoContainer(src string, root string, dest string, uid int, gid int) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	dest = copyDestination(dest, srcInfo.Name(), func(path string) (fs.FileInfo, error) {
+		hostPath, err := resolveInRoot(root, path)
+		if err != nil {
+			return nil, err
+		}
+
+		return os.Stat(hostPath)
+	})
+
+	// every path is resolved on its own, since the directory that's copied into can already
+	// have symlinks in it. a file that's a symlink itself is replaced rather than followed
+	return copyTree(src, func(rel string) (string, error) {
+		path := filepath.Join(dest, rel)
+		dir, err := resolveInRoot(root, filepath.Dir(path))
+		if err != nil {
+			return "", err
+		}
+
+		return filepath.Join(dir, filepath.Base(path)), nil
+	}, uid, gid)
+}
+
+// copyFromContainer copies src in the container whose root is root to dest on the host. the
+// copied files are owned by us
+func copyFromContainer(root string, src string, dest string) error {
+	hostSrc, err := resolveInRoot(root, src)
+	if err != nil {
+		return err
+	}
+
+	srcInfo, err := os.Stat(hostSrc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, errors.Unwrap(err))
+	}
+
+	dest = copyDestination(dest, srcInfo.Name(), os.Stat)
+
+	// the tree is walked without following symlinks, so what's under hostSrc stays in the container
+	return copyTree(hostSrc, func(rel string) (string, error) {
+		return filepath.Join(dest, rel), nil
+	}, -1, -1)
+}
+
+// copyDestination returns the path that a file or directory called name is copied to when it's
+// copied to dest: into dest if it's an existing directory, or else to dest itself
+func copyDestination(dest string, name string, stat func(string) (fs.FileInfo, error)) string {
+	if info, err := stat(dest); err == nil && info.IsDir() {
+		return filepath.Join(dest, name)
+	}
+
+	return dest
+}
+
+// copyTree copies the file or directory src to the path that target returns for each path
+// relative to src, with the permissions of the originals. symlinks are copied as they are, &
+// other special files are skipped. the copies are owned by uid & gid, or by us if they're -1
+func copyTree(src string, target func(rel string) (string, error), uid int, gid int) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		dest, err := target(rel)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			// an existing directory is copied into. it's checked without following symlinks,
+			// since chmod would follow one to wherever it points to
+			err = os.Mkdir(dest, 0700)
+			if os.IsExist(err) {
+				if existing, statErr := os.Lstat(dest); statErr == nil && existing.IsDir() {
+					err = nil
+				}
+			}
+		case mode.IsRegular():
+			err = copyFile(path, dest)
+		case mode&fs.ModeSymlink != 0:
+			err = copySymlink(path, dest)
+		default:
+			log.Printf("cp: skipping %s, it's not a regular file, a directory or a symlink", path)
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if uid >= 0 {
+			if err := os.Lchown(dest, uid, gid); err != nil {
+				return err
+			}
+		}
+
+		if mode&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		// chown clears the setuid & setgid bits, so this comes after it
+		return os.Chmod(dest, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+	})
+}
+
+// copyFile copies the contents of the regular file src to dest, replacing dest if it exists.
+// dest isn't followed if it's a symlink
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if info, err := os.Lstat(dest); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// copySymlink creates a symlink at dest with the target of the symlink src, replacing dest if it
+// exists & isn't a directory
+func copySymlink(src string, dest string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(dest); err == nil && !info.IsDir() {
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+
+	return os.Symlink(target, dest)
+}
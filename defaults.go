@@ -0,0 +1,104 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// the file in the current directory that run reads its defaults from, unless --config is given
+const defaultsFile = ".focker.json"
+
+// the defaults of run's flags, from defaultsFile or --config. the flags given to run override them
+type runDefaults struct {
+	// an image like run's image argument, used when run isn't given one
+	Image string `json:"image,omitempty"`
+
+	// like -v, a volume of the same target given to run replaces the one from here
+	Volumes []string `json:"volumes,omitempty"`
+
+	// like -e, a variable of the same name given to run replaces the one from here
+	Env []string `json:"env,omitempty"`
+
+	// like -m
+	Memory string `json:"memory,omitempty"`
+}
+
+// configFlag returns the value of --config among the flags of run, which come before the first
+// argument that isn't a flag or --
+func configFlag(flagArgs []string) string {
+	path := ""
+	for _, arg := range flagArgs {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+
+		if value, ok := strings.CutPrefix(arg, "--config="); ok {
+			path = value
+		}
+	}
+
+	return path
+}
+
+// readRunDefaults reads the defaults of run from path, or from defaultsFile if path is empty, in
+// which case it's fine for the file not to exist. unknown keys are an error, since they're most
+// likely typos
+func readRunDefaults(path string) (*runDefaults, error) {
+	explicit := len(path) > 0
+	if !explicit {
+		path = defaultsFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return &runDefaults{}, nil
+		}
+
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var defaults runDefaults
+	if err := decoder.Decode(&defaults); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &defaults, nil
+}
+
+// apply sets the options to the defaults, before run's flags are parsed into them
+func (defaults *runDefaults) apply(options *runOptions) error {
+	for _, spec := range defaults.Volumes {
+		volume, err := parseVolumeSpec(spec)
+		if err != nil {
+			return err
+		}
+
+		options.mounts = append(options.mounts, volume)
+	}
+
+	for _, spec := range defaults.Env {
+		if v, ok := parseEnvVar(spec); ok {
+			options.env = append(options.env, v)
+		}
+	}
+
+	if len(defaults.Memory) > 0 {
+		memory, err := parseMemorySize(defaults.Memory)
+		if err != nil {
+			return fmt.Errorf("memory: %w", err)
+		}
+
+		options.memoryMax = memory
+	}
+
+	return nil
+}
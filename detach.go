@@ -0,0 +1,129 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// the fd of the pipe over which the _monitor process tells run -d that the container is running
+const monitorReadyFd = 3
+
+// runDetached starts a container in the background & returns the exit code that focker should
+// exit with. flagArgs are the arguments of run.
+//
+// the container still needs a parent that waits for it & cleans up after it (its state, mounts,
+// network & cgroup), so run -d starts `focker _monitor <containerId> <flagArgs>` in a new session,
+// which runs the container like run does. its stdio (& so the container's) goes to the container's
+// log file. run -d only waits until the container is running & then prints its id & exits, or with
+// --wait-ready, shows the container's output until it's ready first, see waitUntilReady
+func runDetached(flagArgs []string, options runOptions) int {
+	containerId, err := createContainerDir()
+	exitIfError(err, "mkdir container dir")
+
+	// it's also read from if the container fails to start, by which time the monitor has removed
+	// the container & its log file
+	logFile, err := os.OpenFile(containerLogPath(containerId), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	exitIfError(err, "open log file")
+	defer logFile.Close()
+
+	readyPipe, monitorReadyPipe, err := os.Pipe()
+	exitIfError(err, "os.Pipe()")
+	defer readyPipe.Close()
+
+	path, err := os.Executable()
+	exitIfError(err, "os.Executable()")
+
+	monitor := exec.Command(path, append([]string{"_monitor", containerId}, flagArgs...)...)
+	monitor.Stdout, monitor.Stderr = logFile, logFile
+	monitor.ExtraFiles = []*os.File{monitorReadyPipe}
+	monitor.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	exitIfError(monitor.Start(), "start monitor")
+	monitorReadyPipe.Close()
+
+	// we get EOF without the byte if the monitor exits before the container is running
+	if n, _ := readyPipe.Read(make([]byte, 1)); n == 1 {
+		monitor.Process.Release()
+
+		code := 0
+		if options.waitReady {
+			code = waitUntilReady(containerId, len(options.healthCmd) > 0)
+		}
+
+		fmt.Println(containerId)
+		return code
+	}
+
+	monitor.Wait()
+
+	// the log has the reason
+	log.Printf("container %s failed to start:", containerId)
+	if _, err := logFile.Seek(0, io.SeekStart); err == nil {
+		io.Copy(os.Stderr, logFile)
+	}
+
+	// nothing else is left of the container if it failed before its config was written
+	if _, err := os.Stat(filepath.Join(containerDir(containerId), containerConfigFile)); os.IsNotExist(err) {
+		os.RemoveAll(containerDir(containerId))
+	}
+
+	return 1
+}
+
+// notifyDetachedRunning tells run -d that the container is running, see runDetached
+func notifyDetachedRunning() {
+	readyPipe := os.NewFile(monitorReadyFd, "monitor-ready-pipe")
+	readyPipe.Write([]byte{0})
+	readyPipe.Close()
+}
+
+// waitUntilReady copies the container's output from its log file to stderr (stdout is for its id)
+// until the container is ready, & returns the exit code that run -d --wait-ready should exit with.
+// the container is ready once it's running, which run -d has already waited for, or with
+// --health-cmd, once its first check passed. it fails if the container exits or becomes unhealthy
+// before that, in which case the container is left as it is
+func waitUntilReady(containerId string, healthCheck bool) int {
+	// the log file of runDetached shares its offset with the monitor's stdout & stderr, which is
+	// always at the end
+	logFile, err := os.Open(containerLogPath(containerId))
+	if err != nil {
+		log.Printf("--wait-ready: %v", err)
+		return 1
+	}
+	defer logFile.Close()
+
+	for {
+		// check this before copying so that the output from right before the container became
+		// ready or exited is shown too
+		// with --rm, nothing is left of the container once it has exited
+		config, err := readContainerConfig(containerId)
+		running := err == nil && isContainerRunning(config)
+
+		if _, err := io.Copy(os.Stderr, logFile); err != nil {
+			log.Printf("--wait-ready: %v", err)
+			return 1
+		}
+
+		switch {
+		case !healthCheck:
+			return 0
+		case !running:
+			log.Printf("container %s exited before it was ready", containerId)
+			return 1
+		case config.Health != nil && config.Health.Status == healthHealthy:
+			return 0
+		case config.Health != nil && config.Health.Status == healthUnhealthy:
+			log.Printf("container %s is unhealthy", containerId)
+			return 1
+		}
+
+		time.Sleep(logsFollowInterval)
+	}
+}
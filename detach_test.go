@@ -0,0 +1,72 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// startFakeChild starts a process whose cmdline is that of the _child process of a container, so
+// that isContainerRunning takes the container for a running one
+func startFakeChild(t *testing.T, containerId string) int {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "_child"), []byte("sleep 60\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	child := exec.Command("sh", "_child", containerId)
+	child.Dir = dir
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		child.Process.Kill()
+		child.Wait()
+	})
+
+	return child.Process.Pid
+}
+
+func TestWaitUntilReady(t *testing.T) {
+	useTempContainersDir(t)
+
+	tests := []struct {
+		name        string
+		running     bool
+		health      healthStatus
+		healthCheck bool
+		want        int
+	}{
+		{name: "healthy", running: true, health: healthHealthy, healthCheck: true, want: 0},
+		{name: "unhealthy", running: true, health: healthUnhealthy, healthCheck: true, want: 1},
+		{name: "exited", health: healthStarting, healthCheck: true, want: 1},
+
+		// it was ready once it was running, which run -d waits for
+		{name: "no-check", want: 0},
+	}
+
+	for _, test := range tests {
+		config := &containerConfig{Id: "b-" + test.name, State: stateExited}
+		if test.running {
+			config.State = stateRunning
+			config.Pid = startFakeChild(t, config.Id)
+		}
+
+		if test.healthCheck {
+			config.Health = &containerHealth{Command: "true", Status: test.health}
+		}
+
+		writeTestContainer(t, config)
+		if err := os.WriteFile(containerLogPath(config.Id), []byte("starting\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := waitUntilReady(config.Id, test.healthCheck); got != test.want {
+			t.Errorf("%s: waitUntilReady() = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
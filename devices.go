@@ -0,0 +1,170 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// the host devices that every container gets in its minimal /dev
+var standardDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// a host device that's exposed to the container with --device
+type deviceSpec struct {
+	// path of the device on the host
+	hostPath string
+
+	// path of the device in the container, the same as on the host by default
+	target string
+}
+
+// parseDeviceSpec parses the value of --device, i.e. <hostPath>[:<containerPath>]
+func parseDeviceSpec(spec string) (deviceSpec, error) {
+	hostPath, target, hasTarget := strings.Cut(spec, ":")
+	if !hasTarget {
+		target = hostPath
+	}
+
+	if !filepath.IsAbs(hostPath) || !filepath.IsAbs(target) {
+		return deviceSpec{}, fmt.Errorf("invalid device %q, expected <hostPath>[:<containerPath>] with absolute paths", spec)
+	}
+
+	if err := checkMountTarget(target); err != nil {
+		return deviceSpec{}, err
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(hostPath, &stat); err != nil {
+		return deviceSpec{}, fmt.Errorf("%s: %w", hostPath, err)
+	}
+
+	if !isDevice(stat.Mode) {
+		return deviceSpec{}, fmt.Errorf("%s is not a character or block device", hostPath)
+	}
+
+	return deviceSpec{hostPath: filepath.Clean(hostPath), target: filepath.Clean(target)}, nil
+}
+
+// String formats the device for --device, e.g. for the child
+func (device deviceSpec) String() string {
+	return device.hostPath + ":" + device.target
+}
+
+// isDevice tells whether mode (the st_mode of stat(2)) is that of a character or block device
+func isDevice(mode uint32) bool {
+	return mode&syscall.S_IFMT == syscall.S_IFCHR || mode&syscall.S_IFMT == syscall.S_IFBLK
+}
+
+// mountMinimalDev mounts a tmpfs at /dev in the rootfs with just the basic devices & the usual
+// symlinks. the devices are bind mounted from the host's /dev if they can't be created, so this
+// must be called before pivot_root
+func mountMinimalDev(rootfsDir string, rootId int) error {
+	devDir, err := resolveInRoot(rootfsDir, "/dev")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(devDir, 0755); err != nil {
+		return err
+	}
+
+	if err := syscall.Mount("tmpfs", devDir, "tmpfs", syscall.MS_NOSUID, "mode=755,size=64k"); err != nil {
+		return fmt.Errorf("mount tmpfs on /dev: %w", err)
+	}
+
+	if err := os.Chown(devDir, rootId, rootId); err != nil {
+		return err
+	}
+
+	for _, device := range standardDevices {
+		if err := createDevice(filepath.Join("/dev", device), filepath.Join(devDir, device), rootId); err != nil {
+			return fmt.Errorf("/dev/%s: %w", device, err)
+		}
+	}
+
+	symlinks := map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	}
+
+	for name, target := range symlinks {
+		link := filepath.Join(devDir, name)
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+
+		if err := os.Lchown(link, rootId, rootId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createDevices creates the --device devices in the rootfs. like mountMinimalDev, it must be
+// called before pivot_root
+func createDevices(rootfsDir string, devices []deviceSpec, rootId int) error {
+	for _, device := range devices {
+		target, err := resolveInRoot(rootfsDir, device.target)
+		if err != nil {
+			return fmt.Errorf("--device %s: %w", device, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		if err := createDevice(device.hostPath, target, rootId); err != nil {
+			return fmt.Errorf("--device %s: %w", device, err)
+		}
+	}
+
+	return nil
+}
+
+// createDevice creates a device node at target with the type, number & permissions of the host's
+// device at hostPath, owned by root of the container. mknod isn't allowed everywhere (e.g. in a
+// user namespace), so the host's device is bind mounted at target instead if it fails with EPERM,
+// in which case it keeps its owner on the host
+func createDevice(hostPath string, target string, rootId int) error {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(hostPath, &stat); err != nil {
+		return err
+	}
+
+	if !isDevice(stat.Mode) {
+		return fmt.Errorf("%s is not a character or block device", hostPath)
+	}
+
+	err := syscall.Mknod(target, stat.Mode, int(stat.Rdev))
+	if err == nil {
+		if err := os.Chown(target, rootId, rootId); err != nil {
+			return err
+		}
+
+		// mknod applies the umask
+		return os.Chmod(target, os.FileMode(stat.Mode&0777))
+	}
+
+	if !errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("mknod %s: %w", target, err)
+	}
+
+	// a bind mount needs an existing file to be mounted on
+	if err := os.WriteFile(target, nil, 0666); err != nil {
+		return err
+	}
+
+	if err := syscall.Mount(hostPath, target, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount %s: %w", hostPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// the nameserver of containers when neither --dns nor the host's /etc/resolv.conf has a usable one
+const defaultNameserver = "8.8.8.8"
+
+// containerResolvConf returns the /etc/resolv.conf of the container, which has the nameservers of
+// --dns if given, or else the host's. the host's search & options lines are kept. it reads the
+// host's /etc/resolv.conf, so it has to be called before pivot_root.
+//
+// nameservers on the loopback (like systemd-resolved's 127.0.0.53) only work with --net=host,
+// since they're unreachable from the container's own network namespace
+func containerResolvConf(dns []string, hostNet bool) string {
+	var nameservers, others []string
+	if file, err := os.Open("/etc/resolv.conf"); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch fields[0] {
+			case "nameserver":
+				ip := net.ParseIP(fields[len(fields)-1])
+				if ip != nil && (hostNet || !ip.IsLoopback()) {
+					nameservers = append(nameservers, ip.String())
+				}
+			case "search", "domain", "options":
+				others = append(others, strings.Join(fields, " "))
+			}
+		}
+
+		file.Close()
+	}
+
+	if len(dns) > 0 {
+		nameservers = dns
+	} else if len(nameservers) == 0 {
+		nameservers = []string{defaultNameserver}
+	}
+
+	var conf strings.Builder
+	for _, nameserver := range nameservers {
+		fmt.Fprintf(&conf, "nameserver %s\n", nameserver)
+	}
+
+	for _, line := range others {
+		fmt.Fprintln(&conf, line)
+	}
+
+	return conf.String()
+}
+
+// containerHosts returns the /etc/hosts of the container, which has localhost & the container's
+// hostname. the hostname resolves to the container's address on the bridge if it has one, or else
+// to 127.0.1.1 like on debian
+func containerHosts(hostname string, ip string) string {
+	if len(ip) == 0 {
+		ip = "127.0.1.1"
+	}
+
+	return fmt.Sprintf("127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n%s\t%s\n", ip, hostname)
+}
+
+// writeEtcFile replaces a file in the container's /etc with content, owned by root of the
+// container. it's removed first since images can have it as a symlink, e.g. to systemd-resolved's
+// stub-resolv.conf. it must be called after pivot_root
+func writeEtcFile(name string, content string, rootId int) error {
+	if err := os.MkdirAll("/etc", 0755); err != nil {
+		return err
+	}
+
+	path := "/etc/" + name
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+
+	return os.Chown(path, rootId, rootId)
+}
+
+// checkDns checks that a --dns value is an ip address
+func checkDns(nameserver string) error {
+	if net.ParseIP(nameserver) == nil {
+		return fmt.Errorf("invalid nameserver %q, expected an ip address", nameserver)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// the PATH of the container unless -e sets another one, the same as docker's default
+const defaultContainerPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// parseEnvVar parses the value of -e, either KEY=VALUE or KEY to take the value from our own
+// environment. ok is false if it's KEY & KEY isn't set, in which case the variable is left out
+func parseEnvVar(spec string) (string, bool) {
+	if strings.Contains(spec, "=") {
+		return spec, true
+	}
+
+	value, ok := os.LookupEnv(spec)
+	if !ok {
+		return "", false
+	}
+
+	return spec + "=" + value, true
+}
+
+// containerEnv returns the environment of the command run in the container. the host's environment
+// isn't passed through since it describes the host (like its PATH & HOME), only TERM is so that
+// interactive programs work. the -e variables come last & override the defaults
+func containerEnv(hostname string, vars []string) []string {
+	env := []string{"PATH=" + defaultContainerPath, "HOME=/root", "HOSTNAME=" + hostname}
+	if term, ok := os.LookupEnv("TERM"); ok {
+		env = append(env, "TERM="+term)
+	}
+
+	for _, v := range vars {
+		key, _, _ := strings.Cut(v, "=")
+
+		replaced := false
+		for i := range env {
+			if strings.HasPrefix(env[i], key+"=") {
+				env[i] = v
+				replaced = true
+				break
+			}
+		}
+
+		if !replaced {
+			env = append(env, v)
+		}
+	}
+
+	return env
+}
+
+// lookupEnv returns the value of key in env, which is in the KEY=VALUE form of os.Environ
+func lookupEnv(env []string, key string) string {
+	for _, v := range env {
+		if value, ok := strings.CutPrefix(v, key+"="); ok {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,216 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// execInContainer runs a command in a running container & returns its exit code. args are the
+// -e & -w flags, the container id & the command with its args. without -w, the command runs in
+// the working directory of the container's command.
+//
+// the go runtime is multi-threaded, so we can't setns into the container's mount namespace
+// ourselves (the kernel only allows that for single-threaded processes). instead, nsenter joins
+// the namespaces of the container's init & runs the command there
+func execInContainer(args []string) int {
+	var env []string
+	var workdir string
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch arg := args[0]; {
+		case strings.HasPrefix(arg, "-e="):
+			if v, ok := parseEnvVar(strings.TrimPrefix(arg, "-e=")); ok {
+				env = append(env, v)
+			}
+		case strings.HasPrefix(arg, "-w="), strings.HasPrefix(arg, "--workdir="):
+			_, workdir, _ = strings.Cut(arg, "=")
+			if !filepath.IsAbs(workdir) {
+				log.Fatalf("exec: -w: the working directory must be an absolute path: %s", workdir)
+			}
+		default:
+			log.Fatalf("exec: unknown flag %s", arg)
+		}
+
+		args = args[1:]
+	}
+
+	if len(args) < 2 {
+		log.Fatal("usage: focker exec [-e=KEY=VALUE]... [-w=<dir>] <containerId> <command> [args...]")
+	}
+
+	containerId, err := resolveContainerId(args[0])
+	exitIfError(err, "exec")
+	command := args[1:]
+
+	config, err := readContainerConfig(containerId)
+	exitIfError(err, "exec")
+	if !isContainerRunning(config) {
+		log.Fatalf("exec: container %s is not running", containerId)
+	}
+
+	if len(workdir) == 0 {
+		workdir = config.Workdir
+	}
+
+	cmd, err := containerCommand(config, env, workdir, command)
+	exitIfError(err, "exec")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// the command has to be in the container's cgroup, along with anything it forks. nsenter
+	// forks as soon as it starts, so we join the cgroup ourselves & nsenter inherits it
+	if _, err := os.Stat(containerCgroupDir(containerId)); err == nil {
+		exitIfError(addToContainerCgroup(containerId, os.Getpid()), "exec: join cgroup")
+	}
+
+	if err := cmd.Run(); err != nil {
+		if cmd.ProcessState == nil {
+			log.Fatalf("exec: %v", err)
+		}
+	}
+
+	return cmd.ProcessState.ExitCode()
+}
+
+// containerCommand returns an nsenter command that runs command in the namespaces of a running
+// container, with the container's environment & the -e variables in env, in the directory workdir
+// of the container (unless it's empty, e.g. for containers created before it was recorded).
+//
+// the command is started through the _exec helper like the container's own command, so that it
+// gets the same restrictions (capabilities, no_new_privs, the seccomp filter, the limits of
+// --ulimit & the user of -u) rather than those of nsenter, i.e. of root on the host
+func containerCommand(config *containerConfig, env []string, workdir string, command []string) (*exec.Cmd, error) {
+	nsenterArgs := []string{
+		"--target", strconv.Itoa(config.Pid),
+		"--mount", "--uts", "--ipc", "--net", "--pid", "--cgroup",
+	}
+
+	// nsenter opens the directory before it enters the mount namespace, so it's given as a path
+	// under the container's root on the host, with the container's symlinks resolved like cp does
+	root := fmt.Sprintf("/proc/%d/root", config.Pid)
+	if len(workdir) > 0 {
+		dir, err := resolveInRoot(root, workdir)
+		if err != nil {
+			return nil, err
+		}
+
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid working directory %s in container", workdir)
+		}
+
+		nsenterArgs = append(nsenterArgs, "--wd="+dir)
+	}
+
+	// the container's init isn't in the user namespace of the command, so look for the command
+	userns, err := findContainerUserns(config.Pid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(userns) > 0 {
+		nsenterArgs = append(nsenterArgs, "--user="+userns)
+	}
+
+	options, err := config.execOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.user) > 0 {
+		user, err := lookupContainerUser(root, options.user)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user %s in container: %w", options.user, err)
+		}
+
+		options.user = user.String()
+
+		// -e can still override it
+		env = append([]string{"HOME=" + user.home}, env...)
+	}
+
+	// only root of the host's user namespace can raise a hard limit, so we do it for the helper
+	// like the container's init does, see raiseUlimits
+	if err := raiseUlimits(options.ulimits); err != nil {
+		return nil, fmt.Errorf("--ulimit: %w", err)
+	}
+
+	// our binary isn't reachable by a path in the container's mount namespace, so nsenter gets it
+	// as an fd & executes it through the container's /proc
+	if !hasProcfs(filepath.Join(root, "proc")) {
+		return nil, fmt.Errorf("container %s has no /proc (--no-proc), which is needed to start commands in it", config.Id)
+	}
+
+	binary, err := fockerBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// the helper looks the command up in the container's PATH, see execHelper
+	helper := execHelperCommand(command[0], command, options)
+	nsenterArgs = append(nsenterArgs, "--", fmt.Sprintf("/proc/self/fd/%d", execBinaryFd))
+	nsenterArgs = append(nsenterArgs, helper.Args[1:]...)
+
+	cmd := exec.Command("nsenter", nsenterArgs...)
+	cmd.ExtraFiles = []*os.File{binary}
+	cmd.Env = containerEnv(config.Hostname, env)
+
+	return cmd, nil
+}
+
+// execOptions returns the restrictions of a container's command that are recorded in its config
+// as the options that execHelperCommand takes. the user isn't resolved yet
+func (config *containerConfig) execOptions() (runOptions, error) {
+	options := runOptions{
+		user:            config.User,
+		capAdd:          config.CapAdd,
+		capDrop:         config.CapDrop,
+		noNewPrivileges: config.NoNewPrivileges,
+		seccomp:         config.Seccomp,
+	}
+
+	for _, spec := range config.Ulimits {
+		limit, err := parseUlimit(spec)
+		if err != nil {
+			return runOptions{}, err
+		}
+
+		options.ulimits = append(options.ulimits, limit)
+	}
+
+	return options, nil
+}
+
+// fockerBinary returns our own binary, which is opened once & kept open for the commands of
+// containerCommand. it's the running binary even if it has been replaced on disk since
+var fockerBinary = sync.OnceValues(func() (*os.File, error) {
+	return os.Open("/proc/self/exe")
+})
+
+// PROC_SUPER_MAGIC from <linux/magic.h>, the f_type of procfs in statfs(2)
+const procSuperMagic = 0x9fa0
+
+// hasProcfs tells whether a procfs is mounted at dir
+func hasProcfs(dir string) bool {
+	var stat syscall.Statfs_t
+	return syscall.Statfs(dir, &stat) == nil && stat.Type == procSuperMagic
+}
+
+// findContainerUserns returns the path of the user namespace of the container's processes, or an
+// empty string if they're in the same user namespace as its init (--userns=host)
+func findContainerUserns(initPid int) (string, error) {
+	pid, err := findContainerUsernsPid(initPid)
+	if err != nil || pid == 0 {
+		return "", err
+	}
+
+	return fmt.Sprintf("/proc/%d/ns/user", pid), nil
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExecOptions(t *testing.T) {
+	config := &containerConfig{
+		User:            "app",
+		CapAdd:          []string{"NET_ADMIN"},
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		Seccomp:         "unconfined",
+		Ulimits:         []string{"nofile=512:1024"},
+	}
+
+	options, err := config.execOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what exec passes to the _exec helper is what focker run passed to it for the command
+	limit, _ := parseUlimit("nofile=512:1024")
+	want := execHelperCommand("/bin/sh", []string{"sh"}, runOptions{
+		user:            "app",
+		capAdd:          []string{"NET_ADMIN"},
+		capDrop:         []string{"ALL"},
+		noNewPrivileges: true,
+		seccomp:         "unconfined",
+		ulimits:         []ulimit{limit},
+	})
+
+	if got := execHelperCommand("/bin/sh", []string{"sh"}, options); !reflect.DeepEqual(got.Args, want.Args) {
+		t.Errorf("execHelperCommand() with execOptions() = %q, want %q", got.Args, want.Args)
+	}
+
+	config.Ulimits = []string{"nofile"}
+	if _, err := config.execOptions(); err == nil {
+		t.Error("execOptions() succeeded with an invalid ulimit")
+	}
+}
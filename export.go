@@ -0,0 +1,271 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// export writes the root filesystem of a container as a tarball & returns the exit code that focker
+// should exit with. args are the container id, along with -o <file> (or -o=<file>) to write it to
+// a file instead of stdout
+func export(args []string) int {
+	var output string
+	var refs []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-o" || arg == "--output":
+			if i+1 == len(args) {
+				log.Printf("export: %s requires a file", arg)
+				return 1
+			}
+
+			i++
+			output = args[i]
+		case strings.HasPrefix(arg, "-o="), strings.HasPrefix(arg, "--output="):
+			_, output, _ = strings.Cut(arg, "=")
+		case strings.HasPrefix(arg, "-"):
+			log.Printf("export: unknown flag %s", arg)
+			return 1
+		default:
+			refs = append(refs, arg)
+		}
+	}
+
+	if len(refs) != 1 {
+		log.Print("usage: focker export <containerId> [-o <file>]")
+		return 1
+	}
+
+	containerId, err := resolveContainerId(refs[0])
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	config, err := readContainerConfig(containerId)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	out := os.Stdout
+	if len(output) > 0 {
+		out, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Printf("export: %v", err)
+			return 1
+		}
+	} else if isTerminal(out) {
+		log.Print("export: refusing to write a tarball to a terminal, redirect stdout or use -o")
+		return 1
+	}
+
+	err = exportContainer(config, out)
+	if closeErr := out.Close(); err == nil && len(output) > 0 {
+		err = closeErr
+	}
+
+	if err != nil {
+		log.Printf("export: %v", err)
+		if len(output) > 0 {
+			os.Remove(output)
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+// exportContainer writes the root filesystem of a container to w as a tarball. a running
+// container's is read through the root of its init, since its overlay is only mounted in its
+// mount namespace, & that of a container that isn't running is mounted again in a mount namespace
+// of our own. the mounts in the container (volumes, secrets, /proc etc.) are left out, except for
+// the directories they're mounted on
+func exportContainer(config *containerConfig, w io.Writer) error {
+	var root string
+	var mountPoints []string
+	if isContainerRunning(config) {
+		// with the slash, the walk follows the symlink rather than just writing it
+		root = fmt.Sprintf("/proc/%d/root/", config.Pid)
+
+		var err error
+		mountPoints, err = readMountPoints(fmt.Sprintf("/proc/%d/mountinfo", config.Pid), "/")
+		if err != nil {
+			return fmt.Errorf("list mounts of container %s: %w", config.Id, err)
+		}
+	} else {
+		if len(config.Rootfs) == 0 {
+			return fmt.Errorf("container %s doesn't record its image, so it can only be exported while it's running", config.Id)
+		}
+
+		// the mount namespace is only unshared for this thread, which is the one that does all
+		// of the reading. it's never unlocked, so it exits along with us
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+			return fmt.Errorf("unshare mount namespace: %w", err)
+		}
+
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("make mounts private: %w", err)
+		}
+
+		lowerDirs := strings.Split(config.Rootfs, ":")
+		if info, err := os.Stat(config.Rootfs); err == nil && info.Mode().IsRegular() {
+			// a squashfs image, which goes away along with the mount namespace
+			if err := mountSquashfs(config.Rootfs, containerSquashfsDir(config.Id)); err != nil {
+				return fmt.Errorf("mount squashfs: %w", err)
+			}
+
+			lowerDirs = []string{containerSquashfsDir(config.Id)}
+		}
+
+		if err := mountRootfs(config.Id, lowerDirs); err != nil {
+			return fmt.Errorf("mount rootfs: %w", err)
+		}
+
+		root = containerRootfsDir(config.Id)
+	}
+
+	mounted := map[string]bool{}
+	for _, mountPoint := range mountPoints {
+		if mountPoint != "/" {
+			mounted[strings.TrimPrefix(mountPoint, "/")] = true
+		}
+	}
+
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+
+	// with a user namespace, the files are owned by the container's mapped ids, but the tarball
+	// has the ids as the container sees them. ids that aren't mapped are nobody in the container
+	rootId := int(rootInfo.Sys().(*syscall.Stat_t).Uid)
+	containerIdOf := func(hostId int) int {
+		if rootId == 0 {
+			return hostId
+		}
+
+		if hostId >= rootId && hostId < rootId+usernsSize {
+			return hostId - rootId
+		}
+
+		return 65534
+	}
+
+	tw := tar.NewWriter(w)
+
+	// the first path of each inode with several links, the others are written as hard links to it
+	type inode struct{ dev, ino uint64 }
+	links := map[inode]string{}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		// pivot_root's leftover, in case a container didn't get to remove it
+		if rel == ".put_old" {
+			return fs.SkipDir
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		// a file that's mounted is left out entirely, since it's e.g. a secret from the host
+		if info.Mode()&fs.ModeSocket != 0 || (mounted[rel] && !info.IsDir()) {
+			return nil
+		}
+
+		stat := info.Sys().(*syscall.Stat_t)
+
+		var linkTarget string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if linkTarget, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, linkTarget)
+		if err != nil {
+			return err
+		}
+
+		header.Name = rel
+		header.Uid = containerIdOf(int(stat.Uid))
+		header.Gid = containerIdOf(int(stat.Gid))
+		header.Uname, header.Gname = "", ""
+
+		// the directory that something is mounted on is hidden under it, so it's given to root
+		if mounted[rel] {
+			header.Uid, header.Gid = 0, 0
+		}
+
+		if info.IsDir() {
+			header.Name += "/"
+		} else if info.Mode().IsRegular() && stat.Nlink > 1 {
+			key := inode{uint64(stat.Dev), stat.Ino}
+			if first, ok := links[key]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+			} else {
+				links[key] = rel
+			}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(tw, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		// a directory that's mounted is kept, but not what's in it
+		if mounted[rel] {
+			return fs.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// isTerminal tells whether file is a terminal
+func isTerminal(file *os.File) bool {
+	var state syscall.Termios
+	return ioctl(int(file.Fd()), syscall.TCGETS, unsafe.Pointer(&state)) == nil
+}
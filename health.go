@@ -0,0 +1,128 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// the defaults of --health-interval & --health-retries
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthRetries  = 3
+)
+
+type healthStatus string
+
+const (
+	// the container hasn't been checked yet
+	healthStarting healthStatus = "starting"
+
+	// the last check succeeded
+	healthHealthy healthStatus = "healthy"
+
+	// the last --health-retries checks failed
+	healthUnhealthy healthStatus = "unhealthy"
+)
+
+// the --health-cmd check of a container & its result so far
+type containerHealth struct {
+	// the check, see the flags of run
+	Command  string `json:"command"`
+	Interval string `json:"interval"`
+	Retries  int    `json:"retries"`
+
+	Status healthStatus `json:"status"`
+
+	// how many checks in a row have failed
+	FailingStreak int `json:"failingStreak"`
+}
+
+// startHealthChecks runs the --health-cmd of a running container with sh -c in its namespaces
+// every --health-interval & records whether it's healthy in its config, until the returned function
+// is called. a check that takes longer than the interval fails. the container is unhealthy once
+// --health-retries checks in a row have failed, & healthy again as soon as one succeeds
+func startHealthChecks(containerId string, options *runOptions) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(options.healthInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			config, err := readContainerConfig(containerId)
+			if err != nil || config.Health == nil {
+				return
+			}
+
+			// a frozen container can't answer, which doesn't make it unhealthy
+			if config.State == statePaused {
+				continue
+			}
+
+			healthy := runHealthCheck(config, options.healthCmd, options.healthInterval)
+
+			// the container may have changed in the meantime, e.g. by focker stop
+			config, err = readContainerConfig(containerId)
+			if err != nil || config.Health == nil {
+				return
+			}
+
+			if healthy {
+				config.Health.Status, config.Health.FailingStreak = healthHealthy, 0
+			} else {
+				config.Health.FailingStreak++
+				if config.Health.FailingStreak >= options.healthRetries {
+					config.Health.Status = healthUnhealthy
+				}
+			}
+
+			writeContainerConfig(config)
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// runHealthCheck runs command with sh -c in a running container & tells whether it exited with 0
+// within timeout
+func runHealthCheck(config *containerConfig, command string, timeout time.Duration) bool {
+	cmd, err := containerCommand(config, nil, config.Workdir, []string{"/bin/sh", "-c", command})
+	if err != nil {
+		return false
+	}
+
+	// nsenter forks the command, so it gets a process group that can be killed as a whole
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// the check goes into the container's cgroup, like anything else that runs in it
+	if cgroupDir, err := os.Open(containerCgroupDir(config.Id)); err == nil {
+		defer cgroupDir.Close()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+	}
+
+	if err := cmd.Start(); err != nil {
+		return false
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	defer timer.Stop()
+
+	return cmd.Wait() == nil
+}
@@ -0,0 +1,397 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// an image that can be pulled by name instead of by url
+type imageAlias struct {
+	url string
+
+	// the file with the sha256 checksum of the tarball, in the format of sha256sum's output
+	checksumUrl string
+}
+
+// the names of the host's arch in the alpine download urls
+var alpineArchs = map[string]string{"amd64": "x86_64", "arm64": "aarch64"}
+
+var imageAliases = map[string]imageAlias{
+	"ubuntu:22.04": {
+		url:         "https://cdimage.ubuntu.com/ubuntu-base/releases/22.04/release/ubuntu-base-22.04.5-base-" + runtime.GOARCH + ".tar.gz",
+		checksumUrl: "https://cdimage.ubuntu.com/ubuntu-base/releases/22.04/release/SHA256SUMS",
+	},
+	"alpine:3.18": {
+		url:         "https://dl-cdn.alpinelinux.org/alpine/v3.18/releases/" + alpineArchs[runtime.GOARCH] + "/alpine-minirootfs-3.18.4-" + alpineArchs[runtime.GOARCH] + ".tar.gz",
+		checksumUrl: "https://dl-cdn.alpinelinux.org/alpine/v3.18/releases/" + alpineArchs[runtime.GOARCH] + "/alpine-minirootfs-3.18.4-" + alpineArchs[runtime.GOARCH] + ".tar.gz.sha256",
+	},
+}
+
+// imageTarball returns where the tarball at rawUrl is cached, e.g.
+// images/alpine-minirootfs-3.18.4-x86_64.tar.gz. it's extracted next to it by prepareRootfsLower
+func imageTarball(rawUrl string) (string, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	name := path.Base(parsed.Path)
+	if !strings.HasSuffix(name, ".tar.gz") {
+		return "", fmt.Errorf("%s isn't a .tar.gz file", rawUrl)
+	}
+
+	return filepath.Join(imagesDir, name), nil
+}
+
+// pulledImageTarball returns the cached tarball of an image alias, which has to have been pulled
+func pulledImageTarball(name string) (string, error) {
+	tarball, err := imageTarball(imageAliases[name].url)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(tarball); err != nil {
+		return "", fmt.Errorf("image %s isn't present locally, run focker pull %s", name, name)
+	}
+
+	return tarball, nil
+}
+
+// lookupImage finds the tarball of an image in imagesDir. name is an image alias (alpine:3.18),
+// an alias without its tag (alpine) or the name of a pulled tarball without .tar.gz (e.g. myroot
+// for images/myroot.tar.gz) or of a manifest without .manifest (see imageLayers). ok is false if
+// name isn't an image, e.g. because it's a command
+func lookupImage(name string) (tarball string, ok bool, err error) {
+	if len(name) == 0 || strings.Contains(name, "/") {
+		return "", false, nil
+	}
+
+	if _, isAlias := imageAliases[name]; isAlias {
+		tarball, err := pulledImageTarball(name)
+		return tarball, true, err
+	}
+
+	for _, alias := range imageAliasNames() {
+		if repository, _, _ := strings.Cut(alias, ":"); repository == name {
+			tarball, err := pulledImageTarball(alias)
+			return tarball, true, err
+		}
+	}
+
+	tarball = filepath.Join(imagesDir, name+".tar.gz")
+	if _, err := os.Stat(tarball); err == nil {
+		return tarball, true, nil
+	}
+
+	// an image made of layers, see imageLayers
+	manifest := filepath.Join(imagesDir, name+".manifest")
+	if _, err := os.Stat(manifest); err == nil {
+		return manifest, true, nil
+	}
+
+	// commands don't usually have a tag
+	if strings.Contains(name, ":") {
+		return "", true, fmt.Errorf("image %s isn't present locally, pull it with focker pull", name)
+	}
+
+	return "", false, nil
+}
+
+// imageCommandFile returns the file with the default command of the image whose tarball (or
+// manifest) is tarball, which is next to it, e.g. images/alpine-minirootfs-3.18.4-x86_64.cmd
+func imageCommandFile(tarball string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(tarball, ".manifest"), ".gz"), ".tar") + ".cmd"
+}
+
+// imageDefaultCommand returns the command that containers of the image whose tarball is tarball
+// run when they're run without one, from its imageCommandFile (see parseImageCommand). it's nil
+// if there's no such file
+func imageDefaultCommand(tarball string) ([]string, error) {
+	data, err := os.ReadFile(imageCommandFile(tarball))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	command, err := parseImageCommand(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", imageCommandFile(tarball), err)
+	}
+
+	return command, nil
+}
+
+// parseImageCommand parses the default command of an image, which has one of the forms of CMD in
+// a Dockerfile:
+//   - the exec form, a JSON array like ["nginx", "-g", "daemon off;"], which is executed as is
+//   - the shell form, a JSON string like "nginx -g 'daemon off;' > /var/log/nginx.log", which is
+//     run with /bin/sh -c, so it can use variables, redirections etc., but the shell is the
+//     command's process & gets the signals (e.g. of focker stop) rather than the program
+//   - the command & each of its args on a line of their own, like the exec form. empty lines are
+//     skipped
+func parseImageCommand(data string) ([]string, error) {
+	trimmed := strings.TrimSpace(data)
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		var command []string
+		if err := json.Unmarshal([]byte(trimmed), &command); err != nil {
+			return nil, fmt.Errorf("invalid exec form, expected a JSON array of strings: %w", err)
+		}
+
+		if len(command) == 0 || len(command[0]) == 0 {
+			return nil, fmt.Errorf("the command is empty")
+		}
+
+		return command, nil
+	case strings.HasPrefix(trimmed, "\""):
+		var script string
+		if err := json.Unmarshal([]byte(trimmed), &script); err != nil {
+			return nil, fmt.Errorf("invalid shell form, expected a JSON string: %w", err)
+		}
+
+		if len(strings.TrimSpace(script)) == 0 {
+			return nil, fmt.Errorf("the command is empty")
+		}
+
+		return []string{"/bin/sh", "-c", script}, nil
+	}
+
+	var command []string
+	for _, line := range strings.Split(data, "\n") {
+		if len(strings.TrimSpace(line)) > 0 {
+			command = append(command, line)
+		}
+	}
+
+	return command, nil
+}
+
+// parseEntrypoint parses the value of --entrypoint, which is either the program to run or, in the
+// exec form like the default command of an image, a JSON array of the program & the args that
+// come before those of the command, e.g. ["python3", "-u"]
+func parseEntrypoint(value string) ([]string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		if len(value) == 0 {
+			return nil, fmt.Errorf("the entrypoint can't be empty")
+		}
+
+		return []string{value}, nil
+	}
+
+	var entrypoint []string
+	if err := json.Unmarshal([]byte(value), &entrypoint); err != nil {
+		return nil, fmt.Errorf("invalid exec form, expected a JSON array of strings: %w", err)
+	}
+
+	if len(entrypoint) == 0 || len(entrypoint[0]) == 0 {
+		return nil, fmt.Errorf("the entrypoint can't be empty")
+	}
+
+	return entrypoint, nil
+}
+
+// defaultImage returns the tarball of containers that are run without an image, which is
+// defaultRootFsTarball or else ubuntu:22.04 if it was pulled
+func defaultImage() (string, error) {
+	if _, err := os.Stat(defaultRootFsTarball); err == nil {
+		return defaultRootFsTarball, nil
+	}
+
+	if tarball, err := pulledImageTarball("ubuntu:22.04"); err == nil {
+		return tarball, nil
+	}
+
+	return "", fmt.Errorf("no image was given & the default one (%s) doesn't exist, run focker pull ubuntu:22.04", defaultRootFsTarball)
+}
+
+// pull downloads a rootfs tarball into imagesDir & returns the exit code that focker should exit
+// with. args are an image alias or the url of a .tar.gz, optionally followed by --sha256=<checksum>
+// for urls that don't have a <url>.sha256 file next to them
+func pull(args []string) int {
+	var ref, checksum string
+	quiet := false
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--sha256=") {
+			checksum = strings.ToLower(strings.TrimPrefix(arg, "--sha256="))
+		} else if arg == "-q" || arg == "--quiet" {
+			quiet = true
+		} else if len(ref) == 0 {
+			ref = arg
+		} else {
+			log.Printf("unexpected argument %s", arg)
+			return 1
+		}
+	}
+
+	source, isAlias := imageAliases[ref]
+	if !isAlias {
+		if !strings.HasPrefix(ref, "http://") && !strings.HasPrefix(ref, "https://") {
+			log.Printf("%s is neither a url nor one of the known images (%s)", ref, strings.Join(imageAliasNames(), ", "))
+			return 1
+		}
+
+		source = imageAlias{url: ref, checksumUrl: ref + ".sha256"}
+	}
+
+	tarball, err := imageTarball(source.url)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	if len(checksum) == 0 {
+		checksum, err = fetchChecksum(source.checksumUrl, path.Base(tarball))
+		if err != nil {
+			hint := ""
+			if !isAlias {
+				hint = ", pass it with --sha256=<checksum>"
+			}
+
+			log.Printf("failed to get the checksum of %s: %v%s", ref, err, hint)
+			return 1
+		}
+	}
+
+	// the cached tarball is only reused if it's the same one
+	if cached, err := fileSha256(tarball); err == nil && cached == checksum {
+		if !quiet {
+			infof("%s is up to date", ref)
+		}
+
+		fmt.Println(tarball)
+		return 0
+	}
+
+	if !quiet {
+		infof("downloading %s", source.url)
+	}
+
+	if err := downloadImage(source.url, tarball, checksum, !quiet && currentLogLevel <= levelInfo); err != nil {
+		log.Printf("failed to pull %s: %v", ref, err)
+		return 1
+	}
+
+	// the lower dirs are only extracted when they don't exist, so the new tarball would never be
+	// extracted if the ones of the previous one stayed
+	if err := removeRootfsLowerDirs(tarball); err != nil {
+		log.Printf("failed to remove the rootfs extracted from the previous %s: %v", tarball, err)
+		return 1
+	}
+
+	fmt.Println(tarball)
+	return 0
+}
+
+func imageAliasNames() []string {
+	var names []string
+	for name := range imageAliases {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// fetchChecksum downloads a checksum file & returns the sha256 checksum of fileName in it. the
+// file either has lines like sha256sum's output ("<checksum>  <file name>", with a * before the
+// name for binary mode) or just the checksum
+func fetchChecksum(checksumUrl string, fileName string) (string, error) {
+	response, err := http.Get(checksumUrl)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", checksumUrl, response.Status)
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 1 || (len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == fileName) {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("%s has no checksum for %s", checksumUrl, fileName)
+}
+
+// downloadImage downloads rawUrl to tarball & checks that its sha256 checksum is the expected
+// one. it's downloaded to a temporary file that's renamed once it's verified, so a failed or
+// tampered download never replaces the cached tarball. with showProgress, the progress of the
+// download is shown on stderr, see progressReader
+func downloadImage(rawUrl string, tarball string, checksum string, showProgress bool) error {
+	response, err := http.Get(rawUrl)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawUrl, response.Status)
+	}
+
+	tmpFile, err := os.CreateTemp(imagesDir, ".pull-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+	progress := newProgressReader(response.Body, "downloading "+path.Base(tarball), response.ContentLength, showProgress)
+	_, err = io.Copy(io.MultiWriter(tmpFile, hash), progress)
+	progress.done()
+	if err != nil {
+		return err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != checksum {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", checksum, actual)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), tarball)
+}
+
+// fileSha256 returns the sha256 checksum of a file, in hex
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
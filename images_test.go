@@ -0,0 +1,144 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestParseImageCommand(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		// the exec form
+		{`["nginx", "-g", "daemon off;"]`, []string{"nginx", "-g", "daemon off;"}},
+		{"  [\"echo\", \"$HOME\"]\n", []string{"echo", "$HOME"}},
+
+		// the shell form
+		{`"echo $HOME > /tmp/home"`, []string{"/bin/sh", "-c", "echo $HOME > /tmp/home"}},
+
+		// a line per arg
+		{"sleep\n\n30\n", []string{"sleep", "30"}},
+		{"/bin/bash\n", []string{"/bin/bash"}},
+	}
+
+	for _, test := range tests {
+		got, err := parseImageCommand(test.data)
+		if err != nil {
+			t.Errorf("parseImageCommand(%q) = %v", test.data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseImageCommand(%q) = %q, want %q", test.data, got, test.want)
+		}
+	}
+
+	for _, data := range []string{`[]`, `[""]`, `["echo", 1]`, `["echo"`, `""`, `"echo`} {
+		if command, err := parseImageCommand(data); err == nil {
+			t.Errorf("parseImageCommand(%q) = %q, want an error", data, command)
+		}
+	}
+}
+
+func TestParseEntrypoint(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"/usr/bin/env", []string{"/usr/bin/env"}},
+
+		// the whole value is the program, like docker's --entrypoint
+		{"python3 -u", []string{"python3 -u"}},
+		{`["python3", "-u"]`, []string{"python3", "-u"}},
+	}
+
+	for _, test := range tests {
+		got, err := parseEntrypoint(test.value)
+		if err != nil {
+			t.Errorf("parseEntrypoint(%q) = %v", test.value, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseEntrypoint(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "[]", `["python3",`} {
+		if _, err := parseEntrypoint(value); err == nil {
+			t.Errorf("parseEntrypoint(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+// the exec form runs the program as the command's process, with its args as they are, while the
+// shell form runs the shell, which expands variables & is the process that gets the signals
+func TestImageCommandForms(t *testing.T) {
+	expansions := []struct {
+		data string
+		want string
+	}{
+		{`["echo", "$GREETING"]`, "$GREETING"},
+		{`"echo $GREETING"`, "hello"},
+	}
+
+	for _, test := range expansions {
+		command, err := parseImageCommand(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Env = append(os.Environ(), "GREETING=hello")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := strings.TrimSpace(string(output)); got != test.want {
+			t.Errorf("%s printed %q, want %q", test.data, got, test.want)
+		}
+	}
+
+	signaled := []struct {
+		data string
+		want string // the name of the command's process
+	}{
+		{`["sleep", "30"]`, "sleep"},
+		{`"sleep 30; exit 0"`, "sh"},
+	}
+
+	for _, test := range signaled {
+		command, err := parseImageCommand(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Start returns once the program has been executed
+		comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", cmd.Process.Pid))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+
+		if got := strings.TrimSpace(string(comm)); got != test.want {
+			t.Errorf("%s: the command's process is %s, want %s", test.data, got, test.want)
+		}
+	}
+}
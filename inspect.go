@@ -0,0 +1,69 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// inspect prints the config of a container as json & returns the exit code that focker should
+// exit with. args are the container id or name, optionally preceded by --format=<template> to
+// print the config with a go template instead, e.g. --format={{.State}}
+func inspect(args []string) int {
+	var format string
+	if len(args) > 0 && strings.HasPrefix(args[0], "--format=") {
+		format = strings.TrimPrefix(args[0], "--format=")
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		log.Print("usage: focker inspect [--format=<template>] <containerId>")
+		return 1
+	}
+
+	containerId, err := resolveContainerId(args[0])
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	config, err := readContainerConfig(containerId)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	refreshContainerState(config)
+
+	if len(format) == 0 {
+		data, err := json.MarshalIndent(config, "", "  ")
+		exitIfError(err, "inspect: json.MarshalIndent()")
+		fmt.Println(string(data))
+		return 0
+	}
+
+	// the json function prints a field as json, e.g. --format='{{json .Mounts}}'
+	tmpl, err := template.New("format").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(format)
+	if err != nil {
+		log.Printf("invalid --format: %v", err)
+		return 1
+	}
+
+	var output strings.Builder
+	if err := tmpl.Execute(&output, config); err != nil {
+		log.Printf("inspect: %v", err)
+		return 1
+	}
+
+	fmt.Println(output.String())
+	return 0
+}
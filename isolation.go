@@ -0,0 +1,75 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// PR_SET_NO_NEW_PRIVS, which the syscall package doesn't have
+const prSetNoNewPrivs = 38
+
+// applyIsolationPreset sets the options that an --isolation level stands for. explicit holds the
+// flags that the user gave, which take precedence over the preset.
+//
+//   - none: shares the host's pid, uts, ipc, network & user namespaces. only the mount namespace
+//     is kept, since the container still needs its own rootfs
+//   - default: the usual new pid, uts, ipc, network, mount & user namespaces
+//   - strict: default + --read-only, --standard-mounts, --no-new-privileges & --cap-drop=ALL
+func applyIsolationPreset(options *runOptions, level string, explicit map[string]bool) error {
+	switch level {
+	case "none":
+		if !explicit["--pid"] {
+			options.pid = "host"
+		}
+
+		if !explicit["--uts"] {
+			options.uts = "host"
+		}
+
+		if !explicit["--ipc"] {
+			options.ipc = "host"
+		}
+
+		if !explicit["--net"] {
+			options.net = "host"
+		}
+
+		if !explicit["--userns"] {
+			options.userns = "host"
+		}
+	case "default":
+	case "strict":
+		if !explicit["--read-only"] {
+			options.readOnly = true
+		}
+
+		if !explicit["--standard-mounts"] {
+			options.standardMounts = true
+		}
+
+		if !explicit["--no-new-privileges"] {
+			options.noNewPrivileges = true
+		}
+
+		if !explicit["--cap-add"] && !explicit["--cap-drop"] {
+			options.capDrop = []string{"ALL"}
+		}
+	default:
+		return fmt.Errorf("invalid level %q (expected none, default or strict)", level)
+	}
+
+	return nil
+}
+
+// setNoNewPrivileges sets no_new_privs on the calling thread, so that it & the processes it
+// forks can't gain privileges on execve, e.g. through setuid binaries or file capabilities
+func setNoNewPrivileges() error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// the signals that kill -s accepts by name, without the SIG prefix
+var signalsByName = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"PWR":    syscall.SIGPWR,
+	"SYS":    syscall.SIGSYS,
+}
+
+// kill sends a signal to containers right away & returns the exit code that focker should exit
+// with. args are an optional -s <signal> (or -s=<signal>), SIGKILL by default, & the ids of the
+// containers
+func kill(args []string) int {
+	spec := "KILL"
+	var refs []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-s" || arg == "--signal":
+			if i+1 == len(args) {
+				log.Printf("kill: %s requires a signal", arg)
+				return 1
+			}
+
+			i++
+			spec = args[i]
+		case strings.HasPrefix(arg, "-s="), strings.HasPrefix(arg, "--signal="):
+			_, spec, _ = strings.Cut(arg, "=")
+		case strings.HasPrefix(arg, "-"):
+			log.Printf("kill: unknown flag %s", arg)
+			return 1
+		default:
+			refs = append(refs, arg)
+		}
+	}
+
+	sig, err := parseSignal(spec)
+	if err != nil {
+		log.Printf("kill: %v", err)
+		return 1
+	}
+
+	if len(refs) == 0 {
+		log.Print("usage: focker kill [-s <signal>] <containerId>...")
+		return 1
+	}
+
+	exitCode := 0
+	for _, ref := range refs {
+		containerId, err := resolveContainerId(ref)
+		if err == nil {
+			err = killContainer(containerId, sig)
+		}
+
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			continue
+		}
+
+		fmt.Println(ref)
+	}
+
+	return exitCode
+}
+
+// parseSignal parses the signal of kill -s, which is a name with or without the SIG prefix (in
+// any case) or a number. only SIGKILL & the signals that the container's init passes on to the
+// command (see forwardedSignals) have any effect on the command, so the others are refused
+func parseSignal(spec string) (syscall.Signal, error) {
+	sig, ok := signalsByName[strings.TrimPrefix(strings.ToUpper(spec), "SIG")]
+	if !ok {
+		number, err := strconv.Atoi(spec)
+		if err != nil || number <= 0 || number >= 65 {
+			return 0, fmt.Errorf("unknown signal %s", spec)
+		}
+
+		sig = syscall.Signal(number)
+	}
+
+	if sig == syscall.SIGKILL {
+		return sig, nil
+	}
+
+	var names []string
+	for _, forwarded := range forwardedSignals {
+		if forwarded == sig {
+			return sig, nil
+		}
+
+		names = append(names, signalName(forwarded.(syscall.Signal)))
+	}
+
+	return 0, fmt.Errorf("%s wouldn't reach the command, the container's init only passes on %s (& SIGKILL kills the container)", spec, strings.Join(names, ", "))
+}
+
+// signalName returns the name of a signal in signalsByName with the SIG prefix, e.g. SIGTERM
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalsByName {
+		if s == sig {
+			return "SIG" + name
+		}
+	}
+
+	return strconv.Itoa(int(sig))
+}
+
+// killContainer sends sig to the init of a running container, which passes it on to the command,
+// or which the kernel kills along with every other process of the container for SIGKILL. focker
+// run records the exit & cleans up after the container as usual
+func killContainer(containerId string, sig syscall.Signal) error {
+	config, err := readContainerConfig(containerId)
+	if err != nil {
+		return err
+	}
+
+	if !isContainerRunning(config) {
+		return fmt.Errorf("container %s is not running", containerId)
+	}
+
+	if err := syscall.Kill(config.Pid, sig); err != nil {
+		return fmt.Errorf("failed to send %s to container %s: %w", signalName(sig), containerId, err)
+	}
+
+	return nil
+}
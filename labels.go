@@ -0,0 +1,94 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseLabel parses the value of -l, i.e. <key>=<value> or just <key> for an empty value
+func parseLabel(spec string) (string, string, error) {
+	key, value, _ := strings.Cut(spec, "=")
+	if len(key) == 0 {
+		return "", "", fmt.Errorf("invalid label %q, expected <key>[=<value>]", spec)
+	}
+
+	for _, prefix := range reservedLabelPrefixes {
+		if strings.HasPrefix(strings.ToLower(key), prefix) {
+			return "", "", fmt.Errorf("invalid label %q, keys that start with %s are reserved for focker", spec, prefix)
+		}
+	}
+
+	return key, value, nil
+}
+
+// the prefixes of the label keys that focker keeps for its own metadata, which -l can't set
+var reservedLabelPrefixes = []string{"com.focker.", "focker.internal."}
+
+// the --filter flags of ps. a container is listed if it has every label & is in one of the states
+// (if any are given)
+type psFilter struct {
+	// label key -> value, or nil to only require the key
+	labels map[string]*string
+
+	states []containerState
+}
+
+// add adds a --filter to the filter, i.e. label=<key>[=<value>] or status=<state>
+func (filter *psFilter) add(spec string) error {
+	name, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return fmt.Errorf("invalid filter %q, expected label=<key>[=<value>] or status=<state>", spec)
+	}
+
+	switch name {
+	case "label":
+		key, labelValue, hasValue := strings.Cut(value, "=")
+		if len(key) == 0 {
+			return fmt.Errorf("invalid filter %q, expected label=<key>[=<value>]", spec)
+		}
+
+		if filter.labels == nil {
+			filter.labels = map[string]*string{}
+		}
+
+		filter.labels[key] = nil
+		if hasValue {
+			filter.labels[key] = &labelValue
+		}
+	case "status":
+		state := containerState(value)
+		if _, ok := stateTransitions[state]; !ok {
+			return fmt.Errorf("invalid status %q, expected created, running, paused, stopped, exited or removing", value)
+		}
+
+		filter.states = append(filter.states, state)
+	default:
+		return fmt.Errorf("unknown filter %q, expected label or status", name)
+	}
+
+	return nil
+}
+
+// matches tells whether ps lists the container with the filter
+func (filter *psFilter) matches(config *containerConfig) bool {
+	for key, value := range filter.labels {
+		labelValue, ok := config.Labels[key]
+		if !ok || value != nil && labelValue != *value {
+			return false
+		}
+	}
+
+	if len(filter.states) == 0 {
+		return true
+	}
+
+	for _, state := range filter.states {
+		if config.State == state {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func TestParseLabel(t *testing.T) {
+	tests := []struct {
+		spec  string
+		key   string
+		value string
+		ok    bool
+	}{
+		{"env=prod", "env", "prod", true},
+		{"env", "env", "", true},
+		{"url=http://a/?b=c", "url", "http://a/?b=c", true},
+		{"focker.version=1", "focker.version", "1", true},
+		{"=prod", "", "", false},
+
+		// focker's own metadata
+		{"com.focker.restart=always", "", "", false},
+		{"COM.FOCKER.health", "", "", false},
+		{"focker.internal.state=running", "", "", false},
+	}
+
+	for _, test := range tests {
+		key, value, err := parseLabel(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("parseLabel(%q) error = %v, want ok = %v", test.spec, err, test.ok)
+			continue
+		}
+
+		if key != test.key || value != test.value {
+			t.Errorf("parseLabel(%q) = %q, %q, want %q, %q", test.spec, key, value, test.key, test.value)
+		}
+	}
+}
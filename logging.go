@@ -0,0 +1,88 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type logLevel int
+
+const (
+	// what focker does step by step, e.g. each step of setting up a container
+	levelDebug logLevel = iota
+
+	// things worth knowing that aren't errors, e.g. that an image is being downloaded
+	levelInfo
+
+	// nothing but errors
+	levelError
+)
+
+var logLevels = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"error": levelError,
+}
+
+// the level below which messages aren't logged, from --log-level or $FOCKER_LOG
+var currentLogLevel = levelInfo
+
+// setLogLevel sets the level from its name (debug, info or error) & exports it as $FOCKER_LOG, so
+// that the processes that focker re-executes itself as (e.g. _child) log at the same level
+func setLogLevel(name string) error {
+	level, ok := logLevels[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("invalid log level %q, expected debug, info or error", name)
+	}
+
+	currentLogLevel = level
+	return os.Setenv("FOCKER_LOG", strings.ToLower(name))
+}
+
+// parseGlobalFlags sets the log level from $FOCKER_LOG & from --log-level=<level> or --verbose
+// (i.e. debug), which come before the command, & returns the args without them. --version is
+// left alone, it stands for the version command
+func parseGlobalFlags(args []string) []string {
+	if name := os.Getenv("FOCKER_LOG"); len(name) > 0 {
+		exitIfError(setLogLevel(name), "FOCKER_LOG")
+	}
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "--version" {
+		switch arg := args[0]; {
+		case arg == "--verbose":
+			exitIfError(setLogLevel("debug"), "--verbose")
+		case strings.HasPrefix(arg, "--log-level="):
+			exitIfError(setLogLevel(strings.TrimPrefix(arg, "--log-level=")), "--log-level")
+		default:
+			log.Fatalf("unknown flag %s, only --log-level=<level> & --verbose come before the command", arg)
+		}
+
+		args = args[1:]
+	}
+
+	return args
+}
+
+func debugf(format string, args ...any) {
+	if currentLogLevel <= levelDebug {
+		log.Printf("debug: "+format, args...)
+	}
+}
+
+func infof(format string, args ...any) {
+	if currentLogLevel <= levelInfo {
+		log.Printf(format, args...)
+	}
+}
+
+// warnf logs something that's likely a mistake but doesn't keep focker from going on. warnings
+// are logged along with the informational messages, so only --log-level=error leaves them out
+func warnf(format string, args ...any) {
+	if currentLogLevel <= levelInfo {
+		log.Printf("warning: "+format, args...)
+	}
+}
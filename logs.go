@@ -0,0 +1,69 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// how often logs -f checks for new output
+const logsFollowInterval = 250 * time.Millisecond
+
+// logs prints the output of a container that was run with --log & returns the exit code that
+// focker should exit with. args are the container id, optionally preceded by -f to keep printing
+// new output until the container exits
+func logs(args []string) int {
+	follow := false
+	if len(args) > 0 && (args[0] == "-f" || args[0] == "--follow") {
+		follow = true
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		log.Print("usage: focker logs [-f] <containerId>")
+		return 1
+	}
+
+	containerId, err := resolveContainerId(args[0])
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	logFile, err := os.Open(containerLogPath(containerId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("container %s has no logs, it wasn't run with --log", containerId)
+		} else {
+			log.Print(err)
+		}
+
+		return 1
+	}
+	defer logFile.Close()
+
+	for {
+		// check this before reading so that the output from right before the container exited
+		// is printed too
+		running := false
+		if follow {
+			config, err := readContainerConfig(containerId)
+			running = err == nil && isContainerRunning(config)
+		}
+
+		if _, err := io.Copy(os.Stdout, logFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if !running {
+			return 0
+		}
+
+		time.Sleep(logsFollowInterval)
+	}
+}
@@ -0,0 +1,186 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// how the container's output is written to its log, from --log-opt mode=
+const (
+	// the container's writes wait until its output has been written, like without focker
+	logModeBlocking = "blocking"
+
+	// the container's output is buffered in memory & written in the background, so a slow log
+	// never stalls it, but the oldest lines are dropped once the buffer is full
+	logModeNonBlocking = "non-blocking"
+)
+
+// the default size of the buffer of --log-opt mode=non-blocking, the same as docker's
+const defaultLogBufferSize = 1 << 20
+
+// parseLogOpt parses a --log-opt, i.e. mode=blocking|non-blocking or max-buffer-size=<bytes>, into
+// options
+func parseLogOpt(spec string, options *runOptions) error {
+	key, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return fmt.Errorf("invalid option %q, expected <key>=<value>", spec)
+	}
+
+	switch key {
+	case "mode":
+		if value != logModeBlocking && value != logModeNonBlocking {
+			return fmt.Errorf("invalid mode %q, expected %s or %s", value, logModeBlocking, logModeNonBlocking)
+		}
+
+		options.logMode = value
+	case "max-buffer-size":
+		size, err := parseMemorySize(value)
+		if err != nil {
+			return err
+		}
+
+		options.logBufferSize = size
+	default:
+		return fmt.Errorf("unknown option %q, expected mode or max-buffer-size", key)
+	}
+
+	return nil
+}
+
+// nonBlockingLogWriter is the writer of --log-opt mode=non-blocking. Write only queues the output
+// in a buffer of at most maxSize bytes, from which it's written to out in the background, & drops
+// the oldest lines to make room when the buffer is full. the number of lines that were dropped is
+// written to out in place of them, e.g. "[focker: dropped 12 lines of output]"
+type nonBlockingLogWriter struct {
+	out     io.Writer
+	maxSize int
+
+	mu sync.Mutex
+
+	// signaled when a line is queued & on close
+	queued *sync.Cond
+
+	// the complete lines that are waiting to be written, oldest first, & their total size
+	lines [][]byte
+	size  int
+
+	// the start of a line that hasn't been ended by a \n yet
+	partial []byte
+
+	// how many lines were dropped since the last line that was written to out
+	dropped int
+
+	closed    bool
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// newNonBlockingLogWriter returns a nonBlockingLogWriter that writes to out until it's closed
+func newNonBlockingLogWriter(out io.Writer, maxSize uint64) *nonBlockingLogWriter {
+	writer := &nonBlockingLogWriter{out: out, maxSize: int(maxSize), done: make(chan struct{})}
+	writer.queued = sync.NewCond(&writer.mu)
+	go writer.drain()
+	return writer
+}
+
+func (writer *nonBlockingLogWriter) Write(p []byte) (int, error) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	for data := p; len(data) > 0; {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			writer.partial = append(writer.partial, data...)
+
+			// a line without an end mustn't grow past the buffer either
+			if len(writer.partial) >= writer.maxSize {
+				writer.queue(writer.partial)
+				writer.partial = nil
+			}
+
+			break
+		}
+
+		writer.queue(append(writer.partial, data[:end+1]...))
+		writer.partial = nil
+		data = data[end+1:]
+	}
+
+	return len(p), nil
+}
+
+// queue adds a copy of line to the buffer, dropping the oldest lines if it doesn't fit.
+// writer.mu must be held
+func (writer *nonBlockingLogWriter) queue(line []byte) {
+	line = bytes.Clone(line)
+	for len(writer.lines) > 0 && writer.size+len(line) > writer.maxSize {
+		writer.size -= len(writer.lines[0])
+		writer.lines[0] = nil
+		writer.lines = writer.lines[1:]
+		writer.dropped++
+	}
+
+	writer.lines = append(writer.lines, line)
+	writer.size += len(line)
+	writer.queued.Signal()
+}
+
+// drain writes the queued lines to out until the writer is closed & its buffer is empty
+func (writer *nonBlockingLogWriter) drain() {
+	defer close(writer.done)
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	for {
+		for len(writer.lines) == 0 && !writer.closed {
+			writer.queued.Wait()
+		}
+
+		if len(writer.lines) == 0 {
+			return
+		}
+
+		line := writer.lines[0]
+		writer.lines[0] = nil
+		writer.lines = writer.lines[1:]
+		writer.size -= len(line)
+
+		// lines are only dropped from the front of the buffer, so they were right before this one
+		dropped := writer.dropped
+		writer.dropped = 0
+
+		// the container can keep writing to the buffer while out is slow
+		writer.mu.Unlock()
+		if dropped > 0 {
+			fmt.Fprintf(writer.out, "[focker: dropped %d lines of output]\n", dropped)
+		}
+
+		writer.out.Write(line)
+		writer.mu.Lock()
+	}
+}
+
+// Close writes what's left in the buffer to out, including a last line without a \n, & returns
+// once it's written. nothing may be written after it's closed
+func (writer *nonBlockingLogWriter) Close() error {
+	writer.closeOnce.Do(func() {
+		writer.mu.Lock()
+		if len(writer.partial) > 0 {
+			writer.queue(writer.partial)
+			writer.partial = nil
+		}
+
+		writer.closed = true
+		writer.queued.Signal()
+		writer.mu.Unlock()
+
+		<-writer.done
+	})
+
+	return nil
+}
@@ -0,0 +1,121 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// stalledWriter is a log that doesn't take any output until it's released
+type stalledWriter struct {
+	release chan struct{}
+	buffer  bytes.Buffer
+}
+
+func (writer *stalledWriter) Write(p []byte) (int, error) {
+	<-writer.release
+	return writer.buffer.Write(p)
+}
+
+func TestNonBlockingLogWriterDropsOldestLines(t *testing.T) {
+	out := &stalledWriter{release: make(chan struct{})}
+	writer := newNonBlockingLogWriter(out, 16)
+
+	// each line is 8 bytes, so only the last 2 fit in the buffer. the first one may have been
+	// taken by the drainer already, which is then stuck writing it
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(writer, "line %02d\n", i)
+	}
+
+	close(out.release)
+	writer.Close()
+
+	got := out.buffer.String()
+	if !strings.HasSuffix(got, "line 08\nline 09\n") {
+		t.Errorf("the log doesn't end with the newest lines:\n%s", got)
+	}
+
+	// whether the first line was written or dropped, 10 lines are accounted for
+	written := strings.Count(got, "line ")
+	var dropped int
+	report := strings.Index(got, "[focker:")
+	if report < 0 {
+		t.Fatalf("the log doesn't report the dropped lines:\n%s", got)
+	}
+
+	if _, err := fmt.Sscanf(got[report:], "[focker: dropped %d lines of output]", &dropped); err != nil {
+		t.Fatalf("the log doesn't report the dropped lines:\n%s", got)
+	}
+
+	if written+dropped != 10 {
+		t.Errorf("%d lines written & %d dropped, want 10 in all:\n%s", written, dropped, got)
+	}
+}
+
+func TestNonBlockingLogWriterKeepsLines(t *testing.T) {
+	var out bytes.Buffer
+	writer := newNonBlockingLogWriter(&out, defaultLogBufferSize)
+
+	// lines that are split across writes are queued whole, & the last one is written on close
+	// even without a \n
+	for _, chunk := range []string{"hel", "lo\nwor", "ld\n", "bye"} {
+		writer.Write([]byte(chunk))
+	}
+
+	writer.Close()
+	if got, want := out.String(), "hello\nworld\nbye"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestNonBlockingLogWriterBoundsPartialLines(t *testing.T) {
+	out := &stalledWriter{release: make(chan struct{})}
+	writer := newNonBlockingLogWriter(out, 16)
+
+	// a line without an end is queued in pieces once it fills the buffer, so it can't grow it
+	for i := 0; i < 100; i++ {
+		writer.Write([]byte("0123456789"))
+		writer.mu.Lock()
+		size := writer.size + len(writer.partial)
+		writer.mu.Unlock()
+		if size > 2*16 {
+			t.Fatalf("%d bytes are buffered after %d writes, the buffer holds 16", size, i+1)
+		}
+	}
+
+	close(out.release)
+	writer.Close()
+}
+
+func TestParseLogOpt(t *testing.T) {
+	tests := []struct {
+		spec       string
+		mode       string
+		bufferSize uint64
+		wantErr    bool
+	}{
+		{spec: "mode=non-blocking", mode: logModeNonBlocking},
+		{spec: "mode=blocking", mode: logModeBlocking},
+		{spec: "max-buffer-size=4m", bufferSize: 4 << 20},
+		{spec: "mode=async", wantErr: true},
+		{spec: "max-buffer-size=0", wantErr: true},
+		{spec: "max-size=10m", wantErr: true},
+		{spec: "mode", wantErr: true},
+	}
+
+	for _, test := range tests {
+		var options runOptions
+		err := parseLogOpt(test.spec, &options)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseLogOpt(%q) error = %v, wantErr %v", test.spec, err, test.wantErr)
+			continue
+		}
+
+		if options.logMode != test.mode || options.logBufferSize != test.bufferSize {
+			t.Errorf("parseLogOpt(%q) = mode %q & buffer size %d, want %q & %d", test.spec, options.logMode, options.logBufferSize, test.mode, test.bufferSize)
+		}
+	}
+}
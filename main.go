@@ -3,153 +3,1649 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
-	"math/rand"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
 	"time"
 )
 
-const containersDir = "./containers"
-const rootFsTarball = "./ubuntu-base-22.04-base-amd64.tar.gz"
+// the directories of the containers & the images, under fockerHome(), so that focker works the
+// same from any directory. the rootfs tarballs are extracted once under imagesDir & shared by all
+// containers as the read-only lower layer of their overlay rootfs
+var (
+	containersDir string
+	imagesDir     string
+)
+
+// the rootfs of containers that are run without an image
+var defaultRootFsTarball string
 
 func init() {
-	exitIfError(os.MkdirAll(containersDir, 0700), "init containersDir")
+	// the _exec helper runs inside the container, where these don't belong
+	if len(os.Args) > 1 && os.Args[1] == "_exec" {
+		return
+	}
+
+	exitIfError(initFockerHome(), "init FOCKER_HOME")
+}
+
+// initFockerHome sets the paths of the dirs that focker keeps everything in & creates them
+func initFockerHome() error {
+	home, err := fockerHome()
+	if err != nil {
+		return err
+	}
+
+	containersDir = filepath.Join(home, "containers")
+	imagesDir = filepath.Join(home, "images")
+	defaultRootFsTarball = filepath.Join(imagesDir, "ubuntu-base-22.04-base-amd64.tar.gz")
+
+	if err := os.MkdirAll(containersDir, 0700); err != nil {
+		return err
+	}
+
+	return os.MkdirAll(imagesDir, 0700)
+}
+
+// fockerHome returns the directory that focker keeps everything in, which is $FOCKER_HOME or else
+// ~/.focker (so /root/.focker with sudo)
+func fockerHome() (string, error) {
+	if home := os.Getenv("FOCKER_HOME"); len(home) > 0 {
+		return filepath.Abs(home)
+	}
+
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userHome, ".focker"), nil
 }
 
 func main() {
+	os.Args = append(os.Args[:1], parseGlobalFlags(os.Args[1:])...)
 	if len(os.Args) < 2 {
 		log.Fatal("a command is required")
 	}
 
 	command := os.Args[1]
 	switch command {
-	case "run", "_child":
+	case "run", "_child", "_monitor":
 		// the run command will just init a new isolated process (i.e the container) with _child command,
 		// in which we will actually run the command. so we first create a container and then inside
 		// it we run the command that user specified
 
-		var volumes []string
-		var args []string
+		// the parent passes the container id as the first argument of the _child command, & so
+		// does run -d for the _monitor command (see runDetached)
+		var containerId string
+		flagArgs := os.Args[2:]
+		if command != "run" {
+			if len(flagArgs) == 0 {
+				log.Fatalf("%s: container id is required", command)
+			}
+
+			containerId = flagArgs[0]
+			flagArgs = flagArgs[1:]
+		}
+
+		if command == "_monitor" {
+			syscall.CloseOnExec(monitorReadyFd)
+		}
+
+		// the child gets everything from the parent, defaults included
+		options, args := parseRunArgs(flagArgs, command != "_child")
+		if options.replicas > 1 && command == "run" {
+			os.Exit(runReplicas(flagArgs, options))
+		}
+
+		if options.detach && command == "run" {
+			os.Exit(runDetached(flagArgs, options))
+		}
+
+		var code int
+		var err error
+		if command == "_monitor" {
+			code, err = runWithRestarts(containerId, args, options)
+		} else {
+			code, err = run(containerId, args, options, command == "_child")
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		os.Exit(code)
+
+	case "_exec":
+		os.Exit(execHelper(os.Args[2:]))
+
+	case "pull":
+		if len(os.Args) < 3 {
+			log.Fatal("usage: focker pull <url|image> [--sha256=<checksum>] [-q|--quiet]")
+		}
+
+		os.Exit(pull(os.Args[2:]))
+
+	case "logs":
+		if len(os.Args) < 3 {
+			log.Fatal("usage: focker logs [-f] <containerId>")
+		}
+
+		os.Exit(logs(os.Args[2:]))
+
+	case "inspect":
+		os.Exit(inspect(os.Args[2:]))
+
+	case "ps":
+		ps(os.Args[2:])
+
+	case "top":
+		if len(os.Args) < 3 {
+			log.Fatal("usage: focker top <containerId> [-o format]")
+		}
+
+		top(os.Args[2], os.Args[3:])
+
+	case "exec":
+		os.Exit(execInContainer(os.Args[2:]))
+
+	case "stop":
+		os.Exit(stop(os.Args[2:]))
+
+	case "kill":
+		os.Exit(kill(os.Args[2:]))
+
+	case "wait":
+		os.Exit(wait(os.Args[2:]))
+
+	case "stats":
+		os.Exit(stats(os.Args[2:]))
+
+	case "export":
+		os.Exit(export(os.Args[2:]))
+
+	case "cp":
+		os.Exit(cp(os.Args[2:]))
+
+	case "rm":
+		if len(os.Args) < 3 {
+			log.Fatal("usage: focker rm <containerId>... | --all")
+		}
+
+		os.Exit(rm(os.Args[2:]))
+
+	case "prune":
+		os.Exit(prune(os.Args[2:]))
+
+	case "caps":
+		os.Exit(caps(os.Args[2:]))
+
+	case "version", "--version":
+		printVersion()
+
+	default:
+		log.Fatal("bad command")
+	}
+}
+
+type runOptions struct {
+	// the rootfs tarball, from imagesDir or defaultImage()
+	image string
+
+	// the absolute path of a squashfs image that's mounted as the rootfs instead of an extracted
+	// tarball, from --rootfs-squashfs
+	rootfsSquashfs string
+
+	// a name that other commands accept instead of the container's id
+	name string
+
+	// stop & remove the containers that have name instead of failing, from --replace, see
+	// claimContainerName
+	replace bool
+
+	// how many identical containers run starts at once, from --replicas, see runReplicas
+	replicas int
+
+	// replaces the program of the command (the user's or the image's default one), from
+	// --entrypoint, see parseEntrypoint. the child gets the resulting command
+	entrypoint []string
+
+	// metadata of the container that ps can filter by, from -l
+	labels map[string]string
+
+	// bind, tmpfs & secret mounts, from -v, --mount, --tmpfs & --secret
+	mounts []mountSpec
+
+	// remove the container's directory once it exits
+	autoRemove bool
+
+	// when the container is restarted once it exits, with -d
+	restart restartPolicy
+
+	// how many times the container has been restarted so far
+	restartCount int
+
+	// a command that checks whether the container is healthy, with -d. it's run with sh -c every
+	// healthInterval & the container is unhealthy once it has failed healthRetries times in a row
+	healthCmd      string
+	healthInterval time.Duration
+	healthRetries  int
+
+	// how long the container may run before it's stopped, 0 for no limit
+	timeout time.Duration
+
+	// how long after its creation the container is removed by prune, whatever its state, from
+	// --ttl. 0 for no limit
+	ttl time.Duration
+
+	// also write the container's output to its log file
+	log bool
+
+	// how the output is written to the log file, logModeBlocking or logModeNonBlocking, & the size
+	// of the buffer of the latter, from --log-opt
+	logMode       string
+	logBufferSize uint64
+
+	// run the container in the background, with its output going to its log file
+	detach bool
+
+	// with detach, show the container's output until it's ready before detaching, from --wait-ready
+	waitReady bool
+
+	// pass the signals that the container gets on to the whole process group of the command
+	// instead of only to the command, from --kill-process-group
+	killProcessGroup bool
+
+	// give the command a terminal of its own, with -t, & pass our stdin on to it, with -i
+	tty         bool
+	interactive bool
+
+	// environment variables of the command, as KEY=VALUE, from -e
+	env []string
+
+	// absolute path of the directory that the command starts in, / by default
+	workdir string
+
+	// user that the command runs as, as <user>[:<group>] with names or ids, root by default
+	user string
+
+	// containers whose bind mounts should be mounted in this container too
+	volumesFrom []string
+
+	// pid namespace to use, as container:<id or name> or host. by default the container gets a new one
+	pid string
+
+	// uts namespace to use, "host" shares the host's hostname. by default the container gets a
+	// new one, with the container id as hostname
+	uts string
+
+	// hostname of the container, the container id by default
+	hostname string
+
+	// ipc namespace to use, "host" shares the host's System V IPC objects & POSIX message queues.
+	// by default the container gets a new one
+	ipc string
+
+	// network namespace to use, "none" (the default) for a new one with only a loopback interface,
+	// "bridge" for a new one connected to the focker0 bridge or "host" to share the host's network
+	net string
+
+	// address of the container on the bridge, picked automatically if not given with --ip
+	ip string
+
+	// host ports that are forwarded to the container, from -p
+	ports []portMapping
+
+	// nameservers of the container's /etc/resolv.conf, the host's by default
+	dns []string
+
+	// user namespace of the command, "host" runs it as real root. by default root in the
+	// container is mapped to an unprivileged user on the host
+	userns string
+
+	// capabilities added to & dropped from the default ones, with --cap-add & --cap-drop
+	capAdd  []string
+	capDrop []string
+
+	// set no_new_privs so that the command can't gain privileges through setuid binaries etc.
+	noNewPrivileges bool
+
+	// seccomp profile of the command, "unconfined" disables the default one
+	seccomp string
+
+	// don't check that the command exists in the container's rootfs before running it
+	skipBinaryCheck bool
+
+	// don't mount procfs at /proc
+	noProc bool
+
+	// mount tmpfs at /tmp, /run & /dev/shm & a read-only /sys
+	standardMounts bool
+
+	// host devices that are created in the container besides those of the minimal /dev
+	devices []deviceSpec
+
+	// the propagation of the container's mounts, "private" (the default) or "slave" to also see
+	// what the host mounts later on under the sources of its volumes
+	mountPropagation string
+
+	// mount a tmpfs at /tmp unless something else is mounted there, on by default
+	privateTmp bool
+
+	// mount the container's cgroup at /sys/fs/cgroup, "ro" or "rw" (which delegates the cgroup
+	// to the container), empty for none
+	mountCgroup string
+
+	// mount the rootfs read-only, except for rwPaths which get their own tmpfs
+	readOnly bool
+	rwPaths  []string
+
+	// raw writes to files in the container's cgroup, file name -> value
+	cgroupConf map[string]string
+
+	// per-device block io limits, written to io.max
+	ioThrottles []ioThrottle
+
+	// the container's share of block io relative to other cgroups (io.weight), 0 means the default
+	ioWeight uint64
+
+	// memory limits in bytes, 0 means no limit. memoryMax is the hard limit (memory.max) & the
+	// kernel throttles the container when it goes over memoryHigh (memory.high)
+	memoryMax  uint64
+	memoryHigh uint64
+
+	// cpu limit in number of cpus (cpu.max), 0 means no limit
+	cpus float64
+
+	// max number of processes (pids.max), 0 means no limit
+	pidsLimit int64
+
+	// resource limits of the command (like ulimit in shells), from --ulimit
+	ulimits []ulimit
+}
+
+// needsCgroup tells whether the container needs its own cgroup
+func (options *runOptions) needsCgroup() bool {
+	return len(options.cgroupConf) > 0 || len(options.ioThrottles) > 0 || options.ioWeight > 0 || options.memoryMax > 0 ||
+		options.memoryHigh > 0 || options.cpus > 0 || options.pidsLimit > 0 || len(options.mountCgroup) > 0
+}
+
+// parseRunArgs separates focker's flags from the command (& its args) that the user wants to run.
+// the flags come first, everything from the first argument that isn't one (the image or the
+// command) on belongs to the command, so that e.g. grep -i isn't taken for -i. -- ends the flags
+// too, for a command that starts with a dash. with withDefaults, the options start off with the
+// defaults from the config file, see runDefaults
+func parseRunArgs(flagArgs []string, withDefaults bool) (runOptions, []string) {
+	options := runOptions{
+		restart:          restartPolicy{name: "no"},
+		healthInterval:   defaultHealthInterval,
+		healthRetries:    defaultHealthRetries,
+		mountPropagation: "private",
+		privateTmp:       true,
+		logMode:          logModeBlocking,
+	}
+	var args []string
+
+	defaults := &runDefaults{}
+	if withDefaults {
+		var err error
+		defaults, err = readRunDefaults(configFlag(flagArgs))
+		exitIfError(err, "--config")
+		exitIfError(defaults.apply(&options), "--config")
+	}
+
+	// the volumes from the defaults come first, see below
+	defaultMounts := len(options.mounts)
+
+	// the flags that the user gave explicitly, which the --isolation preset doesn't override
+	explicit := map[string]bool{}
+	isolation := "default"
+
+	for i, arg := range flagArgs {
+		if arg == "--" {
+			args = flagArgs[i+1:]
+			break
+		}
+
+		if !strings.HasPrefix(arg, "-") {
+			args = flagArgs[i:]
+			break
+		}
+
+		name, _, _ := strings.Cut(arg, "=")
+		explicit[name] = true
+
+		switch {
+		case strings.HasPrefix(arg, "--name="):
+			options.name = strings.TrimPrefix(arg, "--name=")
+		case name == "--replace":
+			options.replace = parseBoolFlag(arg)
+		case strings.HasPrefix(arg, "--config="):
+			// already read above
+		case strings.HasPrefix(arg, "--replicas="):
+			replicas, err := strconv.Atoi(strings.TrimPrefix(arg, "--replicas="))
+			if err != nil || replicas <= 0 {
+				log.Fatalf("invalid --replicas value: %s (expected a positive integer)", strings.TrimPrefix(arg, "--replicas="))
+			}
+
+			options.replicas = replicas
+		case strings.HasPrefix(arg, "-l="), strings.HasPrefix(arg, "--label="):
+			_, spec, _ := strings.Cut(arg, "=")
+			key, value, err := parseLabel(spec)
+			exitIfError(err, "-l")
+			if options.labels == nil {
+				options.labels = map[string]string{}
+			}
+
+			options.labels[key] = value
+		case strings.HasPrefix(arg, "--entrypoint="):
+			entrypoint, err := parseEntrypoint(strings.TrimPrefix(arg, "--entrypoint="))
+			exitIfError(err, "--entrypoint")
+			options.entrypoint = entrypoint
+		case strings.HasPrefix(arg, "--image="):
+			options.image = strings.TrimPrefix(arg, "--image=")
+		case strings.HasPrefix(arg, "--rootfs-squashfs="):
+			path, err := filepath.Abs(strings.TrimPrefix(arg, "--rootfs-squashfs="))
+			exitIfError(err, "--rootfs-squashfs")
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				log.Fatalf("--rootfs-squashfs: %s isn't a file", path)
+			}
+
+			options.rootfsSquashfs = path
+		case strings.HasPrefix(arg, "-v="):
+			volume, err := parseVolumeSpec(strings.TrimPrefix(arg, "-v="))
+			exitIfError(err, "-v")
+			options.mounts = append(options.mounts, volume)
+		case strings.HasPrefix(arg, "--mount="):
+			mount, err := parseMountSpec(strings.TrimPrefix(arg, "--mount="))
+			exitIfError(err, "--mount")
+			options.mounts = append(options.mounts, mount)
+		case strings.HasPrefix(arg, "-w="), strings.HasPrefix(arg, "--workdir="):
+			_, workdir, _ := strings.Cut(arg, "=")
+			if !filepath.IsAbs(workdir) {
+				log.Fatalf("-w: the working directory must be an absolute path: %s", workdir)
+			}
+
+			options.workdir = filepath.Clean(workdir)
+		case strings.HasPrefix(arg, "-u="), strings.HasPrefix(arg, "--user="):
+			_, options.user, _ = strings.Cut(arg, "=")
+			if len(options.user) == 0 || strings.HasPrefix(options.user, ":") {
+				log.Fatalf("-u: expected <user>[:<group>], got %q", options.user)
+			}
+		case strings.HasPrefix(arg, "--tmpfs="):
+			tmpfs, err := parseTmpfsSpec(strings.TrimPrefix(arg, "--tmpfs="))
+			exitIfError(err, "--tmpfs")
+			options.mounts = append(options.mounts, tmpfs)
+		case strings.HasPrefix(arg, "--secret="):
+			secret, err := parseSecretSpec(strings.TrimPrefix(arg, "--secret="))
+			exitIfError(err, "--secret")
+			options.mounts = append(options.mounts, secret)
+		case strings.HasPrefix(arg, "-e="):
+			if v, ok := parseEnvVar(strings.TrimPrefix(arg, "-e=")); ok {
+				options.env = append(options.env, v)
+			}
+		case strings.HasPrefix(arg, "--volumes-from="):
+			options.volumesFrom = append(options.volumesFrom, strings.TrimPrefix(arg, "--volumes-from="))
+		case strings.HasPrefix(arg, "--pid="):
+			options.pid = strings.TrimPrefix(arg, "--pid=")
+		case strings.HasPrefix(arg, "--hostname="):
+			hostname := strings.TrimPrefix(arg, "--hostname=")
+			exitIfError(checkHostname(hostname), "--hostname")
+			options.hostname = truncateHostname(hostname)
+			if options.hostname != hostname && withDefaults {
+				warnf("--hostname: %s is longer than %d bytes, using %s instead", hostname, maxHostnameLength, options.hostname)
+			}
+		case strings.HasPrefix(arg, "--uts="):
+			options.uts = strings.TrimPrefix(arg, "--uts=")
+			if options.uts != "host" {
+				log.Fatalf("invalid --uts value: %s (expected host)", options.uts)
+			}
+		case strings.HasPrefix(arg, "--ipc="):
+			options.ipc = strings.TrimPrefix(arg, "--ipc=")
+			if options.ipc != "host" {
+				log.Fatalf("invalid --ipc value: %s (expected host)", options.ipc)
+			}
+		case strings.HasPrefix(arg, "--net="):
+			options.net = strings.TrimPrefix(arg, "--net=")
+			if options.net != "none" && options.net != "bridge" && options.net != "host" {
+				log.Fatalf("invalid --net value: %s (expected none, bridge or host)", options.net)
+			}
+		case strings.HasPrefix(arg, "--ip="):
+			options.ip = strings.TrimPrefix(arg, "--ip=")
+		case strings.HasPrefix(arg, "--dns="):
+			nameserver := strings.TrimPrefix(arg, "--dns=")
+			exitIfError(checkDns(nameserver), "--dns")
+			options.dns = append(options.dns, nameserver)
+		case strings.HasPrefix(arg, "-p="):
+			port, err := parsePortMapping(strings.TrimPrefix(arg, "-p="))
+			exitIfError(err, "-p")
+			options.ports = append(options.ports, port)
+		case strings.HasPrefix(arg, "--userns="):
+			options.userns = strings.TrimPrefix(arg, "--userns=")
+			if options.userns != "host" {
+				log.Fatalf("invalid --userns value: %s (expected host)", options.userns)
+			}
+		case strings.HasPrefix(arg, "--cap-add="):
+			capability, err := parseCapability(strings.TrimPrefix(arg, "--cap-add="))
+			exitIfError(err, "--cap-add")
+			options.capAdd = append(options.capAdd, capability)
+		case strings.HasPrefix(arg, "--cap-drop="):
+			capability, err := parseCapability(strings.TrimPrefix(arg, "--cap-drop="))
+			exitIfError(err, "--cap-drop")
+			options.capDrop = append(options.capDrop, capability)
+		case strings.HasPrefix(arg, "--seccomp="):
+			options.seccomp = strings.TrimPrefix(arg, "--seccomp=")
+			if options.seccomp != "unconfined" {
+				log.Fatalf("invalid --seccomp value: %s (expected unconfined)", options.seccomp)
+			}
+		case strings.HasPrefix(arg, "--isolation="):
+			isolation = strings.TrimPrefix(arg, "--isolation=")
+		case strings.HasPrefix(arg, "--init-binary-check="):
+			check, err := strconv.ParseBool(strings.TrimPrefix(arg, "--init-binary-check="))
+			exitIfError(err, "--init-binary-check")
+			options.skipBinaryCheck = !check
+		case name == "--no-proc":
+			options.noProc = parseBoolFlag(arg)
+		case name == "--standard-mounts":
+			options.standardMounts = parseBoolFlag(arg)
+		case name == "--private-tmp":
+			options.privateTmp = parseBoolFlag(arg)
+		case name == "--mount-cgroup":
+			options.mountCgroup = "ro"
+			if _, value, ok := strings.Cut(arg, "="); ok {
+				options.mountCgroup = value
+			}
+
+			if options.mountCgroup != "ro" && options.mountCgroup != "rw" {
+				log.Fatalf("invalid --mount-cgroup value: %s (expected ro or rw)", options.mountCgroup)
+			}
+		case strings.HasPrefix(arg, "--mount-propagation="):
+			options.mountPropagation = strings.TrimPrefix(arg, "--mount-propagation=")
+			if options.mountPropagation != "private" && options.mountPropagation != "slave" {
+				log.Fatalf("invalid --mount-propagation value: %s (expected private or slave)", options.mountPropagation)
+			}
+		case name == "--read-only":
+			options.readOnly = parseBoolFlag(arg)
+		case name == "--no-new-privileges":
+			options.noNewPrivileges = parseBoolFlag(arg)
+		case name == "--rm":
+			options.autoRemove = parseBoolFlag(arg)
+		case name == "--log":
+			options.log = parseBoolFlag(arg)
+		case strings.HasPrefix(arg, "--log-opt="):
+			exitIfError(parseLogOpt(strings.TrimPrefix(arg, "--log-opt="), &options), "--log-opt")
+		case name == "-d":
+			options.detach = parseBoolFlag(arg)
+		case name == "--wait-ready":
+			options.waitReady = parseBoolFlag(arg)
+		case name == "--kill-process-group":
+			options.killProcessGroup = parseBoolFlag(arg)
+		case name == "-t":
+			options.tty = parseBoolFlag(arg)
+		case name == "-i":
+			options.interactive = parseBoolFlag(arg)
+		case arg == "-it", arg == "-ti":
+			options.tty, options.interactive = true, true
+		case strings.HasPrefix(arg, "--rw-path="):
+			rwPath := strings.TrimPrefix(arg, "--rw-path=")
+			if !filepath.IsAbs(rwPath) {
+				log.Fatalf("--rw-path must be an absolute path: %s", rwPath)
+			}
+
+			options.rwPaths = append(options.rwPaths, filepath.Clean(rwPath))
+		case strings.HasPrefix(arg, "--cgroup-conf="):
+			file, value, ok := strings.Cut(strings.TrimPrefix(arg, "--cgroup-conf="), "=")
+			if !ok || len(file) == 0 {
+				log.Fatalf("invalid --cgroup-conf (expected <file>=<value>): %s", arg)
+			}
+
+			if options.cgroupConf == nil {
+				options.cgroupConf = map[string]string{}
+			}
+
+			options.cgroupConf[file] = value
+		case strings.HasPrefix(arg, "-m="), strings.HasPrefix(arg, "--memory="):
+			_, value, _ := strings.Cut(arg, "=")
+			memory, err := parseMemorySize(value)
+			exitIfError(err, "--memory")
+			options.memoryMax = memory
+		case strings.HasPrefix(arg, "--memory-high="):
+			memory, err := parseMemorySize(strings.TrimPrefix(arg, "--memory-high="))
+			exitIfError(err, "--memory-high")
+			options.memoryHigh = memory
+		case strings.HasPrefix(arg, "--cpus="):
+			cpus, err := parseCpus(strings.TrimPrefix(arg, "--cpus="))
+			exitIfError(err, "--cpus")
+			options.cpus = cpus
+		case strings.HasPrefix(arg, "--restart="):
+			policy, err := parseRestartPolicy(strings.TrimPrefix(arg, "--restart="))
+			exitIfError(err, "--restart")
+			options.restart = policy
+		case strings.HasPrefix(arg, "--health-cmd="):
+			options.healthCmd = strings.TrimPrefix(arg, "--health-cmd=")
+			if len(strings.TrimSpace(options.healthCmd)) == 0 {
+				log.Fatal("--health-cmd: the command can't be empty")
+			}
+		case strings.HasPrefix(arg, "--health-interval="):
+			interval, err := time.ParseDuration(strings.TrimPrefix(arg, "--health-interval="))
+			if err != nil || interval <= 0 {
+				log.Fatalf("invalid --health-interval value: %s (expected a duration like 30s or 5m)", strings.TrimPrefix(arg, "--health-interval="))
+			}
+
+			options.healthInterval = interval
+		case strings.HasPrefix(arg, "--health-retries="):
+			retries, err := strconv.Atoi(strings.TrimPrefix(arg, "--health-retries="))
+			if err != nil || retries <= 0 {
+				log.Fatalf("invalid --health-retries value: %s (expected a positive integer)", strings.TrimPrefix(arg, "--health-retries="))
+			}
+
+			options.healthRetries = retries
+		case strings.HasPrefix(arg, "--timeout="):
+			timeout, err := time.ParseDuration(strings.TrimPrefix(arg, "--timeout="))
+			if err != nil || timeout <= 0 {
+				log.Fatalf("invalid --timeout value: %s (expected a duration like 30s or 5m)", strings.TrimPrefix(arg, "--timeout="))
+			}
+
+			options.timeout = timeout
+		case strings.HasPrefix(arg, "--ttl="):
+			ttl, err := time.ParseDuration(strings.TrimPrefix(arg, "--ttl="))
+			if err != nil || ttl <= 0 {
+				log.Fatalf("invalid --ttl value: %s (expected a duration like 30m or 1h)", strings.TrimPrefix(arg, "--ttl="))
+			}
+
+			options.ttl = ttl
+		case strings.HasPrefix(arg, "--pids-limit="):
+			limit, err := parsePidsLimit(strings.TrimPrefix(arg, "--pids-limit="))
+			exitIfError(err, "--pids-limit")
+			options.pidsLimit = limit
+		case strings.HasPrefix(arg, "--ulimit="):
+			limit, err := parseUlimit(strings.TrimPrefix(arg, "--ulimit="))
+			exitIfError(err, "--ulimit")
+			options.ulimits = append(options.ulimits, limit)
+		case strings.HasPrefix(arg, "--device="):
+			device, err := parseDeviceSpec(strings.TrimPrefix(arg, "--device="))
+			exitIfError(err, "--device")
+			options.devices = append(options.devices, device)
+		case strings.HasPrefix(arg, "--device-read-iops="):
+			options.ioThrottles = append(options.ioThrottles, parseIoThrottle(arg, "riops"))
+		case strings.HasPrefix(arg, "--device-write-iops="):
+			options.ioThrottles = append(options.ioThrottles, parseIoThrottle(arg, "wiops"))
+		case strings.HasPrefix(arg, "--device-read-bps="):
+			options.ioThrottles = append(options.ioThrottles, parseIoThrottle(arg, "rbps"))
+		case strings.HasPrefix(arg, "--device-write-bps="):
+			options.ioThrottles = append(options.ioThrottles, parseIoThrottle(arg, "wbps"))
+		case strings.HasPrefix(arg, "--blkio-weight="):
+			weight, err := parseIoWeight(strings.TrimPrefix(arg, "--blkio-weight="))
+			exitIfError(err, "--blkio-weight")
+			options.ioWeight = weight
+		default:
+			log.Fatalf("unknown flag %s", arg)
+		}
+	}
+
+	exitIfError(applyIsolationPreset(&options, isolation, explicit), "--isolation")
+
+	// the first argument can be an image in imagesDir, e.g. focker run alpine /bin/sh. the
+	// parent passes the tarball on to the child as --image
+	if len(options.image) == 0 && len(options.rootfsSquashfs) == 0 {
+		tarball, ok, err := lookupImage(firstArg(args))
+		exitIfError(err, "run")
+		if ok {
+			options.image, args = tarball, args[1:]
+		} else if len(defaults.Image) > 0 {
+			options.image, ok, err = lookupImage(defaults.Image)
+			exitIfError(err, "--config")
+			if !ok {
+				log.Fatalf("--config: image %s isn't present locally, pull it with focker pull", defaults.Image)
+			}
+		} else {
+			options.image, err = defaultImage()
+			exitIfError(err, "run")
+		}
+	}
+
+	// without a command, the container runs the image's default one
+	if len(args) == 0 && len(options.image) > 0 {
+		var err error
+		args, err = imageDefaultCommand(options.image)
+		exitIfError(err, "run")
+	}
+
+	if len(options.entrypoint) > 0 {
+		if len(args) == 0 {
+			args = options.entrypoint
+		} else {
+			args = append(slices.Clip(options.entrypoint), args[1:]...)
+		}
+	}
+
+	if len(args) == 0 && len(options.rootfsSquashfs) > 0 {
+		log.Fatal("run: no command was given, which --rootfs-squashfs needs since its image has no default command")
+	}
+
+	if len(args) == 0 {
+		log.Fatalf("run: no command was given & the image has no default command (%s)", imageCommandFile(options.image))
+	}
+
+	for i := range options.mounts {
+		options.mounts[i].target = resolveMountTarget(options.mounts[i].target, options.workdir)
+	}
+
+	// the volumes from the defaults are replaced by the ones given to run at the same target
+	var mounts []mountSpec
+	for i, mount := range options.mounts {
+		if i >= defaultMounts || !hasMountAt(options.mounts[defaultMounts:], mount.target) {
+			mounts = append(mounts, mount)
+		}
+	}
+
+	options.mounts = mounts
+
+	if options.replace && len(options.name) == 0 {
+		log.Fatal("--replace can only be used with --name")
+	}
+
+	if options.logMode == logModeNonBlocking && !options.log && !options.detach {
+		log.Fatal("--log-opt mode=non-blocking can only be used with --log or -d, there's no log to write to otherwise")
+	}
+
+	if options.logBufferSize > 0 && options.logMode != logModeNonBlocking {
+		log.Fatal("--log-opt max-buffer-size can only be used with --log-opt mode=non-blocking")
+	}
+
+	if options.logMode == logModeNonBlocking && options.logBufferSize == 0 {
+		options.logBufferSize = defaultLogBufferSize
+	}
+
+	// squashfs has no way to shift the owners of its files to the ids of a user namespace, which
+	// is what the second copy of an extracted tarball is for
+	if len(options.rootfsSquashfs) > 0 && options.userns != "host" {
+		log.Fatal("--rootfs-squashfs can only be used with --userns=host, the files of the image can't be owned by the ids of the container's user namespace")
+	}
+
+	if options.waitReady && !options.detach {
+		log.Fatal("--wait-ready can only be used with -d")
+	}
+
+	if options.restart.name != "no" && !options.detach {
+		log.Fatal("--restart can only be used with -d, since the container is restarted in the background")
+	}
+
+	if options.restart.name != "no" && options.autoRemove {
+		log.Fatal("--restart can't be used with --rm, the container would be removed before it could be restarted")
+	}
+
+	if len(options.healthCmd) > 0 && !options.detach {
+		log.Fatal("--health-cmd can only be used with -d, since the container is checked in the background")
+	}
+
+	if (explicit["--health-interval"] || explicit["--health-retries"]) && len(options.healthCmd) == 0 {
+		log.Fatal("--health-interval & --health-retries can only be used with --health-cmd")
+	}
+
+	if len(options.hostname) > 0 && options.uts == "host" {
+		log.Fatal("--hostname can't be used with --uts=host, it would change the host's hostname")
+	}
+
+	if len(options.ip) > 0 && options.net != "bridge" {
+		log.Fatal("--ip can only be used with --net=bridge")
+	}
+
+	if len(options.ports) > 0 && options.net != "bridge" {
+		log.Fatal("-p can only be used with --net=bridge, the container isn't reachable from the host otherwise")
+	}
+
+	if len(options.rwPaths) > 0 && !options.readOnly {
+		log.Fatal("--rw-path can only be used with --read-only")
+	}
+
+	if options.replicas > 1 && (options.tty || options.interactive) {
+		log.Fatal("--replicas can't be used with -t or -i, the replicas can't share the terminal")
+	}
+
+	if options.replicas > 1 && (len(options.ip) > 0 || len(options.ports) > 0) {
+		log.Fatal("--replicas can't be used with --ip or -p, each replica would need the same address or host port")
+	}
+
+	if options.memoryMax > 0 && options.memoryHigh >= options.memoryMax {
+		log.Fatal("--memory-high must be less than --memory, otherwise the container is OOM killed before it's ever throttled")
+	}
+
+	return options, args
+}
+
+// containerHostname returns the hostname of the container, which is its id unless --hostname is
+// given. the container's directory is always named after the id, so hostnames can be shared
+func (options *runOptions) containerHostname(containerId string) string {
+	if len(options.hostname) > 0 {
+		return options.hostname
+	}
+
+	return containerId
+}
+
+// checkHostname checks that a hostname is valid, i.e. that it consists of labels of letters,
+// digits & hyphens that are separated by dots. one that's too long is shortened by truncateHostname
+func checkHostname(hostname string) error {
+	if !hostnamePattern.MatchString(hostname) {
+		return fmt.Errorf("invalid hostname %q", hostname)
+	}
+
+	return nil
+}
+
+// the kernel's limit on the length of a hostname, in bytes
+const maxHostnameLength = 64
+
+// truncateHostname shortens a hostname that's longer than maxHostnameLength to its start & a short
+// hash of the whole of it, so that the same hostname is always shortened the same way & two that
+// only differ in the part that's cut off don't end up the same. shorter ones are returned as is
+func truncateHostname(hostname string) string {
+	if len(hostname) <= maxHostnameLength {
+		return hostname
+	}
+
+	sum := sha256.Sum256([]byte(hostname))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+
+	// the hash can't follow a dot or a hyphen, since a label can't start or end with a hyphen
+	return strings.TrimRight(hostname[:maxHostnameLength-len(suffix)], ".-") + suffix
+}
+
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+// firstArg returns the first of args, or "" if there are none
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	return args[0]
+}
+
+// parseBoolFlag parses a boolean flag, which is true when given without a value, e.g. --read-only
+// or --read-only=false
+func parseBoolFlag(arg string) bool {
+	name, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return true
+	}
+
+	b, err := strconv.ParseBool(value)
+	exitIfError(err, name)
+	return b
+}
+
+// childArgs turns the options that the _child process needs back into command-line flags
+func (options *runOptions) childArgs() []string {
+	args := []string{"--image=" + options.image}
+	if len(options.rootfsSquashfs) > 0 {
+		args = []string{"--rootfs-squashfs=" + options.rootfsSquashfs}
+	}
+
+	// pass the mounts again as --mount= command-line arguments, since -v can't express all options
+	for _, mount := range options.mounts {
+		args = append(args, "--mount="+mount.String())
+	}
+
+	for _, v := range options.env {
+		args = append(args, "-e="+v)
+	}
+
+	if options.skipBinaryCheck {
+		args = append(args, "--init-binary-check=false")
+	}
+
+	if options.noProc {
+		args = append(args, "--no-proc")
+	}
+
+	// the tmpfs mounts of the profile are already in the mounts, but the child still needs the
+	// flag for /sys
+	if options.standardMounts {
+		args = append(args, "--standard-mounts")
+	}
+
+	for _, device := range options.devices {
+		args = append(args, "--device="+device.String())
+	}
+
+	args = append(args, "--mount-propagation="+options.mountPropagation)
+
+	if len(options.mountCgroup) > 0 {
+		args = append(args, "--mount-cgroup="+options.mountCgroup)
+	}
+
+	if options.readOnly {
+		args = append(args, "--read-only")
+	}
+
+	if options.tty {
+		args = append(args, "-t")
+	}
+
+	if options.killProcessGroup {
+		args = append(args, "--kill-process-group")
+	}
+
+	if len(options.hostname) > 0 {
+		args = append(args, "--hostname="+options.hostname)
+	}
+
+	if len(options.workdir) > 0 {
+		args = append(args, "-w="+options.workdir)
+	}
+
+	if len(options.user) > 0 {
+		args = append(args, "-u="+options.user)
+	}
+
+	if len(options.uts) > 0 {
+		args = append(args, "--uts="+options.uts)
+	}
+
+	if len(options.net) > 0 {
+		args = append(args, "--net="+options.net)
+	}
+
+	// the address was allocated by the parent & goes into the container's /etc/hosts
+	if len(options.ip) > 0 {
+		args = append(args, "--ip="+options.ip)
+	}
+
+	for _, nameserver := range options.dns {
+		args = append(args, "--dns="+nameserver)
+	}
+
+	if len(options.userns) > 0 {
+		args = append(args, "--userns="+options.userns)
+	}
+
+	for _, capability := range options.capAdd {
+		args = append(args, "--cap-add="+capability)
+	}
+
+	for _, capability := range options.capDrop {
+		args = append(args, "--cap-drop="+capability)
+	}
+
+	if options.noNewPrivileges {
+		args = append(args, "--no-new-privileges")
+	}
+
+	if len(options.seccomp) > 0 {
+		args = append(args, "--seccomp="+options.seccomp)
+	}
+
+	for _, rwPath := range options.rwPaths {
+		args = append(args, "--rw-path="+rwPath)
+	}
+
+	for _, limit := range options.ulimits {
+		args = append(args, "--ulimit="+limit.String())
+	}
+
+	return args
+}
+
+// run returns the exit code that focker should exit with, or an error if the container couldn't
+// be set up. it doesn't call os.Exit itself so that the deferred cleanup runs
+func run(containerId string, args []string, options runOptions, isChild bool) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.New("at least 1 argument is required")
+	}
+
+	// the pid namespace of the container that --pid=container: joins, if any
+	var pidNamespace string
+
+	if !isChild && len(options.rootfsSquashfs) == 0 {
+		if err := checkRootfsTarball(options.image, options.containerRootId()); err != nil {
+			return 0, err
+		}
+	}
+
+	if !isChild {
+
+		if len(options.pid) > 0 && options.pid != "host" {
+			// the child is born in another container's pid namespace instead of a new one
+			var err error
+			if pidNamespace, err = containerPidNamespace(options.pid); err != nil {
+				return 0, fmt.Errorf("--pid: %w", err)
+			}
+		}
+
+		for _, source := range options.volumesFrom {
+			volumes, err := readContainerVolumes(source)
+			if err != nil {
+				return 0, fmt.Errorf("--volumes-from: %w", err)
+			}
+
+			// the user's own mounts take precedence
+			for _, volume := range volumes {
+				if !hasMountAt(options.mounts, volume.target) {
+					options.mounts = append(options.mounts, volume)
+				}
+			}
+		}
+
+		if err := resolveBindSources(options.mounts); err != nil {
+			return 0, fmt.Errorf("-v: %w", err)
+		}
+
+		if options.standardMounts {
+			options.mounts = withStandardMounts(options.mounts)
+		} else if options.privateTmp {
+			options.mounts = withPrivateTmp(options.mounts)
+		}
+
+		if options.needsCgroup() {
+			if err := checkCgroupV2(); err != nil {
+				return 0, err
+			}
+
+			if err := checkResourceLimits(&options); err != nil {
+				return 0, err
+			}
+		}
+
+		if options.net == "bridge" {
+			ip, err := allocateContainerIp(options.ip)
+			if err != nil {
+				return 0, fmt.Errorf("--ip: %w", err)
+			}
+
+			options.ip = ip
+		}
+
+		// the tarballs are only extracted by the first container
+		if len(options.rootfsSquashfs) == 0 {
+			if _, err := prepareRootfsLowers(options.image, options.containerRootId()); err != nil {
+				return 0, fmt.Errorf("extract rootfs: %w", err)
+			}
+		}
+
+		if len(options.name) > 0 {
+			if err := checkContainerName(options.name); err != nil {
+				return 0, fmt.Errorf("--name: %w", err)
+			}
+		}
+
+		// the parent picks the container id so that it knows where the container lives on disk,
+		// unless run -d already did
+		if len(containerId) == 0 {
+			var err error
+			if containerId, err = createContainerDir(); err != nil {
+				return 0, fmt.Errorf("mkdir container dir: %w", err)
+			}
+		} else if err := os.MkdirAll(containerDir(containerId), 0700); err != nil {
+			return 0, fmt.Errorf("mkdir container dir: %w", err)
+		}
+	}
+
+	// the config of the container, which is only maintained by the parent
+	var config *containerConfig
+	if !isChild {
+		config = &containerConfig{Id: containerId, Name: options.name, Labels: options.labels, State: stateCreated, Command: args, Created: time.Now(), Ip: options.ip}
+		config.ParentPid = os.Getpid()
+		config.ParentStarted, _ = processStartTime(config.ParentPid)
+
+		// the manifest of the image was read by checkRootfsTarball already
+		config.Rootfs = options.rootfsSquashfs
+		if len(config.Rootfs) == 0 {
+			lowerDirs, _ := rootfsLowerDirs(options.image, options.containerRootId())
+			config.Rootfs = strings.Join(lowerDirs, ":")
+		}
+		config.Hostname = options.containerHostname(containerId)
+		if options.uts == "host" {
+			config.Hostname, _ = os.Hostname()
+		}
+
+		config.Workdir = options.workdir
+		if len(config.Workdir) == 0 {
+			config.Workdir = "/"
+		}
+
+		config.User, config.CapAdd, config.CapDrop = options.user, options.capAdd, options.capDrop
+		config.NoNewPrivileges, config.Seccomp = options.noNewPrivileges, options.seccomp
+		for _, limit := range options.ulimits {
+			config.Ulimits = append(config.Ulimits, limit.String())
+		}
+
+		for _, port := range options.ports {
+			config.Ports = append(config.Ports, port.String())
+		}
+
+		for _, mount := range options.mounts {
+			config.Mounts = append(config.Mounts, mount.String())
+		}
+
+		if options.needsCgroup() {
+			config.Limits = options.limits()
+		}
+
+		if len(options.healthCmd) > 0 {
+			config.Health = &containerHealth{
+				Command:  options.healthCmd,
+				Interval: options.healthInterval.String(),
+				Retries:  options.healthRetries,
+				Status:   healthStarting,
+			}
+		}
+
+		if options.restart.name != "no" {
+			config.RestartPolicy = options.restart.String()
+			config.RestartCount = options.restartCount
+		}
+
+		// a restarted container is still the same container
+		if options.restartCount > 0 {
+			if previous, err := readContainerConfig(containerId); err == nil {
+				config.Created = previous.Created
+			}
+		}
+
+		if options.ttl > 0 {
+			expires := config.Created.Add(options.ttl)
+			config.Expires = &expires
+		}
+
+		// the name is taken once the config is written, so no other focker run may check or take
+		// it in between. a restarted container still has it
+		unlock := func() {}
+		if len(config.Name) > 0 && options.restartCount == 0 {
+			var err error
+			if unlock, err = lockContainers(); err != nil {
+				os.RemoveAll(containerDir(containerId))
+				return 0, fmt.Errorf("lock containers: %w", err)
+			}
+
+			if err := claimContainerName(config, options.replace); err != nil {
+				unlock()
+				os.RemoveAll(containerDir(containerId))
+				return 0, fmt.Errorf("--name: %w", err)
+			}
+		}
+
+		err := writeContainerConfig(config)
+		unlock()
+		if err != nil {
+			// without its config, the container's directory would just be left behind
+			os.RemoveAll(containerDir(containerId))
+			return 0, fmt.Errorf("write config: %w", err)
+		}
+	}
+
+	// a container whose setup fails (here or in the child) never ran, so it's removed again
+	// rather than being left behind for ps to list. the failures before the child is started
+	// undo what they set up themselves & so does the usual cleanup after the child exits, so
+	// this only has to take care of its mounts & its directory. a restarted container keeps its
+	// history & is only marked as exited
+	setupDone := false
+	if !isChild && options.restartCount == 0 {
+		defer func() {
+			if !setupDone {
+				discardContainer(containerId)
+			}
+		}()
+	}
+
+	// the child's mount namespace gets a copy of the squashfs mount when it's created, which is
+	// what its overlay rootfs is mounted from. ours is unmounted once the container has exited
+	if !isChild && len(options.rootfsSquashfs) > 0 {
+		if err := mountSquashfs(options.rootfsSquashfs, containerSquashfsDir(containerId)); err != nil {
+			return 0, fmt.Errorf("--rootfs-squashfs: %w", err)
+		}
+
+		debugf("mounted %s at %s", options.rootfsSquashfs, containerSquashfsDir(containerId))
+	}
+
+	// if isChild is true, then it means that we're inside the container
+
+	var commandName string
+	var commandArgs []string
+	if isChild {
+		// if this is the child process, then we run the command that the user passed
+		commandName = args[0]
+		if len(args) > 1 {
+			commandArgs = args[1:]
+		}
+	} else {
+		// otherwise we'll run this program itself in a separate process with an internal
+		// _child command and it will be responsible for running user specified command
+		path, err := os.Executable()
+		if err != nil {
+			return 0, fmt.Errorf("os.Executable(): %w", err)
+		}
+
+		commandName = path
+		commandArgs = append(commandArgs, "_child", containerId)
+		commandArgs = append(commandArgs, options.childArgs()...)
+
+		// the command can start with a dash too
+		commandArgs = append(commandArgs, "--")
+		commandArgs = append(commandArgs, args...)
+	}
+
+	// with --timeout, the container is stopped like focker stop does once it's up, i.e. with
+	// SIGTERM & then SIGKILL after the grace period
+	ctx := context.Background()
+	if !isChild && options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
+	}
+
+	// create Cmd struct to execute the given command
+	cmd := exec.CommandContext(ctx, commandName, commandArgs...)
+	var timedOut atomic.Bool
+	cmd.Cancel = func() error {
+		timedOut.Store(true)
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = defaultStopTimeout
+
+	// wire child process's stdin, stdout & stderr to that of current process
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// with -d, our stdout & stderr already are the log file
+	if !isChild && options.log && !options.detach {
+		// the output still goes to our stdout & stderr, but through pipes, so the command's
+		// stdout & stderr aren't a terminal anymore
+		logFile, err := os.OpenFile(containerLogPath(containerId), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return 0, fmt.Errorf("open log file: %w", err)
+		}
+
+		defer logFile.Close()
+
+		cmd.Stdout = io.MultiWriter(os.Stdout, logFile)
+		cmd.Stderr = io.MultiWriter(os.Stderr, logFile)
+	}
+
+	// with --log-opt mode=non-blocking, the output goes through buffers instead, which are flushed
+	// once the container has exited
+	flushOutput := func() {}
+	if !isChild && options.logMode == logModeNonBlocking {
+		stdout := newNonBlockingLogWriter(cmd.Stdout, options.logBufferSize)
+		stderr := newNonBlockingLogWriter(cmd.Stderr, options.logBufferSize)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		flushOutput = func() {
+			stdout.Close()
+			stderr.Close()
+		}
+
+		defer flushOutput()
+	}
+
+	// with -t, the container's stdio is the slave side of a new terminal, whose output goes to
+	// where it would've otherwise gone. the child makes it the command's controlling terminal
+	var tty *containerTty
+	var ttyOutput io.Writer
+	if !isChild && options.tty {
+		var err error
+		tty, err = newContainerTty()
+		if err != nil {
+			return 0, fmt.Errorf("-t: %w", err)
+		}
+
+		ttyOutput = cmd.Stdout
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = tty.slave, tty.slave, tty.slave
+	}
+
+	if isChild {
+		// set hostname inside container to the container id, unless we share the host's one
+		if options.uts != "host" {
+			if err := syscall.Sethostname([]byte(options.containerHostname(containerId))); err != nil {
+				return 0, fmt.Errorf("set hostname: %w", err)
+			}
+
+			debugf("set the hostname to %s", options.containerHostname(containerId))
+		}
+
+		if options.net != "host" {
+			if err := bringUpLoopback(); err != nil {
+				return 0, fmt.Errorf("bring up loopback: %w", err)
+			}
+
+			debugf("brought up the loopback interface")
+		}
+
+		// the new mount namespace starts off with copies of the host's mounts, which are still
+		// peers of the host's ones if they're shared. nothing that's mounted from here on may
+		// propagate to the host, so this has to come before any mount
+		propagation := uintptr(syscall.MS_PRIVATE)
+		if options.mountPropagation == "slave" {
+			propagation = syscall.MS_SLAVE
+		}
+
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|propagation, ""); err != nil {
+			return 0, fmt.Errorf("make mounts %s: %w", options.mountPropagation, err)
+		}
+
+		debugf("made the mounts %s", options.mountPropagation)
+
+		// mount the rootfs, which the parent has already extracted (or mounted, with --rootfs-squashfs)
+		rootfsDir := containerRootfsDir(containerId)
+		lowerDirs := []string{containerSquashfsDir(containerId)}
+		if len(options.rootfsSquashfs) == 0 {
+			var err error
+			if lowerDirs, err = rootfsLowerDirs(options.image, options.containerRootId()); err != nil {
+				return 0, fmt.Errorf("mount rootfs: %w", err)
+			}
+		}
+
+		if err := mountRootfs(containerId, lowerDirs); err != nil {
+			return 0, fmt.Errorf("mount rootfs: %w", err)
+		}
+
+		debugf("mounted the rootfs at %s", rootfsDir)
+
+		// the devices may have to be bind mounted from the host, so this has to be done before
+		// pivot_root. the rootfs of an image usually has an empty /dev
+		if !hasMountAt(options.mounts, "/dev") {
+			if err := mountMinimalDev(rootfsDir, options.containerRootId()); err != nil {
+				return 0, fmt.Errorf("mount /dev: %w", err)
+			}
+
+			if options.tty {
+				if err := mountConsole(rootfsDir); err != nil {
+					return 0, fmt.Errorf("mount /dev/console: %w", err)
+				}
+			}
+
+			debugf("mounted a minimal /dev")
+		}
+
+		if err := createDevices(rootfsDir, options.devices, options.containerRootId()); err != nil {
+			return 0, err
+		}
+
+		// map volumes to share storage between host & container & mount the tmpfs & secrets.
+		// this is done before pivot_root because the sources of the bind mounts are on the host
+		mountedTargets, err := mountAll(containerId, rootfsDir, options.mounts, options.containerRootId())
+		if err != nil {
+			// what was mounted goes away along with our mount namespace
+			return 0, err
+		}
+
+		// this needs the host's /etc/resolv.conf, which is gone after pivot_root
+		resolvConf := containerResolvConf(options.dns, options.net == "host")
+
+		// set the root directory inside the container to the extracted rootfs
+		// abortIfError(syscall.Chroot(rootfsDir), "chroot")
+		if err := pivotRoot(rootfsDir); err != nil {
+			return 0, fmt.Errorf("pivot root: %w", err)
+		}
+
+		// defer the unmounting of all mounts, in reverse order so that nested ones go first. the
+		// targets are paths inside the container, which is where they are from now on, so this
+		// comes after pivot_root (if the setup fails before, they go away along with our mount
+		// namespace). they're detached since a recursive bind mount can have mounts under it
+		defer func() {
+			for i := len(mountedTargets) - 1; i >= 0; i-- {
+				if err := syscall.Unmount(mountedTargets[i], syscall.MNT_DETACH); err != nil {
+					log.Printf("failed to unmount %s: %v", mountedTargets[i], err)
+				}
+			}
+		}()
+
+		// set procfs: tell kernel that for this process (& it's children), use this new /proc directory as procfs
+		// for procfs, first arg can be anything ig because the kernal ignores it (based on chat with claude & my experiments)
+		// the mount point has to exist before the rootfs can be made read-only, even with
+		// --no-proc, since procfs is still mounted there while the command is being started
+		if err := os.MkdirAll("/proc", 0555); err != nil {
+			return 0, fmt.Errorf("mkdir /proc: %w", err)
+		}
+
+		if !options.noProc {
+			if err := mountProc(); err != nil {
+				return 0, fmt.Errorf("mount procfs: %w", err)
+			}
+
+			debugf("mounted /proc")
+
+			defer syscall.Unmount("/proc", 0)
+		}
+
+		if options.standardMounts && !hasMountAt(options.mounts, "/sys") {
+			if err := mountReadOnlySys(); err != nil {
+				return 0, fmt.Errorf("mount /sys: %w", err)
+			}
+
+			defer syscall.Unmount("/sys", 0)
+		}
+
+		// the container's cgroup namespace has its cgroup as the root, so this only shows the
+		// container's cgroup (& those it creates under it with --mount-cgroup=rw)
+		if len(options.mountCgroup) > 0 && !hasMountAt(options.mounts, cgroupRoot) {
+			if err := mountContainerCgroup(options.mountCgroup == "ro"); err != nil {
+				return 0, fmt.Errorf("mount %s: %w", cgroupRoot, err)
+			}
+
+			debugf("mounted the container's cgroup at %s", cgroupRoot)
+
+			defer syscall.Unmount(cgroupRoot, 0)
+		}
+
+		// these have to be done before making the rootfs read-only. files that are mounted over
+		// by the user are left alone, as is all of a mounted /etc, which can be the host's
+		etcMounted := hasMountAt(options.mounts, "/etc")
+		if !etcMounted && !hasMountAt(options.mounts, "/etc/mtab") {
+			if err := linkMtab(); err != nil {
+				return 0, fmt.Errorf("link /etc/mtab: %w", err)
+			}
+		}
+
+		if !etcMounted && !hasMountAt(options.mounts, "/etc/resolv.conf") {
+			if err := writeEtcFile("resolv.conf", resolvConf, options.containerRootId()); err != nil {
+				return 0, fmt.Errorf("write /etc/resolv.conf: %w", err)
+			}
+		}
+
+		if !etcMounted && !hasMountAt(options.mounts, "/etc/hosts") {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return 0, fmt.Errorf("os.Hostname(): %w", err)
+			}
 
-		if len(os.Args) > 2 {
-			for _, arg := range os.Args[2:] {
-				if strings.HasPrefix(arg, "-v=") {
-					volumes = append(volumes, strings.TrimPrefix(arg, "-v="))
-				} else {
-					args = append(args, arg)
-				}
+			if err := writeEtcFile("hosts", containerHosts(hostname, options.ip), options.containerRootId()); err != nil {
+				return 0, fmt.Errorf("write /etc/hosts: %w", err)
 			}
 		}
 
-		run(args, volumes, command == "_child")
+		if options.readOnly {
+			defer func() {
+				for _, rwPath := range options.rwPaths {
+					syscall.Unmount(rwPath, 0)
+				}
+			}()
 
-	case "ps":
-		ps()
+			if err := mountReadOnlyRoot(options.rwPaths, options.containerRootId()); err != nil {
+				return 0, fmt.Errorf("--read-only: %w", err)
+			}
 
-	default:
-		log.Fatal("bad command")
-	}
-}
+			debugf("made the rootfs read-only")
+		}
 
-func run(args []string, volumes []string, isChild bool) {
-	if len(args) == 0 {
-		log.Fatal("at least 1 argument is required")
-	}
+		// the command inherits our working directory, which is also what a relative path of the
+		// command is resolved against. it's done after the mounts since it can be in a volume
+		if len(options.workdir) > 0 {
+			if err := os.Chdir(options.workdir); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid working directory %s in container: %v\n", options.workdir, errors.Unwrap(err))
+				return 126, nil
+			}
+		}
 
-	// if isChild is true, then it means that we're inside the container
+		// the user is looked up in the container's own /etc/passwd, so only now that it's our root.
+		// the helper switches to it right before executing the command, see execHelperCommand
+		env := options.env
+		if len(options.user) > 0 {
+			user, err := lookupContainerUser("/", options.user)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid user %s in container: %v\n", options.user, err)
+				return 126, nil
+			}
 
-	var commandName string
-	var commandArgs []string
-	if isChild {
-		// if this is the child process, then we run the command that the user passed
-		commandName = args[0]
-		if len(args) > 1 {
-			commandArgs = args[1:]
+			options.user = user.String()
+
+			// -e can still override it
+			env = append([]string{"HOME=" + user.home}, env...)
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return 0, fmt.Errorf("os.Hostname(): %w", err)
 		}
-	} else {
-		// otherwise we'll run this program itself in a separate process with an internal
-		// _child command and it will be responsible for running user specified command
-		path, err := os.Executable()
-		exitIfError(err, "os.Executable()")
-		commandName = path
-		commandArgs = append(commandArgs, "_child")
 
-		// pass the volumes again with -v= add command-line arguments
-		if len(volumes) > 0 {
-			volumeArgs := make([]string, len(volumes))
-			for i, vol := range volumes {
-				volumeArgs[i] = "-v=" + vol
+		cmd.Env = containerEnv(hostname, env)
+
+		// exec.Command looked the command up on the host's filesystem, so look it up again now
+		// that we're inside the container's rootfs, with the container's PATH
+		os.Setenv("PATH", lookupEnv(cmd.Env, "PATH"))
+		path, err := exec.LookPath(commandName)
+		if err != nil {
+			if !options.skipBinaryCheck {
+				// exit with 127 like shells do when a command can't be found & with 126 when
+				// it can't be executed
+				fmt.Fprintln(os.Stderr, explainLookPathError(commandName, err))
+				if isExecNotFound(err) {
+					return 127, nil
+				}
+
+				return 126, nil
 			}
 
-			commandArgs = append(commandArgs, volumeArgs...)
+			path = commandName
 		}
 
-		commandArgs = append(commandArgs, args...)
-	}
+		// the helper sets the limits of the command, but it may not be allowed to raise them
+		if err := raiseUlimits(options.ulimits); err != nil {
+			return 0, fmt.Errorf("--ulimit: %w", err)
+		}
 
-	// create Cmd struct to execute the given command
-	cmd := exec.Command(commandName, commandArgs...)
+		// if we were to configure the above things in the main process, then it would have
+		// modified the system's hostname, root etc.
 
-	// wire child process's stdin, stdout & stderr to that of current process
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+		// let the parent know that the container is ready. we send our pid as seen from inside
+		// the pid namespace (which is 1) because the parent only knows our pid on the host. then
+		// we wait for the parent to ack so that its output comes before that of the command
+		readyPipe := os.NewFile(readyPipeFd, "ready-pipe")
+		fmt.Fprintln(readyPipe, os.Getpid())
+		readyPipe.Read(make([]byte, 1))
+		readyPipe.Close()
 
-	if isChild {
-		containerId := "b-" + randomString(16)
+		// orphans of the container only come to pid 1 by themselves
+		if os.Getpid() != 1 {
+			if err := becomeSubreaper(); err != nil {
+				return 0, fmt.Errorf("become a subreaper: %w", err)
+			}
+		}
 
-		// set hostname inside container to a random string
-		exitIfError(syscall.Sethostname([]byte(containerId)), "set hostname")
+		// the command is started through the _exec helper, which drops its capabilities. it's
+		// started from /proc/self/exe, so with --no-proc, procfs is mounted only until the
+		// helper has started & it waits until it's gone before executing the command
+		helper := execHelperCommand(path, cmd.Args, options)
+		helper.Stdin, helper.Stdout, helper.Stderr, helper.Env = cmd.Stdin, cmd.Stdout, cmd.Stderr, cmd.Env
+		if options.userns != "host" {
+			helper.SysProcAttr = usernsSysProcAttr(options.containerRootId())
+		}
 
-		// extract the rootfs tarball
-		rootfsDir := filepath.Join(containersDir, containerId)
-		unzipRootFsTarball(rootfsDir, rootFsTarball)
+		// with -t, the command gets a session of its own with our stdin (the terminal that the
+		// parent allocated) as its controlling terminal, so that job control works
+		if options.tty {
+			if helper.SysProcAttr == nil {
+				helper.SysProcAttr = &syscall.SysProcAttr{}
+			}
 
-		// map volumes to share storage between host & container
-		mountedVolumes := make([]string, len(volumes))
-		for i, volume := range volumes {
-			parts := strings.Split(volume, ":")
-			if len(parts) != 2 {
-				log.Fatalf("invalid volume mapping: %s", volume)
+			helper.SysProcAttr.Setsid, helper.SysProcAttr.Setctty, helper.SysProcAttr.Ctty = true, true, 0
+		} else if options.killProcessGroup {
+			// signals are passed on to the process group of the command, which it has to lead.
+			// with -t, its session is a group of its own already
+			if helper.SysProcAttr == nil {
+				helper.SysProcAttr = &syscall.SysProcAttr{}
 			}
 
-			source := parts[0]
-			target := filepath.Join(rootfsDir, parts[1])
+			helper.SysProcAttr.Setpgid = true
+		}
+
+		var waitPipe *os.File
+		if options.noProc {
+			if err := mountProc(); err != nil {
+				return 0, fmt.Errorf("mount procfs: %w", err)
+			}
 
-			exitIfError(os.MkdirAll(target, 0700), "mkdir target")
-			exitIfError(syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""), "mount volume")
+			var helperEnd *os.File
+			helperEnd, waitPipe, err = os.Pipe()
+			if err != nil {
+				return 0, fmt.Errorf("os.Pipe(): %w", err)
+			}
 
-			// add to the list of mounted volumes
-			mountedVolumes[i] = parts[1]
+			helper.ExtraFiles = []*os.File{helperEnd}
 		}
 
-		// defer the unmounting of all volumes
-		defer func() {
-			for _, target := range mountedVolumes {
-				if err := syscall.Unmount(target, 0); err != nil {
-					log.Printf("failed to unmount %s: %v", target, err)
-				}
+		err = helper.Start()
+		if options.noProc {
+			helper.ExtraFiles[0].Close()
+			if err := syscall.Unmount("/proc", syscall.MNT_DETACH); err != nil {
+				return 0, fmt.Errorf("unmount procfs: %w", err)
 			}
-		}()
 
-		// set the root directory inside the container to the extracted rootfs
-		// abortIfError(syscall.Chroot(rootfsDir), "chroot")
-		pivotRoot(rootfsDir)
+			waitPipe.Close()
+		}
 
-		// set procfs: tell kernel that for this process (& it's children), use this new /proc directory as procfs
-		// for procfs, first arg can be anything ig because the kernal ignores it (based on chat with claude & my experiments)
-		exitIfError(syscall.Mount("proc", "/proc", "proc", 0, ""), "mount procfs")
-		defer syscall.Unmount("/proc", 0)
+		// the exit status of the command is reported by the parent
+		code := 126
+		if err == nil {
+			// with --kill-process-group, the rest of the group is waited for once it has got a
+			// signal, but not otherwise, since the container exits along with its command
+			var signaled chan os.Signal
+			if options.killProcessGroup {
+				signaled = make(chan os.Signal, 1)
+				signal.Notify(signaled, forwardedSignals...)
+			}
 
-		// if we were to configure the above things in the main process, then it would have
-		// modified the system's hostname, root etc.
+			stopForwarding := forwardSignals(helper.Process, options.killProcessGroup, nil)
+			var status syscall.WaitStatus
+			status, err = reapUntilExit(helper.Process.Pid)
+			if err == nil && len(signaled) > 0 {
+				reapProcessGroup(helper.Process.Pid)
+			}
+
+			stopForwarding()
+
+			if err == nil {
+				code = waitStatusExitCode(status)
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
 
-		fmt.Println("pid", os.Getpid(), "running", commandName)
+		return code, nil
 	} else {
 		// we want the child process that we're about to fork to be isolated
 		cmd.SysProcAttr = &syscall.SysProcAttr{
@@ -158,34 +1654,465 @@ func run(args []string, volumes []string, isChild bool) {
 			syscall.CLONE_NEWUTS |
 				// PID namespace: isolates process IDs
 				syscall.CLONE_NEWPID |
-				// Mount namespace: isolates mount points
+				// Network namespace: isolates network interfaces, routes, ports etc.
+				syscall.CLONE_NEWNET |
+				// IPC namespace: isolates System V IPC objects & POSIX message queues
+				syscall.CLONE_NEWIPC |
+				// cgroup namespace: makes the container's cgroup the root of /proc/self/cgroup
+				cloneNewCgroup |
+				// Mount namespace: isolates mount points. the child makes its mounts private (or
+				// slaves) before mounting anything, see --mount-propagation
 				syscall.CLONE_NEWNS,
+		}
+
+		if len(options.pid) > 0 {
+			// the child is started in the other container's pid namespace, or in the host's
+			cmd.SysProcAttr.Cloneflags &^= syscall.CLONE_NEWPID
+		}
+
+		if options.uts == "host" {
+			cmd.SysProcAttr.Cloneflags &^= syscall.CLONE_NEWUTS
+		}
+
+		if options.ipc == "host" {
+			cmd.SysProcAttr.Cloneflags &^= syscall.CLONE_NEWIPC
+		}
+
+		if options.net == "host" {
+			cmd.SysProcAttr.Cloneflags &^= syscall.CLONE_NEWNET
+		}
+	}
+
+	// the readiness pipe is passed to the child as an extra file. it writes its in-namespace
+	// pid to it once the container is set up & waits for us to ack. it's a socketpair rather
+	// than a pipe because it's used in both directions
+	readyPipe, childReadyPipe, err := newReadyPipe()
+	if err != nil {
+		return 0, fmt.Errorf("readiness pipe: %w", err)
+	}
+
+	cmd.ExtraFiles = []*os.File{childReadyPipe}
+
+	if options.needsCgroup() {
+		// the cgroup is removed once the container exits
+		if err := setupContainerCgroup(containerId, &options); err != nil {
+			return 0, fmt.Errorf("cgroup: %w", err)
+		}
+
+		// the child is cloned right into the cgroup, so that the command & anything it spawns is
+		// always in it, & so that it's the root of the child's cgroup namespace. a namespace
+		// created before moving the child would have our cgroup as its root instead
+		cgroupDir, err := os.Open(containerCgroupDir(containerId))
+		if err != nil {
+			removeContainerCgroup(containerId)
+			return 0, fmt.Errorf("cgroup: %w", err)
+		}
+		defer cgroupDir.Close()
+
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+	}
+
+	if err := startInPidNamespace(cmd, pidNamespace); err != nil {
+		if options.needsCgroup() {
+			removeContainerCgroup(containerId)
+		}
+
+		return 0, fmt.Errorf("start container: %w", err)
+	}
+
+	debugf("started the child of container %s with host pid %d", containerId, cmd.Process.Pid)
+
+	if tty != nil {
+		tty.start(ttyOutput, options.interactive)
+	}
+
+	// from now on, signals (e.g. from kill or a service manager) go to the container instead of
+	// killing us, so that we always get to wait for it & clean up after it. with -t, the terminal
+	// doesn't send any signals to us, since the container has its own
+	skipSignal := sentByTerminal
+	if options.tty {
+		skipSignal = nil
+	}
+
+	stopForwarding := forwardSignals(cmd.Process, false, skipSignal)
+
+	if options.net == "bridge" {
+		// the child's network namespace exists as soon as it's started & the command only runs
+		// after we ack its readiness, so the network is always up by then
+		if err := setupNetworking(containerId, cmd.Process.Pid, options.ip, options.ports); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			teardownNetworking(containerId, options.ip, options.ports)
+			if options.needsCgroup() {
+				removeContainerCgroup(containerId)
+			}
+
+			setContainerState(config, stateExited)
+
+			return 0, err
+		}
+	}
+
+	// close our copy of the child's end so that we get EOF if it dies before it's ready
+	childReadyPipe.Close()
+
+	hostPid := cmd.Process.Pid
+
+	// record the container's pid on the host so that other commands (like top) can find it
+	config.Pid = hostPid
+	if err := writeContainerConfig(config); err != nil {
+		log.Printf("failed to record the pid of container %s: %v", containerId, err)
+	}
+
+	containerPid := readContainerPid(readyPipe)
+	setupDone = containerPid > 0
+	if containerPid > 0 {
+		if !options.detach {
+			fmt.Printf("pid %d (host pid %d) running %s\n", containerPid, hostPid, args[0])
+		}
+
+		if err := setContainerState(config, stateRunning); err != nil {
+			log.Printf("failed to update the state of container %s: %v", containerId, err)
+		}
+
+		// run -d is already gone when the container is restarted
+		if options.detach && options.restartCount == 0 {
+			notifyDetachedRunning()
+		}
+	}
+
+	stopHealthChecks := func() {}
+	if containerPid > 0 && len(options.healthCmd) > 0 {
+		stopHealthChecks = startHealthChecks(containerId, &options)
+	}
+
+	// this comes after the output above, which would be garbled in raw mode. the terminal still
+	// works without it, just not as well
+	if tty != nil {
+		if err := tty.makeRaw(); err != nil {
+			log.Printf("-t: %v", err)
+		}
+	}
+
+	readyPipe.Write([]byte{0})
+	readyPipe.Close()
+
+	err = cmd.Wait()
+	stopForwarding()
+	stopHealthChecks()
+	if tty != nil {
+		tty.stop()
+	}
+
+	flushOutput()
 
-			// unshare container's mount points with the host
-			// basically, i've created a new mount namespace for my container about
-			// & i don't want it to be shared with the host
-			Unshareflags: syscall.CLONE_NEWNS,
+	if timedOut.Load() {
+		log.Printf("container %s timed out after %s", containerId, options.timeout)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", describeContainerPid(hostPid, containerPid), err)
+	}
+
+	// exit with 124 like timeout(1) does
+	code := exitCode(cmd.ProcessState)
+	if timedOut.Load() {
+		code = 124
+	}
+
+	// for focker wait
+	config.ExitCode = &code
+
+	if current, err := readContainerConfig(containerId); err == nil {
+		keepRecordedState(config, current)
+	}
+
+	// the cleanup below still has to be done if this fails
+	if err := setContainerState(config, stateExited); err != nil {
+		log.Printf("failed to update the state of container %s: %v", containerId, err)
+	}
+
+	if len(options.rootfsSquashfs) > 0 {
+		unmountSquashfs(containerId)
+	}
+
+	// the child unmounts what it mounted, but it can't do that if it gets killed (e.g. by the
+	// OOM killer). so the authoritative cleanup is done here, since we always get control back
+	cleanupContainerMounts(containerId)
+
+	if options.net == "bridge" {
+		teardownNetworking(containerId, options.ip, options.ports)
+	}
+
+	if options.needsCgroup() {
+		if err := removeContainerCgroup(containerId); err != nil {
+			log.Print(err)
+		}
+	}
+
+	if options.autoRemove && setupDone {
+		// this refuses to remove anything if something is still mounted under the container's
+		// directory, so that host files can't be deleted through a bind mount that's left over
+		if err := removeContainer(containerId); err != nil {
+			log.Print(err)
+		} else {
+			infof("removed container %s", containerId)
+		}
+	}
+
+	return code, nil
+}
+
+// keepRecordedState copies what other focker processes recorded in the config of a running
+// container (current) into ours before we record its exit: focker stop records that it stopped the
+// container, which keeps it from being restarted, & the health checks record their result. stop
+// may have marked the container as exited already, which doesn't make it any less stopped
+func keepRecordedState(config *containerConfig, current *containerConfig) {
+	config.Health = current.Health
+	if current.State == stateStopped {
+		config.State = current.State
+	}
+
+	if current.ManuallyStopped {
+		config.ManuallyStopped = true
+	}
+}
+
+// readyPipeFd is the fd of the readiness pipe in the child. it's the first (and only) entry
+// of cmd.ExtraFiles, which always start at fd 3
+const readyPipeFd = 3
+
+func newReadyPipe() (*os.File, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return os.NewFile(uintptr(fds[0]), "ready-pipe"), os.NewFile(uintptr(fds[1]), "child-ready-pipe"), nil
+}
+
+// readContainerPid reads the pid that the child reports over the readiness pipe. it returns 0
+// if the child exited before it could report it
+func readContainerPid(r io.Reader) int {
+	var pid int
+	if _, err := fmt.Fscanln(r, &pid); err != nil {
+		return 0
+	}
+
+	return pid
+}
+
+// describeContainerPid is used in diagnostic messages about the container process so that it's
+// clear which pid is the one on the host & which one is inside the container's pid namespace
+func describeContainerPid(hostPid int, containerPid int) string {
+	if containerPid > 0 {
+		return fmt.Sprintf("container process (pid %d, host pid %d)", containerPid, hostPid)
+	}
+
+	return fmt.Sprintf("container process (host pid %d)", hostPid)
+}
+
+// exitCode returns the exit code of a process the way shells report it, i.e. 128 + the signal if
+// it was killed by a signal. state is nil if the process couldn't be started, for which shells
+// exit with 126
+func exitCode(state *os.ProcessState) int {
+	if state == nil {
+		return 126
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return state.ExitCode()
+}
+
+// waitStatusExitCode is exitCode for a status from wait4(2)
+func waitStatusExitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return status.ExitStatus()
+}
+
+// isExecNotFound reports whether starting a command failed because something it needs doesn't
+// exist. note that the kernel returns ENOENT both when the binary itself is missing and when the
+// interpreter named in its shebang (or its dynamic loader) is missing
+func isExecNotFound(err error) bool {
+	return errors.Is(err, exec.ErrNotFound) || errors.Is(err, syscall.ENOENT)
+}
+
+// explainLookPathError turns an error from exec.LookPath into a message that says what's wrong
+// with the command inside the container
+func explainLookPathError(name string, err error) string {
+	if !strings.Contains(name, "/") {
+		return fmt.Sprintf("executable not found in container: %s (not found in any directory of $PATH=%s)", name, os.Getenv("PATH"))
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Sprintf("executable not found in container: %s", name)
+	}
+
+	return fmt.Sprintf("cannot execute %s in container: %v", name, errors.Unwrap(err))
+}
+
+// explainExecNotFound figures out what exactly is missing when executing path failed with ENOENT
+func explainExecNotFound(path string) string {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Sprintf("executable not found in container: %s", path)
+	}
+
+	// e.g. the user that the command runs as can't read it, so we can't tell what's missing
+	if err != nil {
+		return fmt.Sprintf("cannot execute %s in container: %v", path, err)
+	}
+	defer file.Close()
+
+	// check if it's a script with a shebang line
+	firstLine, _ := bufio.NewReader(file).ReadString('\n')
+	if strings.HasPrefix(firstLine, "#!") {
+		fields := strings.Fields(strings.TrimPrefix(firstLine, "#!"))
+		if len(fields) == 0 {
+			return fmt.Sprintf("%s has an empty shebang line", path)
+		}
+
+		interpreter := fields[0]
+		if _, err := os.Stat(interpreter); errors.Is(err, fs.ErrNotExist) {
+			return fmt.Sprintf("interpreter %s (from the shebang of %s) not found in container", interpreter, path)
+		} else if err != nil {
+			return fmt.Sprintf("cannot execute the interpreter of %s in container: %v", path, err)
+		}
+	}
+
+	// a dynamically linked binary names its loader (e.g. /lib64/ld-linux-x86-64.so.2) in its
+	// PT_INTERP segment, which is missing from rootfses built for another libc or architecture
+	if loader, err := elfInterpreter(file); err == nil && len(loader) > 0 {
+		if _, err := os.Stat(loader); errors.Is(err, fs.ErrNotExist) {
+			return fmt.Sprintf("dynamic loader %s (of %s) not found in container", loader, path)
+		} else if err != nil {
+			return fmt.Sprintf("cannot execute the dynamic loader of %s in container: %v", path, err)
 		}
 	}
 
-	err := cmd.Run()
+	return fmt.Sprintf("%s exists but its interpreter or dynamic loader was not found in container", path)
+}
+
+// elfInterpreter returns the dynamic loader that an ELF binary asks for, or "" for a statically
+// linked one
+func elfInterpreter(file io.ReaderAt) (string, error) {
+	binary, err := elf.NewFile(file)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		return "", err
 	}
 
-	os.Exit(cmd.ProcessState.ExitCode())
+	for _, prog := range binary.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(data), "\x00"), nil
+	}
+
+	return "", nil
+}
+
+// an entry of ps --format=json
+type psEntry struct {
+	Id      string            `json:"id"`
+	Name    string            `json:"name,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Status  containerState    `json:"status"`
+	Health  healthStatus      `json:"health,omitempty"`
+	Command []string          `json:"command"`
+	Created time.Time         `json:"created"`
 }
 
-func ps() {
+// ps lists the containers, as a table or with --format=json as a json array. with --filter
+// <filter> (or --filter=<filter>), only the containers that match every filter are listed
+func ps(args []string) {
+	asJson := false
+	var filter psFilter
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--format=json":
+			asJson = true
+		case arg == "--format=table":
+			asJson = false
+		case arg == "--filter":
+			if i+1 == len(args) {
+				log.Fatal("ps: --filter requires a filter")
+			}
+
+			i++
+			exitIfError(filter.add(args[i]), "ps: --filter")
+		case strings.HasPrefix(arg, "--filter="):
+			exitIfError(filter.add(strings.TrimPrefix(arg, "--filter=")), "ps: --filter")
+		default:
+			log.Fatalf("ps: unknown flag %s (expected --format=json, --format=table or --filter)", arg)
+		}
+	}
+
 	files, err := os.ReadDir(containersDir)
 	exitIfError(err, "ps(): os.ReadDir()")
 
+	var configs []*containerConfig
 	for _, file := range files {
-		fileInfo, err := file.Info()
-		exitIfError(err, "ps(): file.Info()")
+		if !file.IsDir() {
+			continue
+		}
+
+		config, err := readContainerConfig(file.Name())
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		refreshContainerState(config)
+		if filter.matches(config) {
+			configs = append(configs, config)
+		}
+	}
+
+	// newest first
+	sort.SliceStable(configs, func(i, j int) bool {
+		return configs[i].Created.After(configs[j].Created)
+	})
+
+	if asJson {
+		entries := []psEntry{}
+		for _, config := range configs {
+			entry := psEntry{config.Id, config.Name, config.Labels, config.State, "", config.Command, config.Created}
+			if config.Health != nil {
+				entry.Health = config.Health.Status
+			}
+
+			entries = append(entries, entry)
+		}
 
-		fmt.Println(file.Name(), fileInfo.ModTime().Format(time.UnixDate))
+		data, err := json.MarshalIndent(entries, "", "  ")
+		exitIfError(err, "ps(): json.MarshalIndent()")
+		fmt.Println(string(data))
+		return
 	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tCOMMAND\tCREATED\tSTATUS\tNAME")
+	for _, config := range configs {
+		status := string(config.State)
+		if config.Health != nil && isContainerRunning(config) {
+			status += " (" + string(config.Health.Status) + ")"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", config.Id, strings.Join(config.Command, " "), config.Created.Format(time.DateTime), status, config.Name)
+	}
+
+	w.Flush()
 }
 
 func exitIfError(err error, label string) {
@@ -200,44 +2127,116 @@ func exitIfError(err error, label string) {
 
 const randomStringChars string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// randomString returns a string of random characters from randomStringChars, from crypto/rand so
+// that separate focker processes don't come up with the same strings
 func randomString(length int) string {
 	if length < 1 {
 		return ""
 	}
 
-	availableRunes := []rune(randomStringChars)
+	// bytes from the largest multiple of len(randomStringChars) on are skipped, since they'd make
+	// the first characters more likely than the others
+	limit := 256 - 256%len(randomStringChars)
 
-	r := make([]rune, length)
+	r := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(r) < length {
+		_, err := rand.Read(buf)
+		exitIfError(err, "randomString(): rand.Read()")
 
-	for i := 0; i < length; i++ {
-		r[i] = availableRunes[rand.Intn(len(randomStringChars))]
+		for _, b := range buf {
+			if int(b) < limit && len(r) < length {
+				r = append(r, randomStringChars[int(b)%len(randomStringChars)])
+			}
+		}
 	}
 
 	return string(r)
 }
 
-func unzipRootFsTarball(dest string, src string) {
-	exitIfError(os.MkdirAll(dest, 0700), "unzipRootFsTarball(): os.MkdirAll()")
+// the ST_RDONLY flag of statfs(2), which the syscall package doesn't have
+const stRdonly = 0x1
+
+// mountProc mounts a procfs of the container's pid namespace at /proc. nothing in /proc is meant
+// to be executed or to be a device, so it's mounted with nosuid, nodev & noexec like docker does
+func mountProc() error {
+	return syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+}
+
+// mountReadOnlyRoot remounts the container's root (after pivot_root) read-only & mounts a tmpfs
+// on each of rwPaths so that they stay writable
+func mountReadOnlyRoot(rwPaths []string, rootId int) error {
+	// the mount points have to be created while the rootfs is still writable
+	for _, rwPath := range rwPaths {
+		if err := os.MkdirAll(rwPath, 0755); err != nil {
+			return err
+		}
+	}
+
+	// "/" is a bind mount (see pivotRoot) & a bind mount can only be made read-only by remounting it.
+	// this only affects "/" itself, the volumes are separate mounts & stay writable
+	if err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY, ""); err != nil {
+		return fmt.Errorf("remount / read-only: %w", err)
+	}
+
+	// a container that's silently left writable would defeat the purpose, so make sure that the
+	// remount took effect
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs("/", &stat); err != nil {
+		return fmt.Errorf("statfs /: %w", err)
+	}
+
+	if stat.Flags&stRdonly == 0 {
+		return errors.New("/ is still writable after remounting it read-only")
+	}
+
+	for _, rwPath := range rwPaths {
+		if err := syscall.Mount("tmpfs", rwPath, "tmpfs", 0, ""); err != nil {
+			return fmt.Errorf("mount tmpfs on %s: %w", rwPath, err)
+		}
+
+		if err := os.Chown(rwPath, rootId, rootId); err != nil {
+			return fmt.Errorf("chown %s: %w", rwPath, err)
+		}
+	}
 
-	cmd := exec.Command("tar", []string{"-xzf", src, "-C", dest}...)
-	exitIfError(cmd.Run(), "unzipRootFsTarball(): tar cmd.Run()")
+	return nil
 }
 
-func pivotRoot(newRoot string) {
+func pivotRoot(newRoot string) error {
 	// pivot_root system call requires new_root arg to be a mount point. here's a line from man pages
 	// new_root must be a path to a mount point, but can't be "/".  A path that is not already a mount point can be converted into one by bind mounting the path onto itself.
-	exitIfError(
-		syscall.Mount(newRoot, newRoot, "", syscall.MS_BIND|syscall.MS_REC, ""),
-		"pivotRoot(): syscall.Mount",
-	)
+	if err := syscall.Mount(newRoot, newRoot, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mount new root: %w", err)
+	}
 
 	// put_old must be a subdirectory inside new_root
 	putOld := filepath.Join(newRoot, ".put_old")
-	exitIfError(os.MkdirAll(putOld, 0700), "pivotRoot(): putold os.MkdirAll")
+	if err := os.MkdirAll(putOld, 0700); err != nil {
+		return err
+	}
 
 	// use pivot_root system call to set the root directory inside the container to the extracted rootfs
-	exitIfError(syscall.PivotRoot(newRoot, putOld), "pivotRoot(): pivot_root")
+	if err := syscall.PivotRoot(newRoot, putOld); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
 
 	// set current working directory to the new root directory
-	exitIfError(syscall.Chdir("/"), "chdir")
+	if err := syscall.Chdir("/"); err != nil {
+		return fmt.Errorf("chdir: %w", err)
+	}
+
+	// the old root (the host's) is now mounted on /.put_old, along with a copy of every mount of
+	// the container under its path on the host. detach it, so that neither the host's files nor
+	// those copies are reachable from the container
+	if err := syscall.Unmount("/.put_old", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount put_old: %w", err)
+	}
+
+	if err := os.Remove("/.put_old"); err != nil {
+		return err
+	}
+
+	debugf("pivoted the root to %s", newRoot)
+	return nil
 }
@@ -0,0 +1,368 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExplainLookPathError(t *testing.T) {
+	dir := t.TempDir()
+
+	notExecutable := filepath.Join(dir, "not-executable")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{filepath.Join(dir, "missing"), "executable not found in container: " + filepath.Join(dir, "missing")},
+		{"focker-test-missing-command", "not found in any directory of $PATH"},
+		{notExecutable, "cannot execute " + notExecutable + " in container: permission denied"},
+	}
+
+	for _, test := range tests {
+		_, err := exec.LookPath(test.name)
+		if err == nil {
+			t.Fatalf("exec.LookPath(%q) succeeded", test.name)
+		}
+
+		if got := explainLookPathError(test.name, err); !strings.Contains(got, test.want) {
+			t.Errorf("explainLookPathError(%q) = %q, want it to contain %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExplainExecNotFound(t *testing.T) {
+	dir := t.TempDir()
+
+	missingInterpreter := filepath.Join(dir, "script.sh")
+	if err := os.WriteFile(missingInterpreter, []byte("#!/focker-test/missing/sh -e\necho hi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{filepath.Join(dir, "missing"), "executable not found in container: " + filepath.Join(dir, "missing")},
+		{missingInterpreter, "interpreter /focker-test/missing/sh (from the shebang of " + missingInterpreter + ") not found in container"},
+	}
+
+	for _, test := range tests {
+		// the kernel reports both a missing binary & a missing interpreter as ENOENT
+		if err := exec.Command(test.path).Run(); !isExecNotFound(err) {
+			t.Fatalf("running %s: got %v, want ENOENT", test.path, err)
+		}
+
+		if got := explainExecNotFound(test.path); got != test.want {
+			t.Errorf("explainExecNotFound(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestExplainExecNotFoundPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read files regardless of their mode")
+	}
+
+	unreadable := filepath.Join(t.TempDir(), "unreadable")
+	if err := os.WriteFile(unreadable, []byte("#!/focker-test/missing/sh\n"), 0111); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := explainExecNotFound(unreadable); !strings.Contains(got, "permission denied") {
+		t.Errorf("explainExecNotFound(%q) = %q, want a permission error", unreadable, got)
+	}
+}
+
+// writeElfFixture writes a minimal executable for this architecture whose PT_INTERP segment asks
+// for loader, which is as far as the kernel gets before failing when the loader is missing
+func writeElfFixture(t *testing.T, path string, loader string) {
+	machine := elf.EM_X86_64
+	if runtime.GOARCH == "arm64" {
+		machine = elf.EM_AARCH64
+	}
+
+	headerSize, progSize := binary.Size(elf.Header64{}), binary.Size(elf.Prog64{})
+	interp := append([]byte(loader), 0)
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     uint64(headerSize),
+		Ehsize:    uint16(headerSize),
+		Phentsize: uint16(progSize),
+		Phnum:     1,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	prog := elf.Prog64{
+		Type:   uint32(elf.PT_INTERP),
+		Flags:  uint32(elf.PF_R),
+		Off:    uint64(headerSize + progSize),
+		Filesz: uint64(len(interp)),
+		Memsz:  uint64(len(interp)),
+		Align:  1,
+	}
+
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, header)
+	binary.Write(&data, binary.LittleEndian, prog)
+	data.Write(interp)
+
+	if err := os.WriteFile(path, data.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExplainExecNotFoundMissingLoader(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "dynamic")
+	writeElfFixture(t, binaryPath, "/focker-test/missing/ld-linux.so.2")
+
+	if err := exec.Command(binaryPath).Run(); !isExecNotFound(err) {
+		t.Fatalf("running %s: got %v, want ENOENT", binaryPath, err)
+	}
+
+	want := "dynamic loader /focker-test/missing/ld-linux.so.2 (of " + binaryPath + ") not found in container"
+	if got := explainExecNotFound(binaryPath); got != want {
+		t.Errorf("explainExecNotFound(%q) = %q, want %q", binaryPath, got, want)
+	}
+}
+
+func TestElfInterpreter(t *testing.T) {
+	binaryPath := filepath.Join(t.TempDir(), "dynamic")
+	writeElfFixture(t, binaryPath, "/lib/ld-musl-x86_64.so.1")
+
+	file, err := os.Open(binaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if loader, err := elfInterpreter(file); err != nil || loader != "/lib/ld-musl-x86_64.so.1" {
+		t.Errorf("elfInterpreter() = %q, %v, want /lib/ld-musl-x86_64.so.1", loader, err)
+	}
+
+	// not an ELF binary at all
+	if _, err := elfInterpreter(strings.NewReader("#!/bin/sh\n")); err == nil {
+		t.Error("elfInterpreter() of a script succeeded, want an error")
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	for _, length := range []int{0, 1, 16, 1000} {
+		r := randomString(length)
+		if len(r) != length {
+			t.Errorf("len(randomString(%d)) = %d", length, len(r))
+		}
+
+		for _, c := range r {
+			if !strings.ContainsRune(randomStringChars, c) {
+				t.Errorf("randomString(%d) has %q, which isn't one of randomStringChars", length, c)
+			}
+		}
+	}
+
+	if randomString(16) == randomString(16) {
+		t.Error("randomString(16) returned the same string twice")
+	}
+}
+
+func TestInitFockerHome(t *testing.T) {
+	previous := [...]string{containersDir, imagesDir, defaultRootFsTarball}
+	t.Cleanup(func() {
+		containersDir, imagesDir, defaultRootFsTarball = previous[0], previous[1], previous[2]
+	})
+
+	dir := t.TempDir()
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		// $FOCKER_HOME, which is created if it doesn't exist
+		{map[string]string{"FOCKER_HOME": filepath.Join(dir, "focker-home")}, filepath.Join(dir, "focker-home")},
+
+		// ~/.focker without it
+		{map[string]string{"FOCKER_HOME": "", "HOME": filepath.Join(dir, "user")}, filepath.Join(dir, "user/.focker")},
+	}
+
+	for _, test := range tests {
+		for key, value := range test.env {
+			t.Setenv(key, value)
+		}
+
+		if err := initFockerHome(); err != nil {
+			t.Fatal(err)
+		}
+
+		if containersDir != filepath.Join(test.want, "containers") || imagesDir != filepath.Join(test.want, "images") {
+			t.Errorf("containersDir = %s & imagesDir = %s, want them in %s", containersDir, imagesDir, test.want)
+		}
+
+		if filepath.Dir(defaultRootFsTarball) != imagesDir {
+			t.Errorf("defaultRootFsTarball = %s, want it in %s", defaultRootFsTarball, imagesDir)
+		}
+
+		for _, path := range []string{containersDir, imagesDir} {
+			if info, err := os.Stat(path); err != nil || !info.IsDir() || info.Mode().Perm() != 0700 {
+				t.Errorf("%s: %v, %v, want a dir with mode 0700", path, info, err)
+			}
+		}
+	}
+}
+
+func TestInitFockerHomeRelative(t *testing.T) {
+	previous := [...]string{containersDir, imagesDir, defaultRootFsTarball}
+	t.Cleanup(func() {
+		containersDir, imagesDir, defaultRootFsTarball = previous[0], previous[1], previous[2]
+	})
+
+	// a relative $FOCKER_HOME is made absolute, since focker changes its working directory
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	t.Setenv("FOCKER_HOME", "relative")
+	if err := initFockerHome(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(dir, "relative/containers"); containersDir != want {
+		t.Errorf("containersDir = %s, want %s", containersDir, want)
+	}
+}
+
+func TestTruncateHostname(t *testing.T) {
+	long := strings.Repeat("a", 54) + ".service.example.com"
+	tests := []struct {
+		hostname string
+		want     string
+	}{
+		{"web", "web"},
+		{strings.Repeat("a", 64), strings.Repeat("a", 64)},
+
+		// 55 bytes of the hostname, without the dot that they end with, & the hash
+		{long, strings.Repeat("a", 54) + "-" + sha256Prefix(long)},
+	}
+
+	for _, test := range tests {
+		got := truncateHostname(test.hostname)
+		if got != test.want {
+			t.Errorf("truncateHostname(%q) = %q, want %q", test.hostname, got, test.want)
+		}
+
+		if err := checkHostname(got); err != nil || len(got) > maxHostnameLength {
+			t.Errorf("truncateHostname(%q) = %q, which isn't a valid hostname", test.hostname, got)
+		}
+	}
+
+	// hostnames that only differ in the part that's cut off
+	first := truncateHostname(strings.Repeat("worker-", 10) + "1")
+	second := truncateHostname(strings.Repeat("worker-", 10) + "2")
+	if first == second || len(first) != maxHostnameLength {
+		t.Errorf("truncateHostname() = %q & %q for different hostnames", first, second)
+	}
+}
+
+// sha256Prefix returns the first 8 hex digits of the SHA-256 of s
+func sha256Prefix(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// newTestFocker builds focker & returns a function that makes commands that run it with a new
+// FOCKER_HOME, whose containers containersDir points at. the home has an image named host that's
+// only a skeleton with /bin & /lib linked to /usr, which hostImageArgs fills with the host's, so
+// that the containers have a shell & the usual tools without a pulled image. the test is skipped
+// unless it runs as root
+func newTestFocker(t *testing.T) func(args ...string) *exec.Cmd {
+	if os.Geteuid() != 0 {
+		t.Skip("containers can only be run by root")
+	}
+
+	binary := filepath.Join(t.TempDir(), "focker")
+	if output, err := exec.Command("go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, output)
+	}
+
+	home := t.TempDir()
+	if err := os.Mkdir(filepath.Join(home, "images"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var headers []*tar.Header
+	for _, dir := range []string{"usr", "etc", "proc", "dev", "sys", "tmp"} {
+		headers = append(headers, &tar.Header{Name: dir + "/", Typeflag: tar.TypeDir})
+	}
+
+	for _, link := range []string{"bin", "sbin", "lib", "lib64"} {
+		headers = append(headers, &tar.Header{Name: link, Typeflag: tar.TypeSymlink, Linkname: "usr/" + link})
+	}
+
+	if err := os.Rename(writeTarball(t, headers), filepath.Join(home, "images", "host.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := containersDir
+	containersDir = filepath.Join(home, "containers")
+	t.Cleanup(func() { containersDir = previous })
+
+	return func(args ...string) *exec.Cmd {
+		cmd := exec.Command(binary, args...)
+		cmd.Env = append(os.Environ(), "FOCKER_HOME="+home)
+		return cmd
+	}
+}
+
+// hostImageArgs returns the arguments of run for a container of the host image of newTestFocker
+// with the flags, running command
+func hostImageArgs(flags []string, command ...string) []string {
+	args := append([]string{"run"}, flags...)
+	args = append(args, "-v=/usr:/usr:ro", "host")
+	return append(args, command...)
+}
+
+// onlyTestContainer returns the config of the container that a test ran with newTestFocker, or
+// nil if it doesn't have one yet
+func onlyTestContainer(t *testing.T) *containerConfig {
+	entries, err := os.ReadDir(containersDir)
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+
+	if len(entries) > 1 {
+		t.Fatalf("%d containers in %s, want 1", len(entries), containersDir)
+	}
+
+	config, err := readContainerConfig(entries[0].Name())
+	if err != nil {
+		return nil
+	}
+
+	return config
+}
@@ -0,0 +1,824 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// a mount given with -v or --mount
+type mountSpec struct {
+	// bind, tmpfs, secret or overlay
+	kind string
+
+	// path on the host. required for bind & secret mounts, optional for overlay mounts
+	source string
+
+	// path inside the container
+	target string
+
+	// by default, bind mounts are recursive (MS_REC), i.e. submounts of source are also mounted
+	nonRecursive bool
+
+	// max size (in bytes, with an optional k, m or g suffix) & max number of inodes of a tmpfs.
+	// both are unlimited by default, though the kernel defaults to half of the RAM for both
+	tmpfsSize   string
+	tmpfsInodes string
+
+	// permissions of the root of a tmpfs, in octal
+	tmpfsMode string
+
+	// mount it read-only, with :ro for -v
+	readOnly bool
+}
+
+// parseVolumeSpec parses the value of -v, i.e. <source>:<target>[:ro|rw]
+func parseVolumeSpec(spec string) (mountSpec, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return mountSpec{}, fmt.Errorf("invalid volume mapping: %s", spec)
+	}
+
+	if err := checkMountTarget(parts[1]); err != nil {
+		return mountSpec{}, err
+	}
+
+	mount := mountSpec{kind: "bind", source: parts[0], target: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			mount.readOnly = true
+		case "rw":
+		default:
+			return mountSpec{}, fmt.Errorf("invalid volume mode %q (expected ro or rw): %s", parts[2], spec)
+		}
+	}
+
+	return mount, nil
+}
+
+// parseTmpfsSpec parses the value of --tmpfs, i.e. <target>[:<options>] where the options are
+// like those of mount -t tmpfs, e.g. /tmp:size=64m,mode=1777. it's a shorthand for
+// --mount=type=tmpfs,target=<target>,tmpfs-size=...
+func parseTmpfsSpec(spec string) (mountSpec, error) {
+	target, options, hasOptions := strings.Cut(spec, ":")
+	if len(target) == 0 {
+		return mountSpec{}, fmt.Errorf("tmpfs mount requires a target: %s", spec)
+	}
+
+	mountOptions := []string{"type=tmpfs", "target=" + target}
+	if hasOptions {
+		for _, option := range strings.Split(options, ",") {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "size", "mode":
+				mountOptions = append(mountOptions, "tmpfs-"+key+"="+value)
+			case "nr_inodes":
+				mountOptions = append(mountOptions, "tmpfs-inodes="+value)
+			case "ro", "rw":
+				mountOptions = append(mountOptions, "readonly="+strconv.FormatBool(key == "ro"))
+			default:
+				return mountSpec{}, fmt.Errorf("unknown tmpfs option %q (expected size, nr_inodes, mode, ro or rw): %s", option, spec)
+			}
+		}
+	}
+
+	return parseMountSpec(joinMountOptions(mountOptions))
+}
+
+// parseMountSpec parses the value of --mount, which is a comma separated list of key=value
+// options like docker's, e.g. type=bind,source=/data,target=/data,bind-nonrecursive,
+// type=tmpfs,target=/tmp,tmpfs-size=64m,tmpfs-inodes=1k or type=overlay,target=/var/lib/db.
+// like docker, it's read as a line of CSV, so an option with a comma in it is quoted, e.g.
+// type=bind,"source=/data/a,b",target=/data
+func parseMountSpec(spec string) (mountSpec, error) {
+	var mount mountSpec
+
+	reader := csv.NewReader(strings.NewReader(spec))
+	reader.LazyQuotes = true
+	options, err := reader.Read()
+	if err != nil {
+		return mountSpec{}, fmt.Errorf("invalid mount %s: %w", spec, err)
+	}
+
+	for _, option := range options {
+		key, value, hasValue := strings.Cut(option, "=")
+
+		switch key {
+		case "type":
+			mount.kind = value
+		case "source", "src":
+			mount.source = value
+		case "target", "destination", "dst":
+			mount.target = value
+		case "bind-nonrecursive":
+			if hasValue && value != "true" && value != "false" {
+				return mountSpec{}, fmt.Errorf("invalid mount option %s: value must be true or false", option)
+			}
+
+			mount.nonRecursive = !hasValue || value == "true"
+		case "readonly", "ro":
+			if hasValue && value != "true" && value != "false" {
+				return mountSpec{}, fmt.Errorf("invalid mount option %s: value must be true or false", option)
+			}
+
+			mount.readOnly = !hasValue || value == "true"
+		case "tmpfs-size":
+			if !isValidTmpfsSize(value) {
+				return mountSpec{}, fmt.Errorf("invalid mount option %s: expected a positive number of bytes with an optional k, m or g suffix", option)
+			}
+
+			mount.tmpfsSize = value
+		case "tmpfs-inodes":
+			if !isValidTmpfsSize(value) {
+				return mountSpec{}, fmt.Errorf("invalid mount option %s: expected a positive number with an optional k, m or g suffix", option)
+			}
+
+			mount.tmpfsInodes = value
+		case "tmpfs-mode":
+			if mode, err := strconv.ParseUint(value, 8, 32); err != nil || mode > 07777 {
+				return mountSpec{}, fmt.Errorf("invalid mount option %s: expected permissions in octal", option)
+			}
+
+			mount.tmpfsMode = value
+		default:
+			return mountSpec{}, fmt.Errorf("unknown mount option: %s", option)
+		}
+	}
+
+	if err := checkMountTarget(mount.target); err != nil {
+		return mountSpec{}, err
+	}
+
+	switch mount.kind {
+	case "bind":
+		if len(mount.source) == 0 || len(mount.target) == 0 {
+			return mountSpec{}, fmt.Errorf("bind mount requires a source and a target: %s", spec)
+		}
+
+		if len(mount.tmpfsSize) > 0 || len(mount.tmpfsInodes) > 0 || len(mount.tmpfsMode) > 0 {
+			return mountSpec{}, fmt.Errorf("tmpfs options can't be used with a bind mount: %s", spec)
+		}
+	case "tmpfs":
+		if len(mount.source) > 0 {
+			return mountSpec{}, fmt.Errorf("tmpfs mount can't have a source: %s", spec)
+		}
+
+		if len(mount.target) == 0 {
+			return mountSpec{}, fmt.Errorf("tmpfs mount requires a target: %s", spec)
+		}
+
+		if mount.nonRecursive {
+			return mountSpec{}, fmt.Errorf("bind options can't be used with a tmpfs mount: %s", spec)
+		}
+	case "overlay":
+		if len(mount.target) == 0 {
+			return mountSpec{}, fmt.Errorf("overlay mount requires a target: %s", spec)
+		}
+
+		if mount.nonRecursive || len(mount.tmpfsSize) > 0 || len(mount.tmpfsInodes) > 0 || len(mount.tmpfsMode) > 0 {
+			return mountSpec{}, fmt.Errorf("bind & tmpfs options can't be used with an overlay mount: %s", spec)
+		}
+	case "secret":
+		if len(mount.source) == 0 || len(mount.target) == 0 {
+			return mountSpec{}, fmt.Errorf("secret mount requires a source and a target: %s", spec)
+		}
+
+		if mount.nonRecursive || len(mount.tmpfsSize) > 0 || len(mount.tmpfsInodes) > 0 || len(mount.tmpfsMode) > 0 {
+			return mountSpec{}, fmt.Errorf("bind & tmpfs options can't be used with a secret mount: %s", spec)
+		}
+	default:
+		return mountSpec{}, fmt.Errorf("unsupported mount type %q in %s", mount.kind, spec)
+	}
+
+	return mount, nil
+}
+
+// resolveMountTarget makes the target of a mount absolute. a relative target is ambiguous, so
+// the rule is that it's relative to the working directory of the command (-w), which is the
+// container's root by default. we warn about it since that may not be what the user expected
+func resolveMountTarget(target string, workdir string) string {
+	if filepath.IsAbs(target) {
+		return filepath.Clean(target)
+	}
+
+	if len(workdir) == 0 {
+		workdir = "/"
+	}
+
+	resolved := filepath.Join(workdir, target)
+	warnf("relative mount target %q is resolved against %s as %q", target, workdir, resolved)
+
+	return resolved
+}
+
+// checkMountTarget checks that a mount target has no .. in it. the target is joined with the
+// rootfs on the host, so .. could otherwise lead to a mount outside of the container
+func checkMountTarget(target string) error {
+	for _, part := range strings.Split(target, "/") {
+		if part == ".." {
+			return fmt.Errorf("mount target %s can't contain ..", target)
+		}
+	}
+
+	return nil
+}
+
+// resolveBindSources checks that the source of each bind mount exists & replaces it with its
+// absolute path, with symlinks resolved, since the child may not have the same working directory.
+// otherwise, a typo in a source would only show up as a cryptic error of mount in the child
+func resolveBindSources(mounts []mountSpec) error {
+	for i, mount := range mounts {
+		if mount.kind != "bind" {
+			continue
+		}
+
+		source, err := filepath.Abs(mount.source)
+		if err != nil {
+			return err
+		}
+
+		source, err = filepath.EvalSymlinks(source)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("source %s of the volume at %s does not exist", mount.source, mount.target)
+			}
+
+			return fmt.Errorf("volume at %s: %w", mount.target, err)
+		}
+
+		mounts[i].source = source
+	}
+
+	return nil
+}
+
+// parseSecretSpec parses the value of --secret, i.e. src=<hostFile>[,target=<containerPath>]. the
+// target defaults to /run/secrets/<name of the source> & a relative target is put in /run/secrets
+func parseSecretSpec(spec string) (mountSpec, error) {
+	secret := mountSpec{kind: "secret"}
+
+	for _, option := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(option, "=")
+
+		switch key {
+		case "source", "src":
+			secret.source = value
+		case "target", "destination", "dst":
+			secret.target = value
+		default:
+			return mountSpec{}, fmt.Errorf("unknown secret option: %s", option)
+		}
+	}
+
+	if len(secret.source) == 0 {
+		return mountSpec{}, fmt.Errorf("secret requires a source: %s", spec)
+	}
+
+	if err := checkMountTarget(secret.target); err != nil {
+		return mountSpec{}, err
+	}
+
+	info, err := os.Stat(secret.source)
+	if err != nil {
+		return mountSpec{}, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return mountSpec{}, fmt.Errorf("secret %s is not a regular file", secret.source)
+	}
+
+	// the child may not have the same working directory, so it needs an absolute path
+	secret.source, err = filepath.Abs(secret.source)
+	if err != nil {
+		return mountSpec{}, err
+	}
+
+	if len(secret.target) == 0 {
+		secret.target = filepath.Base(secret.source)
+	}
+
+	if !filepath.IsAbs(secret.target) {
+		secret.target = filepath.Join("/run/secrets", secret.target)
+	}
+
+	return secret, nil
+}
+
+// isValidTmpfsSize checks a number in the format that the kernel's memparse() accepts for the
+// size & nr_inodes options of tmpfs, i.e. with an optional k, m or g suffix
+func isValidTmpfsSize(value string) bool {
+	number := strings.TrimRight(value, "kKmMgG")
+	if len(value)-len(number) > 1 {
+		return false
+	}
+
+	n, err := strconv.ParseUint(number, 10, 64)
+	return err == nil && n > 0
+}
+
+// tmpfsMountData returns the data argument of mount(2) for a tmpfs mount
+func (mount mountSpec) tmpfsMountData() string {
+	var options []string
+	if len(mount.tmpfsSize) > 0 {
+		options = append(options, "size="+mount.tmpfsSize)
+	}
+
+	if len(mount.tmpfsInodes) > 0 {
+		options = append(options, "nr_inodes="+mount.tmpfsInodes)
+	}
+
+	if len(mount.tmpfsMode) > 0 {
+		options = append(options, "mode="+mount.tmpfsMode)
+	}
+
+	return strings.Join(options, ",")
+}
+
+// String formats the mount back into a --mount value
+func (mount mountSpec) String() string {
+	options := []string{"type=" + mount.kind}
+	if len(mount.source) > 0 {
+		options = append(options, "source="+mount.source)
+	}
+
+	options = append(options, "target="+mount.target)
+
+	if mount.nonRecursive {
+		options = append(options, "bind-nonrecursive")
+	}
+
+	if mount.readOnly {
+		options = append(options, "readonly")
+	}
+
+	if len(mount.tmpfsSize) > 0 {
+		options = append(options, "tmpfs-size="+mount.tmpfsSize)
+	}
+
+	if len(mount.tmpfsInodes) > 0 {
+		options = append(options, "tmpfs-inodes="+mount.tmpfsInodes)
+	}
+
+	if len(mount.tmpfsMode) > 0 {
+		options = append(options, "tmpfs-mode="+mount.tmpfsMode)
+	}
+
+	return joinMountOptions(options)
+}
+
+// joinMountOptions joins the options of a mount for parseMountSpec, quoting those that have a
+// comma (or a quote) in them, e.g. the source or target of a volume
+func joinMountOptions(options []string) string {
+	var spec strings.Builder
+	writer := csv.NewWriter(&spec)
+	writer.Write(options)
+	writer.Flush()
+
+	return strings.TrimSuffix(spec.String(), "\n")
+}
+
+// listMountsUnder returns the mount points under dir in our mount namespace, in the order in
+// which they were mounted, by reading /proc/self/mountinfo
+func listMountsUnder(dir string) ([]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return readMountPoints("/proc/self/mountinfo", dir)
+}
+
+// readMountPoints returns the mount points under dir in a mountinfo file of procfs, in the order
+// in which they were mounted. they're relative to the root of the process that the file is of
+func readMountPoints(mountinfoPath string, dir string) ([]string, error) {
+	file, err := os.Open(mountinfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mountPoints []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// the 5th field is the mount point. see proc(5)
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+
+		mountPoint := unescapeMountPath(fields[4])
+		if mountPoint == dir || strings.HasPrefix(mountPoint, strings.TrimSuffix(dir, "/")+"/") {
+			mountPoints = append(mountPoints, mountPoint)
+		}
+	}
+
+	return mountPoints, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (like \040 for a space) that the kernel uses for
+// whitespace & backslashes in paths in /proc/self/mountinfo
+func unescapeMountPath(path string) string {
+	if !strings.ContainsRune(path, '\\') {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+
+		b.WriteByte(path[i])
+	}
+
+	return b.String()
+}
+
+// cleanupContainerMounts unmounts anything that's still mounted under the container's directory
+// in our mount namespace. the container's own mounts live in its mount namespace & are gone with
+// it, but if the mount propagation of the host was shared, they may have propagated to it.
+// mounts are undone in reverse order so that nested mounts go first
+func cleanupContainerMounts(containerId string) {
+	mountPoints, err := listMountsUnder(containerDir(containerId))
+	if err != nil {
+		log.Printf("failed to list mounts of container %s: %v", containerId, err)
+		return
+	}
+
+	for i := len(mountPoints) - 1; i >= 0; i-- {
+		// detach so that a busy mount doesn't stop us, it'll be cleaned up once it's not busy
+		if err := syscall.Unmount(mountPoints[i], syscall.MNT_DETACH); err != nil {
+			log.Printf("failed to unmount %s: %v", mountPoints[i], err)
+		} else {
+			infof("unmounted leftover mount %s", mountPoints[i])
+		}
+	}
+
+	// the secrets dir is just an empty mount point by now
+	if err := os.Remove(containerSecretsDir(containerId)); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove secrets dir of container %s: %v", containerId, err)
+	}
+}
+
+// linkMtab makes sure that /etc/mtab in the container is a symlink to /proc/self/mounts (like on
+// modern distros), so that tools reading it see the container's mounts rather than whatever was
+// in the base image. it must be called after pivot_root
+func linkMtab() error {
+	const mtab = "/etc/mtab"
+	const mounts = "/proc/self/mounts"
+
+	if link, err := os.Readlink(mtab); err == nil && (link == mounts || link == "../proc/self/mounts") {
+		return nil
+	}
+
+	// it's either missing, a regular file with stale entries or a symlink to something else
+	if err := os.Remove(mtab); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Symlink(mounts, mtab)
+}
+
+// readContainerVolumes returns the bind mounts of a container (by id or name) from its
+// config.json, for --volumes-from. they come with all of their options, so e.g. a non-recursive
+// bind stays one
+func readContainerVolumes(ref string) ([]mountSpec, error) {
+	containerId, err := resolveContainerId(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := readContainerConfig(containerId)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []mountSpec
+	for _, spec := range config.Mounts {
+		mount, err := parseMountSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("bad mount in config of container %s: %w", containerId, err)
+		}
+
+		if mount.kind == "bind" {
+			volumes = append(volumes, mount)
+		}
+	}
+
+	return volumes, nil
+}
+
+// mountAll mounts the container's mounts in its rootfs before pivot_root & returns their targets
+// (as seen from inside the container) in the order in which they were mounted. like docker, the
+// mounts are sorted by the depth of their targets so that e.g. a volume at /run/data isn't hidden
+// by a tmpfs at /run
+func mountAll(containerId string, rootfsDir string, mounts []mountSpec, rootId int) ([]string, error) {
+	sorted := make([]mountSpec, len(mounts))
+	copy(sorted, mounts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return mountDepth(sorted[i].target) < mountDepth(sorted[j].target)
+	})
+
+	var mountedTargets []string
+	for i, mount := range sorted {
+		target, err := resolveInRoot(rootfsDir, mount.target)
+		if err != nil {
+			return nil, fmt.Errorf("mount target %s: %w", mount.target, err)
+		}
+
+		switch mount.kind {
+		case "bind":
+			// MS_REC also binds the mounts under the source, unless the user doesn't want them
+			var flags uintptr = syscall.MS_BIND | syscall.MS_REC
+			if mount.nonRecursive {
+				flags &^= syscall.MS_REC
+			}
+
+			if err := createBindTarget(mount.source, target); err != nil {
+				return nil, fmt.Errorf("volume %s: %w", mount.target, err)
+			}
+
+			if err := syscall.Mount(mount.source, target, "", flags, ""); err != nil {
+				return nil, fmt.Errorf("mount volume %s: %w", mount.target, err)
+			}
+		case "tmpfs":
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, fmt.Errorf("mkdir tmpfs target: %w", err)
+			}
+
+			if err := syscall.Mount("tmpfs", target, "tmpfs", 0, mount.tmpfsMountData()); err != nil {
+				return nil, fmt.Errorf("mount tmpfs %s: %w", mount.target, err)
+			}
+
+			if err := os.Chown(target, rootId, rootId); err != nil {
+				return nil, fmt.Errorf("chown tmpfs %s: %w", mount.target, err)
+			}
+		case "secret":
+			if err := mountSecret(containerId, i, mount.source, target, rootId); err != nil {
+				return nil, fmt.Errorf("mount secret %s: %w", mount.target, err)
+			}
+		case "overlay":
+			if err := mountOverlayVolume(containerId, i, mount.source, target); err != nil {
+				return nil, fmt.Errorf("mount overlay volume %s: %w", mount.target, err)
+			}
+		}
+
+		debugf("mounted %s volume %s", mount.kind, mount.target)
+
+		// add to the list of mounted targets
+		mountedTargets = append(mountedTargets, mount.target)
+
+		// tmpfs is only made read-only after it's been chowned. secrets always are read-only
+		if mount.readOnly && mount.kind != "secret" {
+			if err := remountReadOnly(target); err != nil {
+				return nil, fmt.Errorf("make %s read-only: %w", mount.target, err)
+			}
+		}
+	}
+
+	return mountedTargets, nil
+}
+
+// createBindTarget creates what the source of a bind mount is mounted on, which has to be of the
+// same kind: a directory for a directory & an empty file for anything else (e.g. a config file or
+// a socket). the dirs that this creates stay visible above the mount, so they have to be
+// accessible to the command, which isn't root on the host with user namespaces
+func createBindTarget(source string, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("mkdir target: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("mkdir target: %w", err)
+	}
+
+	if targetInfo, err := os.Stat(target); err == nil && targetInfo.IsDir() {
+		return fmt.Errorf("can't mount the file %s on a directory", source)
+	}
+
+	mountPoint, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("create target: %w", err)
+	}
+
+	return mountPoint.Close()
+}
+
+// the flags of statfs(2) that have the same value as the mount flags & have to be passed again
+// when remounting, otherwise the remount would clear them
+const preservedMountFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME |
+	syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+// remountReadOnly makes a mount & every mount under it read-only. a bind mount ignores MS_RDONLY
+// when it's created, so it has to be remounted, & the remount only applies to a single mount, so
+// the submounts of a recursive bind mount are remounted one by one
+func remountReadOnly(target string) error {
+	mountPoints, err := listMountsUnder(target)
+	if err != nil {
+		return err
+	}
+
+	for _, mountPoint := range mountPoints {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(mountPoint, &stat); err != nil {
+			return err
+		}
+
+		flags := uintptr(stat.Flags)&preservedMountFlags | syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY
+		if err := syscall.Mount("", mountPoint, "", flags, ""); err != nil {
+			return fmt.Errorf("remount %s read-only: %w", mountPoint, err)
+		}
+	}
+
+	return nil
+}
+
+func mountDepth(target string) int {
+	return strings.Count(filepath.Clean("/"+target), "/")
+}
+
+// secrets are copied to a tmpfs in the container's directory (outside of its rootfs), so that
+// they never hit the disk, & bind mounted read-only from there
+func containerSecretsDir(containerId string) string {
+	return filepath.Join(containerDir(containerId), "secrets")
+}
+
+// mountSecret copies the secret file at source to the secrets tmpfs (mounting it if needed) &
+// bind mounts the copy read-only at target. index is used to name the copy uniquely
+func mountSecret(containerId string, index int, source string, target string, rootId int) error {
+	secretsDir := containerSecretsDir(containerId)
+
+	mounted, err := listMountsUnder(secretsDir)
+	if err != nil {
+		return err
+	}
+
+	if len(mounted) == 0 {
+		if err := os.MkdirAll(secretsDir, 0700); err != nil {
+			return err
+		}
+
+		flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+		if err := syscall.Mount("tmpfs", secretsDir, "tmpfs", flags, "mode=700"); err != nil {
+			return fmt.Errorf("mount secrets tmpfs: %w", err)
+		}
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+
+	secretCopy := filepath.Join(secretsDir, strconv.Itoa(index))
+	if err := os.WriteFile(secretCopy, data, 0400); err != nil {
+		return err
+	}
+
+	// it's only readable by root in the container
+	if err := os.Chown(secretCopy, rootId, rootId); err != nil {
+		return err
+	}
+
+	// a bind mount needs an existing file to be mounted on. it stays empty in the rootfs
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	mountPoint, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0400)
+	if err != nil {
+		return err
+	}
+	mountPoint.Close()
+
+	if err := syscall.Mount(secretCopy, target, "", syscall.MS_BIND, ""); err != nil {
+		return err
+	}
+
+	// a bind mount can only be made read-only by remounting it
+	flags := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	return syscall.Mount("", target, "", flags, "")
+}
+
+// mountOverlayVolume mounts an overlayfs at target whose lower layer is the image's content at
+// target, so the volume starts off with that content but the writes go to a separate upper layer
+// & the image stays untouched. the upper layer is kept in source (so that it can be reused by
+// other containers) or in the container's directory if there's no source. index is used to name
+// the latter uniquely
+func mountOverlayVolume(containerId string, index int, source string, target string) error {
+	info, err := os.Stat(target)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory in the image", strings.TrimPrefix(target, containerRootfsDir(containerId)))
+	}
+
+	layersDir := source
+	if len(layersDir) == 0 {
+		layersDir = filepath.Join(containerDir(containerId), "volumes", strconv.Itoa(index))
+	}
+
+	// overlayfs needs the upper & work dirs to be on the same filesystem
+	upperDir := filepath.Join(layersDir, "upper")
+	workDir := filepath.Join(layersDir, "work")
+	for _, dir := range []string{upperDir, workDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// the root of the volume is the upper dir, so it should have the same owner & mode as in the
+	// image
+	if err := copyOwnerAndMode(upperDir, target); err != nil {
+		return err
+	}
+
+	// the lower dir is resolved when mounting, so mounting on top of it is fine
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", target, upperDir, workDir)
+	if err := syscall.Mount("overlay", target, "overlay", 0, data); err != nil {
+		return fmt.Errorf("mount overlay on %s: %w", target, err)
+	}
+
+	return nil
+}
+
+// the tmpfs mounts of --standard-mounts. /dev/shm has to come after /dev, which is mounted by
+// mountMinimalDev before pivot_root
+var standardTmpfsMounts = []mountSpec{
+	privateTmpMount,
+	{kind: "tmpfs", target: "/run", tmpfsMode: "755"},
+	{kind: "tmpfs", target: "/dev/shm", tmpfsMode: "1777", tmpfsSize: "64m"},
+}
+
+// hasMountAt tells whether one of the mounts is at target in the container
+func hasMountAt(mounts []mountSpec, target string) bool {
+	for _, mount := range mounts {
+		if filepath.Clean(mount.target) == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the tmpfs at /tmp that every container gets, see withPrivateTmp
+var privateTmpMount = mountSpec{kind: "tmpfs", target: "/tmp", tmpfsMode: "1777"}
+
+// withPrivateTmp adds privateTmpMount before the user's mounts, unless the
+// user mounted something else there, so that the container's temporary files stay out of its
+// rootfs & go away along with it
+func withPrivateTmp(mounts []mountSpec) []mountSpec {
+	if hasMountAt(mounts, "/tmp") {
+		return mounts
+	}
+
+	return append([]mountSpec{privateTmpMount}, mounts...)
+}
+
+// withStandardMounts adds the tmpfs mounts of --standard-mounts before the user's mounts, except
+// the ones that the user mounted something else at
+func withStandardMounts(mounts []mountSpec) []mountSpec {
+	var withStandard []mountSpec
+	for _, mount := range standardTmpfsMounts {
+		if !hasMountAt(mounts, mount.target) {
+			withStandard = append(withStandard, mount)
+		}
+	}
+
+	return append(withStandard, mounts...)
+}
+
+// mountReadOnlySys mounts sysfs at /sys read-only. it must be called after pivot_root
+func mountReadOnlySys() error {
+	if err := os.MkdirAll("/sys", 0555); err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount("sysfs", "/sys", "sysfs", flags, ""); err != nil {
+		return fmt.Errorf("mount sysfs on /sys: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveMountTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		workdir string
+		want    string
+	}{
+		// absolute targets are left alone, whatever -w is
+		{"/data", "", "/data"},
+		{"/data", "/app", "/data"},
+		{"/data/./logs/", "/app", "/data/logs"},
+
+		// relative ones are relative to / without -w
+		{"data", "", "/data"},
+		{"./data/logs", "", "/data/logs"},
+
+		// & relative to -w with it
+		{"data", "/app", "/app/data"},
+		{"./data/logs", "/app/src", "/app/src/data/logs"},
+		{"data", "/", "/data"},
+	}
+
+	for _, test := range tests {
+		if got := resolveMountTarget(test.target, test.workdir); got != test.want {
+			t.Errorf("resolveMountTarget(%q, %q) = %q, want %q", test.target, test.workdir, got, test.want)
+		}
+	}
+}
+
+func TestMountTargetsRejectDotDot(t *testing.T) {
+	for _, target := range []string{"..", "../etc", "data/../../etc", "/data/..", "/../etc"} {
+		if _, err := parseVolumeSpec("/tmp:" + target); err == nil || !strings.Contains(err.Error(), "..") {
+			t.Errorf("parseVolumeSpec(/tmp:%s) = %v, want an error about ..", target, err)
+		}
+
+		if _, err := parseMountSpec("type=bind,source=/tmp,target=" + target); err == nil {
+			t.Errorf("parseMountSpec(target=%s) succeeded, want an error", target)
+		}
+	}
+
+	// .. is only rejected as a whole path component
+	for _, target := range []string{"/data/..hidden", "data..", "/a..b/c"} {
+		if _, err := parseVolumeSpec("/tmp:" + target); err != nil {
+			t.Errorf("parseVolumeSpec(/tmp:%s) = %v, want no error", target, err)
+		}
+	}
+}
+
+func TestCreateBindTarget(t *testing.T) {
+	dir := t.TempDir()
+	sourceFile := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(sourceFile, []byte("key=value\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc/app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file source gets an empty file, with the dirs above it
+	fileTarget := filepath.Join(rootfs, "etc/new/app.conf")
+	if err := createBindTarget(sourceFile, fileTarget); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(fileTarget); err != nil || !info.Mode().IsRegular() || info.Size() != 0 {
+		t.Errorf("target of a file: %v, %v, want an empty file", info, err)
+	}
+
+	// a directory source gets a directory
+	dirTarget := filepath.Join(rootfs, "data/logs")
+	if err := createBindTarget(dir, dirTarget); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(dirTarget); err != nil || !info.IsDir() {
+		t.Errorf("target of a directory: %v, %v, want a directory", info, err)
+	}
+
+	// a file can't be mounted on an existing directory
+	if err := createBindTarget(sourceFile, filepath.Join(rootfs, "etc/app")); err == nil {
+		t.Error("createBindTarget(file, directory) succeeded, want an error")
+	}
+}
+
+func TestResolveInRootKeepsMountsInTheRootfs(t *testing.T) {
+	dir := t.TempDir()
+	rootfs := filepath.Join(dir, "rootfs")
+	outside := filepath.Join(dir, "outside")
+	for _, path := range []string{filepath.Join(rootfs, "etc"), outside} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// symlinks that an image could have to get its mounts onto the host
+	symlinks := map[string]string{
+		"absolute": outside,
+		"relative": "../../outside",
+		"config":   "/etc",
+		"loop":     "loop",
+	}
+
+	for name, target := range symlinks {
+		if err := os.Symlink(target, filepath.Join(rootfs, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/absolute/app.conf", filepath.Join(rootfs, outside, "app.conf")},
+		{"/relative/app.conf", filepath.Join(rootfs, "outside/app.conf")},
+		{"/config/app.conf", filepath.Join(rootfs, "etc/app.conf")},
+		{"/config", filepath.Join(rootfs, "etc")},
+		{"/new/dir/app.conf", filepath.Join(rootfs, "new/dir/app.conf")},
+	}
+
+	sourceFile := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(sourceFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		target, err := resolveInRoot(rootfs, test.target)
+		if err != nil || target != test.want {
+			t.Errorf("resolveInRoot(%q) = %q, %v, want %q", test.target, target, err, test.want)
+			continue
+		}
+
+		if filepath.Base(test.target) != "app.conf" {
+			continue
+		}
+
+		if err := createBindTarget(sourceFile, target); err != nil {
+			t.Errorf("createBindTarget(%s): %v", test.target, err)
+		}
+	}
+
+	if _, err := os.Lstat(filepath.Join(outside, "app.conf")); err == nil {
+		t.Error("a mount target was created outside of the rootfs")
+	}
+
+	if _, err := resolveInRoot(rootfs, "/loop/app.conf"); err == nil {
+		t.Error("resolveInRoot() of a symlink loop succeeded, want an error")
+	}
+}
+
+func TestMountSpecRoundTrip(t *testing.T) {
+	mounts := []mountSpec{
+		{kind: "bind", source: "/tmp/a,b", target: "/data,1", readOnly: true},
+		{kind: "bind", source: `/tmp/"quoted"`, target: "/data", nonRecursive: true},
+		{kind: "bind", source: "/tmp/plain", target: "/data"},
+		{kind: "tmpfs", target: "/run,tmp", tmpfsSize: "64m", tmpfsInodes: "1k", tmpfsMode: "1777"},
+	}
+
+	for _, mount := range mounts {
+		parsed, err := parseMountSpec(mount.String())
+		if err != nil || parsed != mount {
+			t.Errorf("parseMountSpec(%q) = %+v, %v, want %+v", mount.String(), parsed, err, mount)
+		}
+	}
+
+	// the specs of containers created before options were quoted still parse the same
+	if got := (mountSpec{kind: "bind", source: "/tmp/plain", target: "/data"}).String(); got != "type=bind,source=/tmp/plain,target=/data" {
+		t.Errorf("String() = %q, want it unquoted", got)
+	}
+}
+
+func TestVolumeWithComma(t *testing.T) {
+	mount, err := parseVolumeSpec("/tmp/a,b:/data:ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what the child & --volumes-from get
+	parsed, err := parseMountSpec(mount.String())
+	if err != nil || parsed.source != "/tmp/a,b" || parsed.target != "/data" || !parsed.readOnly {
+		t.Errorf("parseMountSpec(%q) = %+v, %v", mount.String(), parsed, err)
+	}
+
+	if mount, err := parseTmpfsSpec("/run,tmp:size=64m,mode=1777"); err != nil || mount.target != "/run,tmp" || mount.tmpfsSize != "64m" {
+		t.Errorf("parseTmpfsSpec() = %+v, %v", mount, err)
+	}
+}
+
+func TestCleanupAfterOomKill(t *testing.T) {
+	if err := checkCgroupV2(); err != nil {
+		t.Skip(err)
+	}
+
+	focker := newTestFocker(t)
+
+	volume := t.TempDir()
+	if err := os.WriteFile(filepath.Join(volume, "data"), []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the command eats memory until the container hits its limit
+	var output bytes.Buffer
+	cmd := focker(hostImageArgs([]string{"-m=64m", "-v=" + volume + ":/data"}, "/bin/sh", "-c", "sleep 1; tail /dev/zero")...)
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	var config *containerConfig
+	for deadline := time.Now().Add(30 * time.Second); config == nil || config.State != stateRunning; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			t.Fatalf("the container didn't start:\n%s", output.String())
+		}
+
+		config = onlyTestContainer(t)
+	}
+
+	// the OOM killer would pick the command, as the biggest process of the container. the child
+	// is the container's init, whose deferred unmounts never run once it's killed
+	if err := os.WriteFile("/proc/"+strconv.Itoa(config.Pid)+"/oom_score_adj", []byte("1000"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(time.Minute):
+		cmd.Process.Kill()
+		t.Fatalf("the container wasn't OOM killed:\n%s", output.String())
+	}
+
+	config, err := readContainerConfig(config.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ExitCode == nil || *config.ExitCode != 128+9 {
+		t.Fatalf("the container wasn't killed by SIGKILL:\n%s", output.String())
+	}
+
+	mountPoints, err := listMountsUnder(containerDir(config.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mountPoints) > 0 {
+		t.Errorf("mounts of the OOM killed container are left: %s", strings.Join(mountPoints, ", "))
+	}
+
+	if data, err := os.ReadFile(filepath.Join(volume, "data")); err != nil || string(data) != "keep" {
+		t.Errorf("the volume's file is gone or changed: %q, %v", data, err)
+	}
+}
+
+func TestVolumesAreUnmountedAfterExit(t *testing.T) {
+	focker := newTestFocker(t)
+
+	// root of the container's user namespace is an unprivileged user on the host
+	volume := t.TempDir()
+	if err := os.Chmod(volume, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(volume, "data"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a nested target too, whose path is only right relative to the container's root
+	args := hostImageArgs([]string{"-v=" + volume + ":/data", "-v=" + volume + ":/srv/app/data:ro"}, "/bin/cat", "/data/data", "/srv/app/data/data")
+	output, err := focker(args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, output)
+	}
+
+	if !strings.Contains(string(output), "keepkeep") {
+		t.Fatalf("the container didn't see the volume:\n%s", output)
+	}
+
+	config := onlyTestContainer(t)
+	mountPoints, err := listMountsUnder(containerDir(config.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mountPoints) > 0 {
+		t.Errorf("the volumes are still mounted after the container exited: %s", strings.Join(mountPoints, ", "))
+	}
+
+	// rm would delete the host's files through a bind mount that's left
+	if output, err := focker("rm", config.Id).CombinedOutput(); err != nil {
+		t.Fatalf("rm: %v:\n%s", err, output)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(volume, "data")); err != nil || string(data) != "keep" {
+		t.Errorf("the volume's file is gone or changed: %q, %v", data, err)
+	}
+}
+
+// hostProcMounts returns the lines of our mountinfo for /proc & the mounts under it
+func hostProcMounts(t *testing.T) []string {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mounts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		// <id> <parent id> <major:minor> <root> <mount point> ...
+		fields := strings.Fields(line)
+		if len(fields) > 4 && (fields[4] == "/proc" || strings.HasPrefix(fields[4], "/proc/")) {
+			mounts = append(mounts, line)
+		}
+	}
+
+	return mounts
+}
+
+func TestHostProcIsUntouched(t *testing.T) {
+	focker := newTestFocker(t)
+	before := hostProcMounts(t)
+
+	// the container mounts its own /proc, also with a read-only rootfs & with mounts that are slaves
+	// of the host's
+	for _, flags := range [][]string{nil, {"--read-only"}, {"--mount-propagation=slave"}} {
+		output, err := focker(hostImageArgs(flags, "/bin/cat", "/proc/self/mounts")...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v:\n%s", flags, err, output)
+		}
+
+		if !strings.Contains(string(output), "proc /proc proc") {
+			t.Fatalf("%v: the container has no /proc of its own:\n%s", flags, output)
+		}
+
+		if after := hostProcMounts(t); strings.Join(after, "\n") != strings.Join(before, "\n") {
+			t.Errorf("%v: the host's /proc mounts changed from\n%s\nto\n%s", flags, strings.Join(before, "\n"), strings.Join(after, "\n"))
+		}
+
+		if !hasProcfs("/proc") {
+			t.Fatalf("%v: the host's /proc isn't procfs anymore", flags)
+		}
+
+		if _, err := os.Stat("/proc/self/status"); err != nil {
+			t.Errorf("%v: the host's /proc doesn't work anymore: %v", flags, err)
+		}
+	}
+}
+
+func TestContainerMountsDontPropagateToHost(t *testing.T) {
+	focker := newTestFocker(t)
+
+	// the container tells us through a volume once it has mounted a tmpfs of its own, by a name
+	// that can be looked for in our mounts
+	signals := t.TempDir()
+	source := "focker-test-" + strconv.Itoa(os.Getpid())
+	script := "mkdir /tmp/inner && mount -t tmpfs " + source + " /tmp/inner && touch /signals/mounted && sleep 2"
+
+	// the command has to be real root with CAP_SYS_ADMIN & without the seccomp profile to mount
+	flags := []string{"--userns=host", "--cap-add=SYS_ADMIN", "--seccomp=unconfined", "-v=" + signals + ":/signals"}
+	for _, propagation := range []string{"private", "slave"} {
+		os.Remove(filepath.Join(signals, "mounted"))
+
+		var output bytes.Buffer
+		cmd := focker(hostImageArgs(append(flags, "--mount-propagation="+propagation), "/bin/sh", "-c", script)...)
+		cmd.Stdout, cmd.Stderr = &output, &output
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		mounted := false
+		for deadline := time.Now().Add(30 * time.Second); !mounted && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			_, err := os.Stat(filepath.Join(signals, "mounted"))
+			mounted = err == nil
+		}
+
+		// while the container runs & after it has exited
+		seen := false
+		for _, check := range []func() error{func() error { return nil }, cmd.Wait} {
+			check()
+			data, err := os.ReadFile("/proc/self/mountinfo")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seen = seen || strings.Contains(string(data), source)
+		}
+
+		if !mounted {
+			t.Fatalf("%s: the container didn't mount the tmpfs:\n%s", propagation, output.String())
+		}
+
+		if seen {
+			t.Errorf("%s: the tmpfs that the container mounted showed up in the host's mounts", propagation)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// CLONE_NEWCGROUP, which the syscall package doesn't define
+const cloneNewCgroup = 0x02000000
+
+// joinNamespace moves the calling thread into the namespace that nsPath (a /proc/<pid>/ns/<type>
+// file) refers to. nsType is the CLONE_NEW* flag of the namespace, which the kernel uses to check
+// that nsPath is of the expected type
+func joinNamespace(nsPath string, nsType int) error {
+	fd, err := syscall.Open(nsPath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	if _, _, errno := syscall.RawSyscall(sysSetns, uintptr(fd), uintptr(nsType), 0); errno != 0 {
+		return fmt.Errorf("setns %s: %w", nsPath, errno)
+	}
+
+	return nil
+}
+
+// containerPidNamespace returns the path of the pid namespace of another container, which the
+// child is started in (see startInPidNamespace). pid is the value of the --pid flag, i.e.
+// container:<id or name>
+func containerPidNamespace(pid string) (string, error) {
+	ref, ok := strings.CutPrefix(pid, "container:")
+	if !ok || len(ref) == 0 {
+		return "", fmt.Errorf("invalid --pid value: %s (expected container:<id or name>)", pid)
+	}
+
+	targetId, err := resolveContainerId(ref)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := readContainerConfig(targetId)
+	if err != nil {
+		return "", err
+	}
+
+	if !isContainerRunning(target) {
+		return "", fmt.Errorf("container %s is not running", targetId)
+	}
+
+	return fmt.Sprintf("/proc/%d/ns/pid", target.Pid), nil
+}
+
+// startInPidNamespace starts cmd in the pid namespace that nsPath refers to, or in ours if nsPath
+// is empty. setns only changes the namespace of the children that the calling thread creates
+// afterwards, so cmd is started from a thread of its own that is never unlocked, like in
+// inNetNamespace. the commands that we run later on (e.g. ip for --net=bridge) stay in our
+// namespace, where the pids that we give them mean what we expect
+func startInPidNamespace(cmd *exec.Cmd, nsPath string) error {
+	if len(nsPath) == 0 {
+		return cmd.Start()
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		if err := joinNamespace(nsPath, syscall.CLONE_NEWPID); err != nil {
+			errs <- fmt.Errorf("--pid: join pid namespace: %w", err)
+			return
+		}
+
+		errs <- cmd.Start()
+	}()
+
+	return <-errs
+}
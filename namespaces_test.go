@@ -0,0 +1,77 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestStartInPidNamespace(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a pid namespace needs root")
+	}
+
+	// a process in a pid namespace of its own, like the init of another container
+	target := exec.Command("sleep", "60")
+	target.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWPID}
+	if err := target.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		target.Process.Kill()
+		target.Wait()
+	})
+
+	nsPath := fmt.Sprintf("/proc/%d/ns/pid", target.Process.Pid)
+	want, err := os.Readlink(nsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ours, err := os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("readlink", "/proc/self/ns/pid")
+	var output strings.Builder
+	cmd.Stdout = &output
+	if err := startInPidNamespace(cmd, nsPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.TrimSpace(output.String()); got != want {
+		t.Errorf("the command is in pid namespace %s, want %s", got, want)
+	}
+
+	// what we run afterwards (like ip for --net=bridge) is still in our namespace
+	for i := 0; i < 10; i++ {
+		got, err := exec.Command("readlink", "/proc/self/ns/pid").Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.TrimSpace(string(got)) != ours {
+			t.Fatalf("a later command is in pid namespace %s, want ours (%s)", got, ours)
+		}
+	}
+}
+
+func TestContainerPidNamespaceRejectsBadValues(t *testing.T) {
+	useTempContainersDir(t)
+
+	for _, pid := range []string{"container:", "b-1234", "container:b-missing"} {
+		if _, err := containerPidNamespace(pid); err == nil {
+			t.Errorf("containerPidNamespace(%q) succeeded, want an error", pid)
+		}
+	}
+}
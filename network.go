@@ -0,0 +1,393 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// struct ifreq from <net/if.h>, with just the flags member of its union
+type ifreqFlags struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// bringUpLoopback brings up the lo interface of our network namespace, which starts off down in a
+// new network namespace, so that localhost works in the container. it's the equivalent of
+// `ip link set lo up`, which we can't count on being installed
+func bringUpLoopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var req ifreqFlags
+	copy(req.name[:], "lo")
+
+	if err := ioctl(fd, syscall.SIOCGIFFLAGS, unsafe.Pointer(&req)); err != nil {
+		return fmt.Errorf("get flags of lo: %w", err)
+	}
+
+	req.flags |= syscall.IFF_UP
+	if err := ioctl(fd, syscall.SIOCSIFFLAGS, unsafe.Pointer(&req)); err != nil {
+		return fmt.Errorf("set flags of lo: %w", err)
+	}
+
+	return nil
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// the bridge that the containers with --net=bridge are attached to & its subnet. the bridge
+// itself gets the first address of the subnet & is the containers' default gateway
+const (
+	bridgeName   = "focker0"
+	bridgeSubnet = "172.29.0.0/16"
+	bridgeIp     = "172.29.0.1"
+)
+
+// allocateContainerIp returns a free address in the bridge's subnet for a new container, or
+// checks that the address that the user asked for with --ip is usable
+func allocateContainerIp(requested string) (string, error) {
+	_, subnet, _ := net.ParseCIDR(bridgeSubnet)
+
+	used, err := usedContainerIps()
+	if err != nil {
+		return "", err
+	}
+
+	if len(requested) > 0 {
+		ip := net.ParseIP(requested).To4()
+		if ip == nil || !subnet.Contains(ip) {
+			return "", fmt.Errorf("%s is not an IPv4 address in %s", requested, bridgeSubnet)
+		}
+
+		if ip.Equal(subnet.IP) || ip.Equal(broadcastAddress(subnet)) {
+			return "", fmt.Errorf("%s is reserved", requested)
+		}
+
+		if used[ip.String()] {
+			return "", fmt.Errorf("%s is already in use", requested)
+		}
+
+		return ip.String(), nil
+	}
+
+	return freeContainerIp(used)
+}
+
+// allocateContainerIps returns count different free addresses in the bridge's subnet, for
+// containers that are started at once (see runReplicas) & that would otherwise all pick the same
+// one, since none of them is running yet when the others look for a free address
+func allocateContainerIps(count int) ([]string, error) {
+	used, err := usedContainerIps()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, count)
+	for i := range ips {
+		if ips[i], err = freeContainerIp(used); err != nil {
+			return nil, err
+		}
+
+		used[ips[i]] = true
+	}
+
+	return ips, nil
+}
+
+// usedContainerIps returns the addresses of the bridge's subnet that are taken, i.e. that of the
+// bridge & those of the running containers, which are read from their config.json
+func usedContainerIps() (map[string]bool, error) {
+	used := map[string]bool{bridgeIp: true}
+	files, err := os.ReadDir(containersDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		config, err := readContainerConfig(file.Name())
+		if err == nil && len(config.Ip) > 0 && isContainerRunning(config) {
+			used[config.Ip] = true
+		}
+	}
+
+	return used, nil
+}
+
+// freeContainerIp returns the first address of the bridge's subnet that isn't in used
+func freeContainerIp(used map[string]bool) (string, error) {
+	_, subnet, _ := net.ParseCIDR(bridgeSubnet)
+
+	broadcast := broadcastAddress(subnet)
+	for ip := nextIp(subnet.IP); !ip.Equal(broadcast); ip = nextIp(ip) {
+		if !used[ip.String()] {
+			return ip.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no free address left in %s", bridgeSubnet)
+}
+
+func nextIp(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+func broadcastAddress(subnet *net.IPNet) net.IP {
+	ip := make(net.IP, len(subnet.IP))
+	for i := range ip {
+		ip[i] = subnet.IP[i] | ^subnet.Mask[i]
+	}
+
+	return ip
+}
+
+// the names of the host's & the container's ends of a container's veth pair. interface names
+// can't be longer than 15 characters, so only a part of the container id is used
+func vethNames(containerId string) (string, string) {
+	suffix := strings.TrimPrefix(containerId, "b-")
+	if len(suffix) > 8 {
+		suffix = suffix[:8]
+	}
+
+	return "veth" + suffix, "ceth" + suffix
+}
+
+// setupNetworking connects the container whose init process is pid to the focker0 bridge: one end
+// of a veth pair goes on the bridge & the other end is moved into the container's network
+// namespace as eth0 with the given address. outgoing traffic of the container is masqueraded
+// behind the host's address
+func setupNetworking(containerId string, pid int, ip string, ports []portMapping) error {
+	if err := ensureBridge(); err != nil {
+		return err
+	}
+
+	hostVeth, containerVeth := vethNames(containerId)
+	_, prefixLen, _ := strings.Cut(bridgeSubnet, "/")
+
+	err := runCommands(
+		[]string{"ip", "link", "add", hostVeth, "type", "veth", "peer", "name", containerVeth},
+		[]string{"ip", "link", "set", hostVeth, "master", bridgeName, "up"},
+		[]string{"ip", "link", "set", containerVeth, "netns", strconv.Itoa(pid)},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = inNetNamespace(pid, func() error {
+		return runCommands(
+			[]string{"ip", "link", "set", containerVeth, "name", "eth0"},
+			[]string{"ip", "addr", "add", ip + "/" + prefixLen, "dev", "eth0"},
+			[]string{"ip", "link", "set", "eth0", "up"},
+			[]string{"ip", "route", "add", "default", "via", bridgeIp},
+		)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range containerIptablesRules(ip, ports) {
+		if err := runCommands(rule.command("-A")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// teardownNetworking deletes what setupNetworking created for a container. the veth pair is
+// destroyed by the kernel anyway once the container's network namespace is gone, but the iptables
+// rules stay around until they're deleted. errors are only logged, since parts of the setup may
+// not have happened
+func teardownNetworking(containerId string, ip string, ports []portMapping) {
+	hostVeth, _ := vethNames(containerId)
+	if _, err := net.InterfaceByName(hostVeth); err == nil {
+		if err := runCommands([]string{"ip", "link", "del", hostVeth}); err != nil {
+			log.Print(err)
+		}
+	}
+
+	for _, rule := range containerIptablesRules(ip, ports) {
+		// -C checks whether the rule exists
+		if runCommands(rule.command("-C")) != nil {
+			continue
+		}
+
+		if err := runCommands(rule.command("-D")); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+type iptablesRule struct {
+	table string
+	chain string
+	spec  []string
+}
+
+// command returns the iptables command that does op (-A, -C or -D) with the rule
+func (rule iptablesRule) command(op string) []string {
+	return append([]string{"iptables", "-t", rule.table, op, rule.chain}, rule.spec...)
+}
+
+// containerIptablesRules returns the iptables rules that give a container outbound connectivity:
+// its traffic to the outside is masqueraded & forwarded, even when the FORWARD chain drops by
+// default (like on hosts with docker). connections to the host ports of the port mappings are
+// forwarded to the container, both when they come from outside (PREROUTING) & when they come from
+// the host itself to one of its addresses (OUTPUT)
+func containerIptablesRules(ip string, ports []portMapping) []iptablesRule {
+	rules := []iptablesRule{
+		{"nat", "POSTROUTING", []string{"-s", ip, "!", "-o", bridgeName, "-j", "MASQUERADE"}},
+		{"filter", "FORWARD", []string{"-s", ip, "-j", "ACCEPT"}},
+		{"filter", "FORWARD", []string{"-d", ip, "-j", "ACCEPT"}},
+	}
+
+	for _, port := range ports {
+		dnat := []string{
+			"-p", port.protocol, "--dport", strconv.Itoa(port.hostPort),
+			"-j", "DNAT", "--to-destination", ip + ":" + strconv.Itoa(port.containerPort),
+		}
+
+		rules = append(rules,
+			iptablesRule{"nat", "PREROUTING", append([]string{"-m", "addrtype", "--dst-type", "LOCAL"}, dnat...)},
+			iptablesRule{"nat", "OUTPUT", append([]string{"-m", "addrtype", "--dst-type", "LOCAL"}, dnat...)},
+		)
+	}
+
+	return rules
+}
+
+// a -p mapping of a port of the host to a port of the container
+type portMapping struct {
+	hostPort      int
+	containerPort int
+	protocol      string // tcp or udp
+}
+
+// parsePortMapping parses the value of -p, <hostPort>:<containerPort>[/tcp|udp]
+func parsePortMapping(spec string) (portMapping, error) {
+	ports, protocol, ok := strings.Cut(spec, "/")
+	if !ok {
+		protocol = "tcp"
+	}
+
+	if protocol != "tcp" && protocol != "udp" {
+		return portMapping{}, fmt.Errorf("invalid protocol in %q (expected tcp or udp)", spec)
+	}
+
+	hostPort, containerPort, ok := strings.Cut(ports, ":")
+	if !ok {
+		return portMapping{}, fmt.Errorf("invalid port mapping %q (expected <hostPort>:<containerPort>)", spec)
+	}
+
+	host, err := parsePort(hostPort)
+	if err != nil {
+		return portMapping{}, err
+	}
+
+	container, err := parsePort(containerPort)
+	if err != nil {
+		return portMapping{}, err
+	}
+
+	return portMapping{hostPort: host, containerPort: container, protocol: protocol}, nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q (expected 1-65535)", value)
+	}
+
+	return port, nil
+}
+
+// String returns the mapping in the format of -p
+func (mapping portMapping) String() string {
+	return fmt.Sprintf("%d:%d/%s", mapping.hostPort, mapping.containerPort, mapping.protocol)
+}
+
+// ensureBridge creates the focker0 bridge if it doesn't exist yet & makes sure that the host
+// forwards packets. the bridge is shared by all containers, so it's never deleted
+func ensureBridge() error {
+	if _, err := net.InterfaceByName(bridgeName); err != nil {
+		_, prefixLen, _ := strings.Cut(bridgeSubnet, "/")
+		err := runCommands(
+			[]string{"ip", "link", "add", bridgeName, "type", "bridge"},
+			[]string{"ip", "addr", "add", bridgeIp + "/" + prefixLen, "dev", bridgeName},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := runCommands([]string{"ip", "link", "set", bridgeName, "up"}); err != nil {
+		return err
+	}
+
+	return os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644)
+}
+
+// runCommands runs commands one after the other & stops at the first one that fails. the error
+// includes the output of the command, since that's where ip & iptables explain what went wrong
+func runCommands(commands ...[]string) error {
+	for _, command := range commands {
+		output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+		if err != nil {
+			if output := strings.TrimSpace(string(output)); len(output) > 0 {
+				return fmt.Errorf("%s: %v: %s", strings.Join(command, " "), err, output)
+			}
+
+			return fmt.Errorf("%s: %v", strings.Join(command, " "), err)
+		}
+	}
+
+	return nil
+}
+
+// inNetNamespace runs fn with the calling thread in the network namespace of the process pid, so
+// that the commands it runs configure that namespace. fn runs on a thread of its own that is never
+// unlocked, so the go runtime throws it away afterwards instead of reusing it in the wrong
+// namespace
+func inNetNamespace(pid int, fn func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		if err := joinNamespace(fmt.Sprintf("/proc/%d/ns/net", pid), syscall.CLONE_NEWNET); err != nil {
+			errs <- err
+			return
+		}
+
+		errs <- fn()
+	}()
+
+	return <-errs
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// how often a progressReader redraws its line
+const progressInterval = 100 * time.Millisecond
+
+// progressReader passes reads through to reader & reports how much of it has been read on a line
+// of out that it keeps redrawing, e.g. "downloading alpine:3.18: 45% (1.50MiB of 3.25MiB)". the
+// percentage is only shown if the total size is known, & the number of files once addEntry has
+// been called, e.g. for a tarball that's being extracted
+type progressReader struct {
+	reader io.Reader
+
+	// where the progress is reported, nil to not report it at all
+	out io.Writer
+
+	label string
+
+	// the size of what's read, or -1 if it isn't known (e.g. without a Content-Length)
+	total int64
+
+	read     int64
+	entries  int
+	reported time.Time
+}
+
+// newProgressReader returns a progressReader that reports on stderr if show is true. the line is
+// redrawn with \r, so it's only shown on a terminal
+func newProgressReader(reader io.Reader, label string, total int64, show bool) *progressReader {
+	progress := &progressReader{reader: reader, label: label, total: total}
+	if show && isTerminal(os.Stderr) {
+		progress.out = os.Stderr
+	}
+
+	return progress
+}
+
+func (progress *progressReader) Read(p []byte) (int, error) {
+	n, err := progress.reader.Read(p)
+	progress.read += int64(n)
+	progress.report(false)
+	return n, err
+}
+
+// addEntry counts a file of a tarball that's being extracted from the reader
+func (progress *progressReader) addEntry() {
+	progress.entries++
+	progress.report(false)
+}
+
+// done reports the final progress & ends its line
+func (progress *progressReader) done() {
+	progress.report(true)
+}
+
+func (progress *progressReader) report(final bool) {
+	if progress.out == nil || !final && time.Since(progress.reported) < progressInterval {
+		return
+	}
+
+	progress.reported = time.Now()
+
+	// \033[K clears what's left of a longer previous line
+	line := "\r" + progress.String() + "\033[K"
+	if final {
+		line += "\n"
+	}
+
+	fmt.Fprint(progress.out, line)
+}
+
+func (progress *progressReader) String() string {
+	line := fmt.Sprintf("%s: %s", progress.label, formatBytes(progress.read))
+	if progress.total > 0 {
+		percent := min(progress.read*100/progress.total, 100)
+		line = fmt.Sprintf("%s: %d%% (%s of %s)", progress.label, percent, formatBytes(progress.read), formatBytes(progress.total))
+	}
+
+	if progress.entries > 0 {
+		line += fmt.Sprintf(", %d files", progress.entries)
+	}
+
+	return line
+}
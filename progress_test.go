@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProgressReader(t *testing.T) {
+	data := strings.Repeat("x", 3*1024*1024)
+	var out bytes.Buffer
+	progress := &progressReader{reader: strings.NewReader(data), out: &out, label: "downloading alpine.tar.gz", total: int64(len(data))}
+
+	copied, err := io.Copy(io.Discard, progress)
+	if err != nil || copied != int64(len(data)) {
+		t.Fatalf("io.Copy() = %d, %v, want everything to be passed through", copied, err)
+	}
+
+	progress.done()
+
+	// the first read is reported, the rest are within progressInterval of it
+	lines := strings.Split(out.String(), "\r")
+	if want := "downloading alpine.tar.gz: 100% (3.00MiB of 3.00MiB)\033[K\n"; lines[len(lines)-1] != want {
+		t.Errorf("the last progress line is %q, want %q", lines[len(lines)-1], want)
+	}
+}
+
+func TestProgressReaderString(t *testing.T) {
+	tests := []struct {
+		progress progressReader
+		want     string
+	}{
+		{progressReader{label: "downloading a.tar.gz", total: 2048, read: 512}, "downloading a.tar.gz: 25% (512B of 2.00KiB)"},
+
+		// without a Content-Length
+		{progressReader{label: "downloading a.tar.gz", total: -1, read: 1536}, "downloading a.tar.gz: 1.50KiB"},
+
+		{progressReader{label: "extracting a.tar.gz", total: 2048, read: 2048, entries: 12}, "extracting a.tar.gz: 100% (2.00KiB of 2.00KiB), 12 files"},
+	}
+
+	for _, test := range tests {
+		if got := test.progress.String(); got != test.want {
+			t.Errorf("String() = %q, want %q", got, test.want)
+		}
+	}
+}
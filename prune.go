@@ -0,0 +1,199 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// prune removes every container that has exited, & those past their --ttl after stopping them,
+// & returns the exit code that focker should exit with. it asks for confirmation first, unless
+// args are -f (or --force)
+func prune(args []string) int {
+	force := false
+	for _, arg := range args {
+		switch arg {
+		case "-f", "--force":
+			force = true
+		default:
+			log.Print("usage: focker prune [-f]")
+			return 1
+		}
+	}
+
+	containerIds, err := prunableContainers()
+	if err != nil {
+		log.Printf("prune: %v", err)
+		return 1
+	}
+
+	expired, err := expiredContainers(time.Now())
+	if err != nil {
+		log.Printf("prune: %v", err)
+		return 1
+	}
+
+	// the expired containers that are running (or about to be restarted) are stopped first, like
+	// with focker stop
+	stopFirst := map[string]bool{}
+	for _, containerId := range expired {
+		if !slices.Contains(containerIds, containerId) {
+			containerIds = append(containerIds, containerId)
+			stopFirst[containerId] = true
+		}
+	}
+
+	if len(containerIds) == 0 {
+		return 0
+	}
+
+	if !force {
+		fmt.Printf("remove %d exited or expired container(s)? [y/N] ", len(containerIds))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return 0
+		}
+	}
+
+	exitCode := 0
+	var reclaimed int64
+	for _, containerId := range containerIds {
+		if stopFirst[containerId] {
+			if err := stopContainer(containerId, defaultStopTimeout); err != nil {
+				log.Print(err)
+				exitCode = 1
+				continue
+			}
+		}
+
+		size := diskUsage(containerDir(containerId))
+		if err := removeContainer(containerId); err != nil {
+			log.Print(err)
+			exitCode = 1
+			continue
+		}
+
+		// the cgroup is normally removed once the container exits, but not if focker was killed
+		if err := removeContainerCgroup(containerId); err != nil {
+			log.Print(err)
+		}
+
+		reclaimed += size
+		fmt.Println(containerId)
+	}
+
+	fmt.Printf("reclaimed %s\n", formatBytes(reclaimed))
+	return exitCode
+}
+
+// prunableContainers returns the ids of the containers that have exited, including those whose
+// process is gone without focker having recorded it. containers that are still being set up,
+// & those that their --restart policy is about to restart, are left alone
+func prunableContainers() ([]string, error) {
+	files, err := os.ReadDir(containersDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var containerIds []string
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		config, err := readContainerConfig(file.Name())
+		if err != nil {
+			continue
+		}
+
+		refreshContainerState(config)
+		if config.State != stateExited || awaitsRestartPolicy(config) {
+			continue
+		}
+
+		containerIds = append(containerIds, file.Name())
+	}
+
+	return containerIds, nil
+}
+
+// expiredContainers returns the ids of the containers whose --ttl has passed at now, whatever
+// their state. those that are still being set up are left for the next prune
+func expiredContainers(now time.Time) ([]string, error) {
+	files, err := os.ReadDir(containersDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var containerIds []string
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+
+		config, err := readContainerConfig(file.Name())
+		if err != nil || config.Expires == nil || now.Before(*config.Expires) {
+			continue
+		}
+
+		if config.State == stateCreated && isParentRunning(config) {
+			continue
+		}
+
+		containerIds = append(containerIds, file.Name())
+	}
+
+	return containerIds, nil
+}
+
+// awaitsRestartPolicy tells whether the --restart policy of a container that has exited restarts it,
+// in which case its _monitor process is waiting for the backoff delay to run it again
+func awaitsRestartPolicy(config *containerConfig) bool {
+	if len(config.RestartPolicy) == 0 || config.ManuallyStopped || config.ExitCode == nil {
+		return false
+	}
+
+	policy, err := parseRestartPolicy(config.RestartPolicy)
+	return err == nil && policy.restarts(*config.ExitCode, config.RestartCount)
+}
+
+// diskUsage returns the size of the files under dir, counting hard links to the same file once,
+// like du does
+func diskUsage(dir string) int64 {
+	var size int64
+	seen := map[uint64]bool{}
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			if seen[stat.Ino] {
+				return nil
+			}
+
+			seen[stat.Ino] = true
+			size += stat.Blocks * 512
+			return nil
+		}
+
+		size += info.Size()
+		return nil
+	})
+
+	return size
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExpiredContainers(t *testing.T) {
+	useTempContainersDir(t)
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	parentStarted, err := processStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers := []struct {
+		config  containerConfig
+		expired bool
+	}{
+		{containerConfig{Id: "b-no-ttl", State: stateExited}, false},
+		{containerConfig{Id: "b-later", State: stateExited, Expires: &future}, false},
+		{containerConfig{Id: "b-exited", State: stateExited, Expires: &past}, true},
+
+		// whatever their state
+		{containerConfig{Id: "b-running", State: stateRunning, Pid: os.Getpid(), Expires: &past}, true},
+		{containerConfig{Id: "b-created", State: stateCreated, Expires: &past}, true},
+
+		// except while focker run is still setting it up
+		{containerConfig{Id: "b-setup", State: stateCreated, ParentPid: os.Getpid(), ParentStarted: parentStarted, Expires: &past}, false},
+	}
+
+	for _, container := range containers {
+		writeTestContainer(t, &container.config)
+	}
+
+	expired, err := expiredContainers(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, container := range containers {
+		if got := slices.Contains(expired, container.config.Id); got != container.expired {
+			t.Errorf("%s: expired = %v, want %v", container.config.Id, got, container.expired)
+		}
+	}
+}
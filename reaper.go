@@ -0,0 +1,57 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+)
+
+// PR_SET_CHILD_SUBREAPER, which the syscall package doesn't have
+const prSetChildSubreaper = 36
+
+// becomeSubreaper makes the processes of the container that are orphaned become our children
+// even when we aren't pid 1 of the container's pid namespace, i.e. with --pid=host or
+// --pid=container:<id>, so that reapUntilExit reaps them either way
+func becomeSubreaper() error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// reapUntilExit waits until our child with pid exits & returns its status, reaping every other
+// child that exits in the meantime. the _child process is the init of the container, so the
+// processes of the container that are orphaned (e.g. the children that a shell leaves behind)
+// become its children, & they'd stay zombies until the container exits if nobody waited for them
+func reapUntilExit(pid int) (syscall.WaitStatus, error) {
+	for {
+		var status syscall.WaitStatus
+		reaped, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if reaped == pid {
+			return status, nil
+		}
+	}
+}
+
+// reapProcessGroup reaps the children that exit until no process is left in the process group
+// pgid. with --kill-process-group, the processes of the group that got a signal along with the
+// command (e.g. the children of an entrypoint script) may still be shutting down after it exited,
+// & the kernel would kill them as soon as the container's init exits
+func reapProcessGroup(pgid int) {
+	for syscall.Kill(-pgid, 0) == nil {
+		var status syscall.WaitStatus
+		if _, err := syscall.Wait4(-1, &status, 0, nil); err != nil && err != syscall.EINTR {
+			return
+		}
+	}
+}
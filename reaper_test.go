@@ -0,0 +1,71 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestReapUntilExitReapsOrphans(t *testing.T) {
+	// like the _child process with --pid=host, so that the orphans become our children
+	if err := becomeSubreaper(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subshell starts a sleep & exits right away, which abandons the sleep. it exits long
+	// before the shell does, so it'd be a zombie until the end of the test if nobody reaped it
+	cmd := exec.Command("/bin/sh", "-c", "(sleep 0.1 & echo $!); sleep 0.5; exit 7")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphan, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := reapUntilExit(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := waitStatusExitCode(status); code != 7 {
+		t.Errorf("exit code = %d, want 7", code)
+	}
+
+	// kill(2) still finds a zombie, so the orphan is only gone if it was reaped
+	if err := syscall.Kill(orphan, 0); err != syscall.ESRCH {
+		t.Errorf("the orphan %d is still there after reapUntilExit (kill: %v)", orphan, err)
+	}
+}
+
+func TestReapUntilExitSignaled(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "kill -TERM $$")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := reapUntilExit(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := waitStatusExitCode(status); code != 128+int(syscall.SIGTERM) {
+		t.Errorf("exit code = %d, want %d", code, 128+int(syscall.SIGTERM))
+	}
+}
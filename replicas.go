@@ -0,0 +1,93 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runReplicas runs --replicas identical containers at once & returns the exit code that focker
+// should exit with. flagArgs are the arguments of run. each replica is a focker run of its own
+// (without --replicas), so it gets its own id, rootfs, mounts, address & cgroup, & with --name,
+// the replicas are named <name>-1, <name>-2, ... it waits until every replica has exited (with -d,
+// until each one is running) & exits with the exit code of the first replica that failed, or 0
+func runReplicas(flagArgs []string, options runOptions) int {
+	path, err := os.Executable()
+	exitIfError(err, "os.Executable()")
+
+	// the replicas start at once, so they'd all find the same address free, & they'd all try to
+	// create the bridge if it doesn't exist yet
+	var ips []string
+	if options.net == "bridge" {
+		ips, err = allocateContainerIps(options.replicas)
+		exitIfError(err, "--replicas")
+		exitIfError(ensureBridge(), "--net=bridge")
+	}
+
+	replicas := make([]*exec.Cmd, options.replicas)
+	for i := range replicas {
+		args := []string{"run"}
+		if len(options.name) > 0 {
+			args = append(args, fmt.Sprintf("--name=%s-%d", options.name, i+1))
+		}
+
+		if len(ips) > 0 {
+			args = append(args, "--ip="+ips[i])
+		}
+
+		args = append(args, replicaArgs(flagArgs)...)
+
+		// the replicas can't share stdin, so they get none
+		replica := exec.Command(path, args...)
+		replica.Stdout, replica.Stderr = os.Stdout, os.Stderr
+		if err := replica.Start(); err != nil {
+			log.Printf("failed to start replica %d: %v", i+1, err)
+			continue
+		}
+
+		replicas[i] = replica
+
+		// each replica is the parent of its container, which waits for it & cleans up after
+		// it, so signals are passed on to the replicas rather than killing us
+		stopForwarding := forwardSignals(replica.Process, false, sentByTerminal)
+		defer stopForwarding()
+	}
+
+	result := 0
+	for i, replica := range replicas {
+		code := 1
+		if replica != nil {
+			replica.Wait()
+			code = exitCode(replica.ProcessState)
+		}
+
+		if code != 0 {
+			debugf("replica %d exited with %d", i+1, code)
+			if result == 0 {
+				result = code
+			}
+		}
+	}
+
+	return result
+}
+
+// replicaArgs returns the arguments of run for a replica, i.e. flagArgs without --replicas & --name
+func replicaArgs(flagArgs []string) []string {
+	var args []string
+	for i, arg := range flagArgs {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			return append(args, flagArgs[i:]...)
+		}
+
+		if !strings.HasPrefix(arg, "--replicas=") && !strings.HasPrefix(arg, "--name=") {
+			args = append(args, arg)
+		}
+	}
+
+	return args
+}
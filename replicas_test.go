@@ -0,0 +1,71 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReplicaArgs(t *testing.T) {
+	tests := []struct {
+		flagArgs []string
+		want     []string
+	}{
+		{
+			[]string{"--replicas=3", "ubuntu", "/bin/sleep", "60"},
+			[]string{"ubuntu", "/bin/sleep", "60"},
+		},
+		{
+			[]string{"-d", "--name=web", "--replicas=2", "-e=A=1", "ubuntu", "/bin/sh"},
+			[]string{"-d", "-e=A=1", "ubuntu", "/bin/sh"},
+		},
+
+		// the command's own arguments are left alone, even if they look like ours
+		{
+			[]string{"--replicas=2", "/bin/echo", "--replicas=5", "--name=x"},
+			[]string{"/bin/echo", "--replicas=5", "--name=x"},
+		},
+		{
+			[]string{"--replicas=2", "--", "--name=x", "--replicas=5"},
+			[]string{"--", "--name=x", "--replicas=5"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := replicaArgs(test.flagArgs); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("replicaArgs(%q) = %q, want %q", test.flagArgs, got, test.want)
+		}
+	}
+}
+
+func TestAllocateContainerIps(t *testing.T) {
+	useTempContainersDir(t)
+
+	// an exited container doesn't hold on to its address
+	exited := &containerConfig{Id: "b-exited", State: stateExited, Ip: "172.29.0.2"}
+	if err := os.Mkdir(containerDir(exited.Id), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeContainerConfig(exited); err != nil {
+		t.Fatal(err)
+	}
+
+	ips, err := allocateContainerIps(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"172.29.0.2", "172.29.0.3", "172.29.0.4"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("allocateContainerIps(3) = %q, want %q", ips, want)
+	}
+
+	// each replica checks the address it's given like any --ip
+	for _, ip := range ips {
+		if got, err := allocateContainerIp(ip); err != nil || got != ip {
+			t.Errorf("allocateContainerIp(%s) = %q, %v", ip, got, err)
+		}
+	}
+}
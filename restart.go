@@ -0,0 +1,110 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the delay before restarting a container doubles after each restart, from restartBackoffStart up
+// to restartBackoffMax, & starts over once the container has run for restartBackoffReset
+const (
+	restartBackoffStart = time.Second
+	restartBackoffMax   = time.Minute
+	restartBackoffReset = 10 * time.Second
+)
+
+// when a detached container is restarted once it exits, from --restart
+type restartPolicy struct {
+	// "no", "on-failure" or "always"
+	name string
+
+	// how many times on-failure restarts the container at most, 0 for no limit
+	maxRetries int
+}
+
+// parseRestartPolicy parses the value of --restart, i.e. no, always or on-failure[:<max retries>]
+func parseRestartPolicy(value string) (restartPolicy, error) {
+	name, retries, hasRetries := strings.Cut(value, ":")
+	policy := restartPolicy{name: name}
+
+	switch name {
+	case "no", "always":
+		if hasRetries {
+			return restartPolicy{}, fmt.Errorf("a max retry count can only be given with on-failure")
+		}
+	case "on-failure":
+		if hasRetries {
+			maxRetries, err := strconv.Atoi(retries)
+			if err != nil || maxRetries <= 0 {
+				return restartPolicy{}, fmt.Errorf("invalid max retry count %q, it must be a positive integer", retries)
+			}
+
+			policy.maxRetries = maxRetries
+		}
+	default:
+		return restartPolicy{}, fmt.Errorf("invalid policy %q, expected no, on-failure[:<max retries>] or always", value)
+	}
+
+	return policy, nil
+}
+
+// String formats the policy like --restart
+func (policy restartPolicy) String() string {
+	if policy.maxRetries > 0 {
+		return fmt.Sprintf("%s:%d", policy.name, policy.maxRetries)
+	}
+
+	return policy.name
+}
+
+// restarts tells whether the policy restarts a container that exited with exitCode after it was
+// restarted restartCount times
+func (policy restartPolicy) restarts(exitCode int, restartCount int) bool {
+	switch policy.name {
+	case "always":
+		return true
+	case "on-failure":
+		return exitCode != 0 && (policy.maxRetries == 0 || restartCount < policy.maxRetries)
+	default:
+		return false
+	}
+}
+
+// runWithRestarts runs a container like run does & runs it again whenever it exits, as long as its
+// restart policy says so & it wasn't stopped with focker stop. run cleans up after the container
+// (its mounts, network & cgroup) before it returns, so each restart starts from scratch
+func runWithRestarts(containerId string, args []string, options runOptions) (int, error) {
+	backoff := restartBackoffStart
+	for {
+		started := time.Now()
+		code, err := run(containerId, args, options, false)
+		if err != nil || !options.restart.restarts(code, options.restartCount) || !awaitsRestart(containerId) {
+			return code, err
+		}
+
+		if time.Since(started) >= restartBackoffReset {
+			backoff = restartBackoffStart
+		}
+
+		infof("container %s exited with %d, restarting it in %s", containerId, code, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, restartBackoffMax)
+
+		if !awaitsRestart(containerId) {
+			return code, nil
+		}
+
+		options.restartCount++
+	}
+}
+
+// awaitsRestart tells whether a container that has exited can still be restarted, i.e. it hasn't
+// been stopped with focker stop or removed since
+func awaitsRestart(containerId string) bool {
+	config, err := readContainerConfig(containerId)
+	return err == nil && !config.ManuallyStopped
+}
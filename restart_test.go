@@ -0,0 +1,78 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestKeepRecordedStateKeepsManualStops(t *testing.T) {
+	useTempContainersDir(t)
+
+	tests := []struct {
+		name    string
+		current containerConfig
+	}{
+		// focker stop is still waiting for the container to exit
+		{"stopping", containerConfig{State: stateStopped, ManuallyStopped: true}},
+
+		// focker stop has already marked it as exited
+		{"exited", containerConfig{State: stateExited, ManuallyStopped: true}},
+	}
+
+	for _, test := range tests {
+		containerId := "b-" + test.name
+		if err := os.Mkdir(containerDir(containerId), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		// what focker run has of the container while it runs
+		config := &containerConfig{Id: containerId, State: stateRunning, RestartPolicy: "always"}
+		test.current.Id = containerId
+		keepRecordedState(config, &test.current)
+		if err := setContainerState(config, stateExited); err != nil {
+			t.Errorf("%s: setContainerState(exited) = %v", test.name, err)
+		}
+
+		if awaitsRestart(containerId) {
+			t.Errorf("%s: the container awaits a restart after focker stop", test.name)
+		}
+	}
+}
+
+func TestKeepRecordedStateKeepsHealth(t *testing.T) {
+	config := &containerConfig{State: stateRunning, Health: &containerHealth{Status: healthStarting}}
+	keepRecordedState(config, &containerConfig{State: stateRunning, Health: &containerHealth{Status: healthUnhealthy, FailingStreak: 3}})
+
+	if config.Health.Status != healthUnhealthy || config.ManuallyStopped || config.State != stateRunning {
+		t.Errorf("keepRecordedState() = %+v, want the recorded health & nothing else", config)
+	}
+}
+
+func TestRestartPolicyRestarts(t *testing.T) {
+	tests := []struct {
+		policy       string
+		exitCode     int
+		restartCount int
+		want         bool
+	}{
+		{"no", 1, 0, false},
+		{"always", 0, 10, true},
+		{"on-failure", 0, 0, false},
+		{"on-failure", 1, 100, true},
+		{"on-failure:3", 1, 2, true},
+		{"on-failure:3", 1, 3, false},
+	}
+
+	for _, test := range tests {
+		policy, err := parseRestartPolicy(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := policy.restarts(test.exitCode, test.restartCount); got != test.want {
+			t.Errorf("%s.restarts(%d, %d) = %v, want %v", test.policy, test.exitCode, test.restartCount, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// rm removes containers & returns the exit code that focker should exit with. args are the ids of
+// the containers, or --all to remove every container that has exited
+func rm(args []string) int {
+	if len(args) == 1 && args[0] == "--all" {
+		return rmAll()
+	}
+
+	exitCode := 0
+	for _, ref := range args {
+		containerId, err := resolveContainerId(ref)
+		if err == nil {
+			err = removeContainer(containerId)
+		}
+
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			continue
+		}
+
+		fmt.Println(ref)
+	}
+
+	return exitCode
+}
+
+// rmAll removes the containers that have exited, like prune. containers that are still being set
+// up are left alone, & so are those that their --restart policy is about to restart, since their
+// focker run would fail halfway through otherwise
+func rmAll() int {
+	containerIds, err := prunableContainers()
+	exitIfError(err, "rm --all")
+
+	exitCode := 0
+	for _, containerId := range containerIds {
+		if err := removeContainer(containerId); err != nil {
+			log.Print(err)
+			exitCode = 1
+			continue
+		}
+
+		fmt.Println(containerId)
+	}
+
+	return exitCode
+}
+
+// discardContainer removes a container whose setup failed, after unmounting whatever of it is
+// still mounted in our mount namespace, see cleanupContainerMounts
+func discardContainer(containerId string) {
+	cleanupContainerMounts(containerId)
+	if err := removeContainer(containerId); err != nil {
+		log.Print(err)
+		return
+	}
+
+	debugf("removed container %s, whose setup failed", containerId)
+}
+
+// removeContainer deletes the directory of a container that isn't running. it refuses to if
+// anything is still mounted under it, since removing the directory would then delete files of
+// the host through the bind mounts
+func removeContainer(containerId string) error {
+	config, err := readContainerConfig(containerId)
+	if err != nil {
+		return err
+	}
+
+	if isContainerRunning(config) {
+		return fmt.Errorf("container %s is running", containerId)
+	}
+
+	mountPoints, err := listMountsUnder(containerDir(containerId))
+	if err != nil {
+		return fmt.Errorf("failed to list mounts of container %s: %w", containerId, err)
+	}
+
+	if len(mountPoints) > 0 {
+		return fmt.Errorf("container %s still has mounts: %s", containerId, strings.Join(mountPoints, ", "))
+	}
+
+	refreshContainerState(config)
+
+	if err := setContainerState(config, stateRemoving); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(containerDir(containerId)); err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", containerId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// writeTestContainer creates the directory & the config of a container in containersDir
+func writeTestContainer(t *testing.T, config *containerConfig) {
+	if err := os.Mkdir(containerDir(config.Id), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeContainerConfig(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRmAllRemovesOnlyExitedContainers(t *testing.T) {
+	useTempContainersDir(t)
+
+	failed := 1
+	containers := []struct {
+		config  containerConfig
+		removed bool
+	}{
+		{containerConfig{Id: "b-exited", State: stateExited}, true},
+
+		// its process is gone without focker having recorded it
+		{containerConfig{Id: "b-gone", State: stateRunning, Pid: -1}, true},
+
+		// focker run is still setting it up
+		{containerConfig{Id: "b-created", State: stateCreated}, false},
+
+		// its _monitor process is waiting to restart it
+		{containerConfig{Id: "b-restarting", State: stateExited, RestartPolicy: "on-failure", ExitCode: &failed}, false},
+
+		// unless it was stopped with focker stop
+		{containerConfig{Id: "b-stopped", State: stateExited, RestartPolicy: "always", ExitCode: &failed, ManuallyStopped: true}, true},
+	}
+
+	for _, container := range containers {
+		writeTestContainer(t, &container.config)
+	}
+
+	if code := rmAll(); code != 0 {
+		t.Errorf("rmAll() = %d, want 0", code)
+	}
+
+	for _, container := range containers {
+		_, err := os.Stat(containerDir(container.config.Id))
+		if removed := os.IsNotExist(err); removed != container.removed {
+			t.Errorf("%s: removed = %v, want %v", container.config.Id, removed, container.removed)
+		}
+	}
+}
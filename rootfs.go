@@ -0,0 +1,501 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// rootfsLowerDir returns where the tarball is extracted, e.g. images/ubuntu-base-22.04-base-amd64.
+// with user namespaces, the files are owned by the mapped ids of the container, so there's a
+// separate copy for them, e.g. images/ubuntu-base-22.04-base-amd64@100000
+func rootfsLowerDir(tarball string, rootId int) string {
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(tarball), ".gz"), ".tar")
+	if rootId > 0 {
+		name += "@" + strconv.Itoa(rootId)
+	}
+
+	return filepath.Join(imagesDir, name)
+}
+
+// removeRootfsLowerDirs removes the dirs that the tarball was extracted to, i.e. its lower dir &
+// the copies for user namespaces, so that the next container extracts it again. each one is
+// renamed first, so that a container that starts meanwhile never sees a partly removed one
+func removeRootfsLowerDirs(tarball string) error {
+	lowerDir := rootfsLowerDir(tarball, 0)
+	copies, err := filepath.Glob(lowerDir + "@*")
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range append([]string{lowerDir}, copies...) {
+		tmpDir, err := os.MkdirTemp(imagesDir, ".stale-")
+		if err != nil {
+			return err
+		}
+
+		renameErr := os.Rename(dir, filepath.Join(tmpDir, "rootfs"))
+		if renameErr != nil && !os.IsNotExist(renameErr) {
+			os.Remove(tmpDir)
+			return renameErr
+		}
+
+		if err := os.RemoveAll(tmpDir); err != nil {
+			return err
+		}
+
+		if renameErr == nil {
+			debugf("removed %s, which was extracted from the previous %s", dir, tarball)
+		}
+	}
+
+	return nil
+}
+
+// imageLayers returns the tarballs of an image, from the bottom layer to the top one. an image is
+// either a single tarball or a manifest (e.g. images/myapp.manifest) that lists the file names of
+// the tarballs of its layers in imagesDir, one per line & bottom first. empty lines & lines that
+// start with # are skipped
+func imageLayers(image string) ([]string, error) {
+	if !strings.HasSuffix(image, ".manifest") {
+		return []string{image}, nil
+	}
+
+	data, err := os.ReadFile(image)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// the layers are extracted next to their tarballs, by the name of the tarball
+		if strings.Contains(line, "/") || !strings.HasSuffix(line, ".tar.gz") {
+			return nil, fmt.Errorf("%s:%d: invalid layer %q, expected the name of a .tar.gz in %s", image, i+1, line, imagesDir)
+		}
+
+		// they separate the lower dirs & the options of the overlay, see mountRootfs
+		if strings.ContainsAny(line, ":,") {
+			return nil, fmt.Errorf("%s:%d: invalid layer %q, the name can't contain : or ,", image, i+1, line)
+		}
+
+		layers = append(layers, filepath.Join(imagesDir, line))
+	}
+
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("%s doesn't list any layers", image)
+	}
+
+	return layers, nil
+}
+
+// rootfsLowerDirs returns where the layers of an image are extracted, from the top layer to the
+// bottom one like the lowerdir option of overlayfs
+func rootfsLowerDirs(image string, rootId int) ([]string, error) {
+	layers, err := imageLayers(image)
+	if err != nil {
+		return nil, err
+	}
+
+	lowerDirs := make([]string, len(layers))
+	for i, layer := range layers {
+		lowerDirs[len(layers)-1-i] = rootfsLowerDir(layer, rootId)
+	}
+
+	return lowerDirs, nil
+}
+
+// checkRootfsTarball makes sure that the tarball of each layer of the image exists, unless it was
+// already extracted for rootId, so that a missing image is reported before anything of the
+// container is set up
+func checkRootfsTarball(image string, rootId int) error {
+	layers, err := imageLayers(image)
+	if err != nil {
+		return err
+	}
+
+	for _, tarball := range layers {
+		if _, err := os.Stat(rootfsLowerDir(tarball, rootId)); err == nil {
+			continue
+		}
+
+		if _, err := os.Stat(tarball); err != nil {
+			if os.IsNotExist(err) && tarball != image {
+				return fmt.Errorf("the tarball %s of a layer of %s doesn't exist", tarball, image)
+			}
+
+			if os.IsNotExist(err) {
+				return fmt.Errorf("the rootfs tarball %s doesn't exist. pull an image with focker pull (e.g. focker pull ubuntu:22.04) or put the tarball there", tarball)
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareRootfsLowers extracts each layer of the image with prepareRootfsLower & returns their
+// lower dirs like rootfsLowerDirs
+func prepareRootfsLowers(image string, rootId int) ([]string, error) {
+	layers, err := imageLayers(image)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tarball := range layers {
+		if _, err := prepareRootfsLower(tarball, rootId); err != nil {
+			return nil, err
+		}
+	}
+
+	return rootfsLowerDirs(image, rootId)
+}
+
+// prepareRootfsLower extracts the tarball into its lower dir unless that was already done. the
+// tarball is extracted into a temporary dir that's renamed once it's complete, so that a failed
+// extraction or another focker extracting it at the same time never leave a partial lower dir
+func prepareRootfsLower(tarball string, rootId int) (string, error) {
+	lowerDir := rootfsLowerDir(tarball, rootId)
+	if _, err := os.Stat(lowerDir); err == nil {
+		debugf("%s is already extracted to %s", tarball, lowerDir)
+		return lowerDir, nil
+	}
+
+	tmpDir, err := os.MkdirTemp(imagesDir, ".extract-")
+	if err != nil {
+		return "", err
+	}
+
+	// this becomes / of the containers
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+
+	if err := unzipRootFsTarball(tmpDir, tarball); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("extract %s: %w", tarball, err)
+	}
+
+	if rootId > 0 {
+		if err := shiftOwnership(tmpDir, rootId); err != nil {
+			os.RemoveAll(tmpDir)
+			return "", fmt.Errorf("shift ownership of the rootfs: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmpDir, lowerDir); err != nil {
+		os.RemoveAll(tmpDir)
+
+		// someone else extracted it first
+		if _, statErr := os.Stat(lowerDir); statErr == nil {
+			return lowerDir, nil
+		}
+
+		return "", err
+	}
+
+	return lowerDir, nil
+}
+
+// unzipRootFsTarball extracts a .tar.gz into dest, keeping the owners, modes (setuid & setgid bits
+// included), modification times, symlinks, hard links & device nodes of its entries. entries
+// can't end up outside of dest, neither through .. nor through symlinks that the tarball creates
+func unzipRootFsTarball(dest string, src string) error {
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// big images take a while, so the progress is shown by how much of the tarball was read
+	progress := newProgressReader(file, "extracting "+filepath.Base(src), info.Size(), currentLogLevel <= levelInfo)
+	defer progress.done()
+
+	gzipReader, err := gzip.NewReader(progress)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	// the times of directories are set at the end, since extracting into them changes them
+	var dirs []*tar.Header
+
+	reader := tar.NewReader(gzipReader)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		progress.addEntry()
+
+		path, err := tarEntryPath(dest, header.Name)
+		if err != nil {
+			return err
+		}
+
+		if path == dest {
+			// the root dir, which prepareRootfsLower has already set up
+			continue
+		}
+
+		// the whiteouts of a layer hide files of the layers below it
+		if handled, err := extractWhiteout(path); handled || err != nil {
+			if err != nil {
+				return fmt.Errorf("%s: %w", header.Name, err)
+			}
+
+			continue
+		}
+
+		if err := extractTarEntry(dest, path, header, reader); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			dirs = append(dirs, header)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path, _ := tarEntryPath(dest, dirs[i].Name)
+		if err := os.Chtimes(path, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+
+	debugf("extracted %s to %s", src, dest)
+	return nil
+}
+
+// tarEntryPath returns where the entry name of a tarball is extracted to in dest. it fails if
+// name is absolute or has .. in it, which tar itself strips or refuses, or if any of its parent
+// dirs is a symlink, which could point anywhere
+func tarEntryPath(dest string, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("%s is an absolute path in the tarball", name)
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%s has .. in it in the tarball", name)
+		}
+	}
+
+	relative := filepath.Clean("/" + name)
+	if relative == "/" {
+		return dest, nil
+	}
+
+	path := dest
+	parts := strings.Split(strings.TrimPrefix(relative, "/"), "/")
+	for _, part := range parts[:len(parts)-1] {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+
+			return "", err
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is under a symlink in the tarball", name)
+		}
+	}
+
+	return filepath.Join(dest, relative), nil
+}
+
+// extractTarEntry creates path from a tarball entry, replacing whatever was there
+func extractTarEntry(dest string, path string, header *tar.Header, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// a directory is only replaced by something else, e.g. if the tarball has it twice
+	if info, err := os.Lstat(path); err == nil && !(info.IsDir() && header.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	mode := uint32(header.Mode) & 07777
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg:
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(file, content)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(header.Linkname, path); err != nil {
+			return err
+		}
+
+		// symlinks have no mode or times of their own that matter
+		return os.Lchown(path, header.Uid, header.Gid)
+	case tar.TypeLink:
+		target, err := tarEntryPath(dest, header.Linkname)
+		if err != nil {
+			return err
+		}
+
+		// the target was extracted already, with its owner & mode, which the link shares
+		return os.Link(target, path)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		fileType := map[byte]uint32{tar.TypeChar: syscall.S_IFCHR, tar.TypeBlock: syscall.S_IFBLK, tar.TypeFifo: syscall.S_IFIFO}[header.Typeflag]
+		if err := syscall.Mknod(path, fileType|0600, int(mkdev(header.Devmajor, header.Devminor))); err != nil {
+			return err
+		}
+	default:
+		// e.g. pax headers that the reader doesn't handle itself
+		return nil
+	}
+
+	// chown clears the setuid & setgid bits, so the mode comes after it
+	if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+		return err
+	}
+
+	if err := syscall.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, header.ModTime, header.ModTime)
+}
+
+// extractWhiteout turns a whiteout of a layer tarball (as docker creates them) into one of
+// overlayfs, & tells whether path was one. .wh.<name> deletes <name> of the lower layers, which
+// overlayfs does with a 0:0 character device, & .wh..wh..opq hides everything in the lower
+// layers' copies of its directory, which overlayfs does with the trusted.overlay.opaque xattr
+func extractWhiteout(path string) (bool, error) {
+	dir, name := filepath.Split(path)
+	if !strings.HasPrefix(name, ".wh.") {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return true, err
+	}
+
+	if name == ".wh..wh..opq" {
+		return true, syscall.Setxattr(dir, "trusted.overlay.opaque", []byte("y"), 0)
+	}
+
+	path = filepath.Join(dir, strings.TrimPrefix(name, ".wh."))
+	if err := os.RemoveAll(path); err != nil {
+		return true, err
+	}
+
+	return true, syscall.Mknod(path, syscall.S_IFCHR, 0)
+}
+
+// mkdev combines a major & a minor device number into a dev_t, like makedev(3)
+func mkdev(major int64, minor int64) uint64 {
+	return uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32
+}
+
+// mountRootfs mounts the container's rootfs as an overlay of the shared lower dirs (the top layer
+// first, see rootfsLowerDirs), with the container's writes going to its own upper dir. the
+// overlay lives in the container's mount namespace, so it goes away along with the namespace
+// rather than being unmounted (it can't be once it's been made the root with pivot_root)
+func mountRootfs(containerId string, lowerDirs []string) error {
+	rootfsDir := containerRootfsDir(containerId)
+	upperDir := filepath.Join(containerDir(containerId), "upper")
+	workDir := filepath.Join(containerDir(containerId), "work")
+
+	for _, dir := range []string{rootfsDir, upperDir, workDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	if len(lowerDirs) == 0 {
+		return fmt.Errorf("the rootfs has no layers")
+	}
+
+	absLowerDirs := make([]string, len(lowerDirs))
+	for i, lowerDir := range lowerDirs {
+		absLowerDir, err := filepath.Abs(lowerDir)
+		if err != nil {
+			return err
+		}
+
+		// overlayfs only reports EINVAL or ENOENT for a missing one, without saying which
+		if info, err := os.Stat(absLowerDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("the layer %s of the rootfs isn't extracted", absLowerDir)
+		}
+
+		absLowerDirs[i] = absLowerDir
+	}
+
+	// the root of the overlay is the upper dir, so it should have the same owner & mode as the
+	// image's top layer
+	if err := copyOwnerAndMode(upperDir, absLowerDirs[0]); err != nil {
+		return err
+	}
+
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(absLowerDirs, ":"), upperDir, workDir)
+	if err := syscall.Mount("overlay", rootfsDir, "overlay", 0, data); err != nil {
+		return fmt.Errorf("mount overlay rootfs: %w", err)
+	}
+
+	return nil
+}
+
+// copyOwnerAndMode gives the directory path the same owner, group & permissions as reference
+func copyOwnerAndMode(path string, reference string) error {
+	info, err := os.Stat(reference)
+	if err != nil {
+		return err
+	}
+
+	stat := info.Sys().(*syscall.Stat_t)
+	if err := os.Chown(path, int(stat.Uid), int(stat.Gid)); err != nil {
+		return err
+	}
+
+	return os.Chmod(path, info.Mode().Perm()|info.Mode()&(fs.ModeSticky|fs.ModeSetgid))
+}
@@ -0,0 +1,344 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTarball writes a .tar.gz of headers to a temporary file & returns its path. the content of
+// regular files is their name
+func writeTarball(t *testing.T, headers []*tar.Header) string {
+	path := filepath.Join(t.TempDir(), "rootfs.tar.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	writer := tar.NewWriter(gzipWriter)
+	for _, header := range headers {
+		header.Uid, header.Gid = os.Getuid(), os.Getgid()
+		header.ModTime = time.Unix(1700000000, 0)
+		if header.Mode == 0 {
+			header.Mode = 0755
+		}
+
+		var content []byte
+		if header.Typeflag == tar.TypeReg {
+			content = []byte(header.Name)
+			header.Size = int64(len(content))
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := writer.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestUnzipRootFsTarball(t *testing.T) {
+	tarball := writeTarball(t, []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir},
+		{Name: "./bin/", Typeflag: tar.TypeDir},
+		{Name: "./bin/sh", Typeflag: tar.TypeReg},
+		{Name: "./etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "./usr/bin/bash", Typeflag: tar.TypeLink, Linkname: "./bin/sh"},
+		{Name: "./etc/os-release", Typeflag: tar.TypeSymlink, Linkname: "../usr/lib/os-release"},
+	})
+
+	dest := filepath.Join(t.TempDir(), "rootfs")
+	if err := unzipRootFsTarball(dest, tarball); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dest, "bin/sh")); err != nil || string(data) != "./bin/sh" {
+		t.Errorf("bin/sh = %q, %v, want %q", data, err, "./bin/sh")
+	}
+
+	if info, err := os.Stat(filepath.Join(dest, "etc/passwd")); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("etc/passwd: %v, %v, want mode 0644", info, err)
+	}
+
+	sh, _ := os.Stat(filepath.Join(dest, "bin/sh"))
+	if bash, err := os.Stat(filepath.Join(dest, "usr/bin/bash")); err != nil || !os.SameFile(sh, bash) {
+		t.Errorf("usr/bin/bash isn't a hard link to bin/sh: %v", err)
+	}
+
+	// symlinks are extracted as they are, even when they point outside of the rootfs, since
+	// they're resolved inside the container
+	if target, err := os.Readlink(filepath.Join(dest, "etc/os-release")); err != nil || target != "../usr/lib/os-release" {
+		t.Errorf("etc/os-release -> %q, %v, want ../usr/lib/os-release", target, err)
+	}
+}
+
+func TestUnzipRootFsTarballRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+		want    string
+	}{
+		{
+			"parent dir",
+			[]*tar.Header{{Name: "../escaped", Typeflag: tar.TypeReg}},
+			"has .. in it",
+		},
+		{
+			"parent dir in the middle",
+			[]*tar.Header{{Name: "./etc/../../escaped", Typeflag: tar.TypeReg}},
+			"has .. in it",
+		},
+		{
+			"absolute path",
+			[]*tar.Header{{Name: "/escaped", Typeflag: tar.TypeReg}},
+			"is an absolute path",
+		},
+		{
+			"hard link to a parent dir",
+			[]*tar.Header{{Name: "./link", Typeflag: tar.TypeLink, Linkname: "../escaped"}},
+			"has .. in it",
+		},
+		{
+			"symlink parent",
+			[]*tar.Header{
+				{Name: "./etc", Typeflag: tar.TypeSymlink, Linkname: "../outside"},
+				{Name: "./etc/escaped", Typeflag: tar.TypeReg},
+			},
+			"is under a symlink",
+		},
+	}
+
+	for _, test := range tests {
+		tarball := writeTarball(t, test.headers)
+
+		// the rootfs is next to a dir that the tarball tries to write into
+		root := t.TempDir()
+		if err := os.Mkdir(filepath.Join(root, "outside"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		err := unzipRootFsTarball(filepath.Join(root, "rootfs"), tarball)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: unzipRootFsTarball() = %v, want an error that %s", test.name, err, test.want)
+		}
+
+		for _, path := range []string{"escaped", "outside/escaped", "/escaped"} {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(root, path)
+			}
+
+			if _, err := os.Lstat(path); err == nil {
+				t.Errorf("%s: %s was created outside of the rootfs", test.name, path)
+				os.Remove(path)
+			}
+		}
+	}
+}
+
+func TestRemoveRootfsLowerDirs(t *testing.T) {
+	previous := imagesDir
+	imagesDir = t.TempDir()
+	t.Cleanup(func() { imagesDir = previous })
+
+	tarball := filepath.Join(imagesDir, "alpine-minirootfs-3.18.4-x86_64.tar.gz")
+	removed := []string{"alpine-minirootfs-3.18.4-x86_64", "alpine-minirootfs-3.18.4-x86_64@100000", "alpine-minirootfs-3.18.4-x86_64@200000"}
+	kept := []string{"alpine-minirootfs-3.18.4-x86_64-extra", "ubuntu-base-22.04-base-amd64", "ubuntu-base-22.04-base-amd64@100000"}
+	for _, name := range append(removed, kept...) {
+		if err := os.MkdirAll(filepath.Join(imagesDir, name, "bin"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := removeRootfsLowerDirs(tarball); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(imagesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	if strings.Join(names, " ") != strings.Join(kept, " ") {
+		t.Errorf("images dir has %q after removeRootfsLowerDirs, want %q", names, kept)
+	}
+
+	// there's nothing to remove before the first pull
+	if err := removeRootfsLowerDirs(tarball); err != nil {
+		t.Errorf("removeRootfsLowerDirs() without lower dirs = %v", err)
+	}
+}
+
+func TestMountRootfsLayers(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting an overlay needs root")
+	}
+
+	useTempContainersDir(t)
+	previous := imagesDir
+	imagesDir = t.TempDir()
+	t.Cleanup(func() { imagesDir = previous })
+
+	// the content of a regular file is its name, so an upper layer shadows a file of a lower one
+	// with a symlink to tell them apart
+	layers := [][]*tar.Header{
+		{
+			{Name: "base/", Typeflag: tar.TypeDir},
+			{Name: "base/only-base", Typeflag: tar.TypeReg},
+			{Name: "base/shadowed", Typeflag: tar.TypeReg},
+			{Name: "base/deleted", Typeflag: tar.TypeReg},
+		},
+		{
+			{Name: "middle/", Typeflag: tar.TypeDir},
+			{Name: "middle/only-middle", Typeflag: tar.TypeReg},
+			{Name: "middle/shadowed", Typeflag: tar.TypeReg},
+			{Name: "base/", Typeflag: tar.TypeDir},
+			{Name: "base/shadowed", Typeflag: tar.TypeSymlink, Linkname: "from-middle"},
+		},
+		{
+			{Name: "top/", Typeflag: tar.TypeDir},
+			{Name: "top/only-top", Typeflag: tar.TypeReg},
+			{Name: "middle/", Typeflag: tar.TypeDir},
+			{Name: "middle/shadowed", Typeflag: tar.TypeSymlink, Linkname: "from-top"},
+			{Name: "base/", Typeflag: tar.TypeDir},
+			{Name: "base/.wh.deleted", Typeflag: tar.TypeReg},
+		},
+	}
+
+	var manifest []string
+	for i, headers := range layers {
+		name := fmt.Sprintf("layer%d.tar.gz", i+1)
+		if err := os.Rename(writeTarball(t, headers), filepath.Join(imagesDir, name)); err != nil {
+			t.Fatal(err)
+		}
+
+		manifest = append(manifest, name)
+	}
+
+	image := filepath.Join(imagesDir, "app.manifest")
+	if err := os.WriteFile(image, []byte(strings.Join(manifest, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lowerDirs, err := prepareRootfsLowers(image, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const containerId = "b-layers"
+	if err := os.Mkdir(containerDir(containerId), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	rootfsDir := containerRootfsDir(containerId)
+	if err := mountRootfs(containerId, lowerDirs); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Unmount(rootfsDir, syscall.MNT_DETACH) })
+
+	files := []struct {
+		path string
+		want string // the content, the target of a symlink, or "" if the file shouldn't exist
+	}{
+		{"base/only-base", "base/only-base"},
+		{"middle/only-middle", "middle/only-middle"},
+		{"top/only-top", "top/only-top"},
+
+		// the file of the bottom layer is replaced by the middle one, & that of the middle one by
+		// the top one
+		{"base/shadowed", "from-middle"},
+		{"middle/shadowed", "from-top"},
+
+		// the whiteout of the top layer hides it
+		{"base/deleted", ""},
+	}
+
+	for _, file := range files {
+		path := filepath.Join(rootfsDir, file.path)
+		info, err := os.Lstat(path)
+		if len(file.want) == 0 {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s exists in the rootfs (%v), want it deleted by the upper layer", file.path, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", file.path, err)
+			continue
+		}
+
+		var got string
+		if info.Mode()&os.ModeSymlink != 0 {
+			got, err = os.Readlink(path)
+		} else {
+			var content []byte
+			content, err = os.ReadFile(path)
+			got = string(content)
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", file.path, err)
+		} else if got != file.want {
+			t.Errorf("%s = %q, want %q", file.path, got, file.want)
+		}
+	}
+
+	// the shadowed file is only shadowed in the rootfs
+	if info, err := os.Lstat(filepath.Join(lowerDirs[2], "base/shadowed")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("base/shadowed of the bottom layer was changed: %v", err)
+	}
+
+	// a layer that's gone is reported as such instead of overlayfs' EINVAL
+	if err := os.RemoveAll(lowerDirs[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mountRootfs("b-missing-layer", lowerDirs); err == nil || !strings.Contains(err.Error(), lowerDirs[1]) {
+		t.Errorf("mountRootfs() with a missing layer = %v, want an error that names it", err)
+	}
+}
+
+func TestImageLayersRejectsInvalidLayers(t *testing.T) {
+	previous := imagesDir
+	imagesDir = t.TempDir()
+	t.Cleanup(func() { imagesDir = previous })
+
+	for _, line := range []string{"../base.tar.gz", "base.tar", "a:b.tar.gz", "a,upperdir=x.tar.gz"} {
+		image := filepath.Join(imagesDir, "app.manifest")
+		if err := os.WriteFile(image, []byte("base.tar.gz\n"+line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := imageLayers(image); err == nil {
+			t.Errorf("imageLayers() accepted the layer %q", line)
+		}
+	}
+}
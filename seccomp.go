@@ -0,0 +1,102 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// things of seccomp(2) that the syscall package doesn't have
+const (
+	seccompModeFilter = 2
+	seccompRetAllow   = 0x7fff0000
+	seccompRetErrno   = 0x00050000
+
+	// offsets of the fields of struct seccomp_data, which is what the filter runs on
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16 // the low 32 bits of the first argument, on little endian
+)
+
+// syscalls with this bit set are x32 syscalls on x86_64. they're blocked entirely, since they'd
+// otherwise be a way around the numbers below (no other arch has syscalls this high)
+const x32SyscallBit = 0x40000000
+
+// the new mount API, with the same numbers on every arch. without blocking these too, blocking
+// mount would still leave a way of mounting things
+var mountApiSyscalls = map[string]uint32{
+	"open_tree":     428,
+	"move_mount":    429,
+	"fsopen":        430,
+	"fsconfig":      431,
+	"fsmount":       432,
+	"fspick":        433,
+	"mount_setattr": 442,
+}
+
+// clone3 is checked separately from the others, see seccompFilter
+const clone3Syscall = 435
+
+// the flags of clone that create namespaces
+const cloneNamespaceFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC |
+	syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | cloneNewCgroup
+
+// seccompFilter returns the BPF program of the default seccomp profile. it makes the syscalls in
+// seccompBlockedSyscalls (which depends on the arch, along with their numbers) & the mount API
+// fail with EPERM, as well as clone when it creates namespaces. clone3 fails with ENOSYS instead,
+// since its flags are in a struct that the filter can't read, & libc then falls back to clone.
+// syscalls of other ABIs (like 32 bit ones) are blocked too, since their numbers differ
+func seccompFilter() []syscall.SockFilter {
+	filter := []syscall.SockFilter{
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, seccompAuditArch, 1, 0),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|int(syscall.EPERM)),
+
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, x32SyscallBit, 0, 1),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|int(syscall.EPERM)),
+
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, clone3Syscall, 0, 1),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|int(syscall.ENOSYS)),
+	}
+
+	block := func(number uint32) {
+		filter = append(filter,
+			*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(number), 0, 1),
+			*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|int(syscall.EPERM)),
+		)
+	}
+
+	for _, number := range seccompBlockedSyscalls {
+		block(number)
+	}
+
+	for _, number := range mountApiSyscalls {
+		block(number)
+	}
+
+	// this comes last because it replaces the syscall number that the checks above compare
+	return append(filter,
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.SYS_CLONE, 0, 3),
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArg0),
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, cloneNamespaceFlags, 0, 1),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|int(syscall.EPERM)),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow),
+	)
+}
+
+// installSeccompFilter installs the default seccomp profile on the calling thread, which keeps it
+// across execve. it needs either no_new_privs or CAP_SYS_ADMIN (in the thread's user namespace),
+// & every syscall that the thread makes afterwards goes through the filter, so it has to be done
+// right before executing the command
+func installSeccompFilter() error {
+	filter := seccompFilter()
+	program := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP, seccompModeFilter, uintptr(unsafe.Pointer(&program)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// the ioctls & flags of loop devices from <linux/loop.h>, which the syscall package doesn't have
+const (
+	loopSetFd       = 0x4c00
+	loopClrFd       = 0x4c01
+	loopSetStatus64 = 0x4c04
+	loopCtlGetFree  = 0x4c82
+
+	loFlagsAutoclear = 4
+)
+
+// struct loop_info64 from <linux/loop.h>
+type loopInfo64 struct {
+	device         uint64
+	inode          uint64
+	rdevice        uint64
+	offset         uint64
+	sizeLimit      uint64
+	number         uint32
+	encryptType    uint32
+	encryptKeySize uint32
+	flags          uint32
+	fileName       [64]byte
+	cryptName      [64]byte
+	encryptKey     [32]byte
+	init           [2]uint64
+}
+
+// the squashfs of --rootfs-squashfs is mounted in the container's directory, as the lower dir of
+// its overlay rootfs
+func containerSquashfsDir(containerId string) string {
+	return filepath.Join(containerDir(containerId), "squashfs")
+}
+
+// mountSquashfs mounts the squashfs image read-only at dir through a loop device. it's mounted in
+// our mount namespace, so that the child's gets a copy of it when it's created, & has to be
+// unmounted with unmountSquashfs once the container has exited. the loop device is set to clear
+// itself, so it's released along with the last mount of the image
+func mountSquashfs(image string, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	loop, err := attachLoopDevice(image)
+	if err != nil {
+		return err
+	}
+
+	// once it's mounted, the mount keeps the loop device open
+	defer loop.Close()
+
+	if err := syscall.Mount(loop.Name(), dir, "squashfs", syscall.MS_RDONLY|syscall.MS_NODEV, ""); err != nil {
+		switch err {
+		case syscall.ENODEV:
+			return fmt.Errorf("the kernel doesn't support squashfs (CONFIG_SQUASHFS), extract the image to a tarball instead")
+		case syscall.EINVAL:
+			return fmt.Errorf("%s isn't a squashfs image", image)
+		}
+
+		return fmt.Errorf("mount %s: %w", image, err)
+	}
+
+	return nil
+}
+
+// attachLoopDevice sets up a free loop device with the image as its read-only backing file &
+// returns it open
+func attachLoopDevice(image string) (*os.File, error) {
+	backingFile, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer backingFile.Close()
+
+	control, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("the kernel doesn't support loop devices, which the squashfs image is mounted through: %w", err)
+	}
+	defer control.Close()
+
+	// another process can take the free device before we do, so we try again with the next one
+	for {
+		index, _, errno := syscall.Syscall(syscall.SYS_IOCTL, control.Fd(), loopCtlGetFree, 0)
+		if errno != 0 {
+			return nil, fmt.Errorf("find a free loop device: %w", errno)
+		}
+
+		loop, err := os.OpenFile(fmt.Sprintf("/dev/loop%d", index), os.O_RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		// the backing file was opened read-only, so the loop device is read-only too
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, loop.Fd(), loopSetFd, backingFile.Fd())
+		if errno == syscall.EBUSY {
+			loop.Close()
+			continue
+		}
+
+		if errno != 0 {
+			loop.Close()
+			return nil, fmt.Errorf("set up %s: %w", loop.Name(), errno)
+		}
+
+		info := loopInfo64{flags: loFlagsAutoclear}
+		if err := ioctl(int(loop.Fd()), loopSetStatus64, unsafe.Pointer(&info)); err != nil {
+			ioctl(int(loop.Fd()), loopClrFd, nil)
+			loop.Close()
+			return nil, fmt.Errorf("set up %s: %w", loop.Name(), err)
+		}
+
+		return loop, nil
+	}
+}
+
+// unmountSquashfs unmounts the squashfs of a container that has exited, see mountSquashfs
+func unmountSquashfs(containerId string) {
+	dir := containerSquashfsDir(containerId)
+	if err := syscall.Unmount(dir, syscall.MNT_DETACH); err != nil {
+		if err != syscall.EINVAL && err != syscall.ENOENT {
+			log.Printf("failed to unmount the squashfs of container %s: %v", containerId, err)
+		}
+
+		return
+	}
+
+	debugf("unmounted the squashfs at %s", dir)
+}
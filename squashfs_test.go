@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMountSquashfsRejectsOtherImages(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("loop devices can only be set up by root")
+	}
+
+	dir := t.TempDir()
+	image := filepath.Join(dir, "rootfs.tar.gz")
+	if err := os.WriteFile(image, make([]byte, 64<<10), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := mountSquashfs(image, filepath.Join(dir, "squashfs"))
+	if err == nil || !strings.Contains(err.Error(), "isn't a squashfs image") {
+		t.Fatalf("mountSquashfs() of a tarball = %v, want an error saying that it isn't a squashfs image", err)
+	}
+
+	// the loop device clears itself once it's closed, since nothing was mounted from it
+	backingFiles, _ := filepath.Glob("/sys/block/loop*/loop/backing_file")
+	for _, path := range backingFiles {
+		if data, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(data)) == image {
+			t.Errorf("%s is still attached to %s", image, filepath.Base(filepath.Dir(filepath.Dir(path))))
+		}
+	}
+}
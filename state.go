@@ -0,0 +1,61 @@
+//go:build linux
+
+package main
+
+import "fmt"
+
+type containerState string
+
+const (
+	// the container's directory has been set up but its process hasn't started (yet)
+	stateCreated containerState = "created"
+
+	// the container's process has been set up & is running the command
+	stateRunning containerState = "running"
+
+	// the container's processes are frozen
+	statePaused containerState = "paused"
+
+	// the container has been asked to stop & we're waiting for its process to exit
+	stateStopped containerState = "stopped"
+
+	// the container's process has exited
+	stateExited containerState = "exited"
+
+	// the container's directory is being deleted
+	stateRemoving containerState = "removing"
+)
+
+// the states that a container can move to from each state
+var stateTransitions = map[containerState][]containerState{
+	// a container whose setup fails exits without ever running
+	stateCreated: {stateRunning, stateExited, stateRemoving},
+	stateRunning: {statePaused, stateStopped, stateExited},
+	// a paused or stopped container can still be killed, e.g. by the OOM killer or with kill -9
+	statePaused:  {stateRunning, stateStopped, stateExited},
+	stateStopped: {stateExited},
+	// a container that is restarted starts over from created, with a new config
+	stateExited:   {stateRemoving},
+	stateRemoving: {},
+}
+
+func canTransition(from containerState, to containerState) bool {
+	for _, state := range stateTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setContainerState moves the container to a new state & persists it in its config.json. it
+// refuses to do transitions that aren't in the table above, like pausing an exited container
+func setContainerState(config *containerConfig, state containerState) error {
+	if !canTransition(config.State, state) {
+		return fmt.Errorf("container %s cannot go from %s to %s", config.Id, config.State, state)
+	}
+
+	config.State = state
+	return writeContainerConfig(config)
+}
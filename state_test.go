@@ -0,0 +1,90 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from containerState
+		to   containerState
+		want bool
+	}{
+		{stateCreated, stateRunning, true},
+		{stateCreated, stateExited, true},
+		{stateCreated, stateRemoving, true},
+		{stateRunning, statePaused, true},
+		{stateRunning, stateStopped, true},
+		{stateRunning, stateExited, true},
+		{statePaused, stateRunning, true},
+		{statePaused, stateStopped, true},
+		{statePaused, stateExited, true},
+		{stateStopped, stateExited, true},
+		{stateExited, stateRemoving, true},
+
+		{stateCreated, statePaused, false},
+		{stateCreated, stateStopped, false},
+		{stateRunning, stateCreated, false},
+		{stateRunning, stateRemoving, false},
+		{statePaused, stateRemoving, false},
+		{stateStopped, stateRunning, false},
+		{stateStopped, statePaused, false},
+		{stateExited, stateRunning, false},
+		{stateExited, statePaused, false},
+		{stateExited, stateStopped, false},
+		{stateRemoving, stateCreated, false},
+		{stateRemoving, stateRunning, false},
+		{stateRemoving, stateExited, false},
+		{"", stateRunning, false},
+		{stateRunning, "", false},
+	}
+
+	for _, test := range tests {
+		if got := canTransition(test.from, test.to); got != test.want {
+			t.Errorf("canTransition(%q, %q) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestCanTransitionSameState(t *testing.T) {
+	for state := range stateTransitions {
+		if canTransition(state, state) {
+			t.Errorf("canTransition(%q, %q) = true, want false", state, state)
+		}
+	}
+}
+
+func TestSetContainerStateRejectsIllegal(t *testing.T) {
+	config := &containerConfig{Id: "test", State: stateExited}
+	if err := setContainerState(config, statePaused); err == nil {
+		t.Fatal("setContainerState(exited -> paused) succeeded, want an error")
+	}
+
+	if config.State != stateExited {
+		t.Errorf("state = %q after a rejected transition, want %q", config.State, stateExited)
+	}
+}
+
+func TestRefreshContainerState(t *testing.T) {
+	useTempContainersDir(t)
+
+	for _, state := range []containerState{stateRunning, statePaused, stateStopped} {
+		// the process of the container & focker run are both gone
+		config := &containerConfig{Id: "r-" + string(state), State: state, Pid: 1 << 30}
+		writeTestContainer(t, config)
+
+		refreshContainerState(config)
+		if config.State != stateExited {
+			t.Errorf("%s: state = %q, want %q", state, config.State, stateExited)
+		}
+
+		saved, err := readContainerConfig(config.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if saved.State != stateExited {
+			t.Errorf("%s: saved state = %q, want %q", state, saved.State, stateExited)
+		}
+	}
+}
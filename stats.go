@@ -0,0 +1,199 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// how often stats --stream refreshes, which is also how long the cpu usage is measured over
+const statsInterval = time.Second
+
+// the resource usage of a container at some point in time, from the files of its cgroup. a value
+// is -1 if the controller of its file isn't enabled for the cgroup
+type containerStats struct {
+	time time.Time
+
+	// the total cpu time that the container has used, in microseconds
+	cpuUsec int64
+
+	// memory usage & the hard limit in bytes. the limit is the host's memory if it has none
+	memory      int64
+	memoryLimit int64
+
+	pids int64
+}
+
+// stats prints the resource usage of a running container & returns the exit code that focker
+// should exit with. args are the container id, optionally preceded by --stream to keep printing
+// it every second until the container exits
+func stats(args []string) int {
+	stream := false
+	if len(args) > 0 && args[0] == "--stream" {
+		stream = true
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		log.Print("usage: focker stats [--stream] <containerId>")
+		return 1
+	}
+
+	containerId, err := resolveContainerId(args[0])
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	config, err := readContainerConfig(containerId)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	if !isContainerRunning(config) {
+		log.Printf("stats: container %s is not running", containerId)
+		return 1
+	}
+
+	if _, err := os.Stat(containerCgroupDir(containerId)); err != nil {
+		log.Printf("stats: container %s has no cgroup, it's only created for containers with resource limits", containerId)
+		return 1
+	}
+
+	// the cpu usage is a percentage of the time between two samples
+	previous, err := readContainerStats(containerId)
+	if err != nil {
+		log.Printf("stats: %v", err)
+		return 1
+	}
+
+	// with --stream on a terminal, the stats are redrawn in place
+	redraw := stream && isTerminal(os.Stdout)
+	for {
+		time.Sleep(statsInterval)
+
+		current, err := readContainerStats(containerId)
+		if err != nil {
+			// the cgroup is removed once the container exits
+			if config, configErr := readContainerConfig(containerId); stream && configErr == nil && !isContainerRunning(config) {
+				return 0
+			}
+
+			log.Printf("stats: %v", err)
+			return 1
+		}
+
+		if redraw {
+			fmt.Print("\033[H\033[2J")
+		}
+
+		printContainerStats(containerId, previous, current)
+		if !stream {
+			return 0
+		}
+
+		previous = current
+	}
+}
+
+// readContainerStats reads the current resource usage of a container from its cgroup
+func readContainerStats(containerId string) (*containerStats, error) {
+	cgroupDir := containerCgroupDir(containerId)
+	stats := &containerStats{time: time.Now(), cpuUsec: -1, memory: -1, memoryLimit: -1, pids: -1}
+
+	// cpu.stat is there even without the cpu controller
+	data, err := os.ReadFile(filepath.Join(cgroupDir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// usage_usec 1234567
+		if value, ok := strings.CutPrefix(line, "usage_usec "); ok {
+			stats.cpuUsec, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	stats.memory = readCgroupCounter(cgroupDir, "memory.current")
+	stats.pids = readCgroupCounter(cgroupDir, "pids.current")
+
+	if stats.memory >= 0 {
+		stats.memoryLimit = readCgroupCounter(cgroupDir, "memory.max")
+		if stats.memoryLimit < 0 {
+			if hostMemory, err := readHostMemory(); err == nil {
+				stats.memoryLimit = int64(hostMemory)
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// readCgroupCounter reads a cgroup file with a single number, or returns -1 if it doesn't exist
+// or has no number (like "max")
+func readCgroupCounter(cgroupDir string, file string) int64 {
+	data, err := os.ReadFile(filepath.Join(cgroupDir, file))
+	if err != nil {
+		return -1
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return value
+}
+
+// printContainerStats prints the resource usage of a container as a table like docker stats does.
+// the cpu usage is in percent of one cpu over the time between previous & current
+func printContainerStats(containerId string, previous *containerStats, current *containerStats) {
+	cpu, memory, memoryPercent, pids := "-", "-", "-", "-"
+
+	elapsedUsec := current.time.Sub(previous.time).Microseconds()
+	if current.cpuUsec >= 0 && previous.cpuUsec >= 0 && elapsedUsec > 0 {
+		cpu = fmt.Sprintf("%.2f%%", float64(current.cpuUsec-previous.cpuUsec)*100/float64(elapsedUsec))
+	}
+
+	if current.memory >= 0 {
+		memory = formatBytes(current.memory)
+		if current.memoryLimit > 0 {
+			memory += " / " + formatBytes(current.memoryLimit)
+			memoryPercent = fmt.Sprintf("%.2f%%", float64(current.memory)*100/float64(current.memoryLimit))
+		}
+	}
+
+	if current.pids >= 0 {
+		pids = strconv.FormatInt(current.pids, 10)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tCPU %\tMEM USAGE / LIMIT\tMEM %\tPIDS")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", containerId, cpu, memory, memoryPercent, pids)
+	w.Flush()
+}
+
+// formatBytes formats a size in bytes with a binary unit, e.g. 1.5MiB
+func formatBytes(size int64) string {
+	const units = "KMGTPE"
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	value := float64(size)
+	unit := -1
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.2f%ciB", value, units[unit])
+}
@@ -0,0 +1,200 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+// the signals that the container's init passes on to the command
+var forwardedSignals = []os.Signal{
+	syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2,
+}
+
+// forwardSignals passes the signals that we get on to process until the returned function is
+// called, or with group, to its process group (which process must lead), e.g. so that the children
+// of an entrypoint script get them too (--kill-process-group). the _child process is the init of
+// the container, so signals sent to the container (e.g. by focker stop) end up with it rather than
+// with the command. the signals that skip (if not nil) returns true for are only caught, so that
+// they don't kill us
+func forwardSignals(process *os.Process, group bool, skip func(os.Signal) bool) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if skip != nil && skip(sig) {
+					continue
+				}
+
+				if group {
+					syscall.Kill(-process.Pid, sig.(syscall.Signal))
+				} else {
+					process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// sentByTerminal reports whether sig may come from the terminal, i.e. Ctrl-C or Ctrl-\ while we're
+// in the foreground. the terminal sends those to its whole foreground process group, which the
+// container is in too, so passing them on would make the container get them twice
+func sentByTerminal(sig os.Signal) bool {
+	if sig != syscall.SIGINT && sig != syscall.SIGQUIT {
+		return false
+	}
+
+	var foreground int32
+	if err := ioctl(int(os.Stdin.Fd()), syscall.TIOCGPGRP, unsafe.Pointer(&foreground)); err != nil {
+		return false
+	}
+
+	return int(foreground) == syscall.Getpgrp()
+}
+
+// stop stops containers & returns the exit code that focker should exit with. args are an
+// optional -t=<seconds> & the ids of the containers
+func stop(args []string) int {
+	timeout := defaultStopTimeout
+	if len(args) > 0 && strings.HasPrefix(args[0], "-t=") {
+		seconds, err := strconv.Atoi(strings.TrimPrefix(args[0], "-t="))
+		if err != nil || seconds < 0 {
+			log.Fatalf("stop: invalid -t value %q (expected a number of seconds)", strings.TrimPrefix(args[0], "-t="))
+		}
+
+		timeout = time.Duration(seconds) * time.Second
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		log.Fatal("usage: focker stop [-t=<seconds>] <containerId>...")
+	}
+
+	exitCode := 0
+	for _, ref := range args {
+		containerId, err := resolveContainerId(ref)
+		if err == nil {
+			err = stopContainer(containerId, timeout)
+		}
+
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			continue
+		}
+
+		fmt.Println(ref)
+	}
+
+	return exitCode
+}
+
+// stopContainer sends SIGTERM to the container & SIGKILL if it's still running after timeout. the
+// kernel kills every process of the container once its init exits
+func stopContainer(containerId string, timeout time.Duration) error {
+	config, err := readContainerConfig(containerId)
+	if err != nil {
+		return err
+	}
+
+	if !isContainerRunning(config) {
+		// it's already gone, maybe without focker getting to record it. if it's about to be
+		// restarted, it isn't anymore
+		refreshContainerState(config)
+		if len(config.RestartPolicy) > 0 && !config.ManuallyStopped {
+			config.ManuallyStopped = true
+			return writeContainerConfig(config)
+		}
+
+		return nil
+	}
+
+	if config.State != stateStopped {
+		config.ManuallyStopped = true
+		if err := setContainerState(config, stateStopped); err != nil {
+			return err
+		}
+	}
+
+	if err := syscall.Kill(config.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to send SIGTERM to container %s: %w", containerId, err)
+	}
+
+	if !waitForContainerExit(config, timeout) {
+		infof("container %s didn't exit within %v, sending SIGKILL", containerId, timeout)
+		if err := syscall.Kill(config.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to send SIGKILL to container %s: %w", containerId, err)
+		}
+
+		waitForContainerExit(config, time.Minute)
+	}
+
+	// focker run records the exit & cleans up after the container, so we wait for it to be done.
+	// it's only done here if focker run is gone (e.g. it has been killed), since we'd otherwise
+	// both unmount the same things & write the config at the same time
+	deadline := time.Now().Add(time.Minute)
+	for {
+		config, err = readContainerConfig(containerId)
+		if err != nil {
+			return err
+		}
+
+		if config.State != stateStopped {
+			return nil
+		}
+
+		if !isParentRunning(config) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			infof("focker run of container %s hasn't recorded its exit yet", containerId)
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := setContainerState(config, stateExited); err != nil {
+		return err
+	}
+
+	cleanupContainerMounts(containerId)
+	return nil
+}
+
+// waitForContainerExit waits for the container's init to exit & tells whether it did within
+// timeout
+func waitForContainerExit(config *containerConfig, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for isContainerRunning(config) {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return true
+}
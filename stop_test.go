@@ -0,0 +1,69 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestForwardSignalsToProcessGroup(t *testing.T) {
+	tests := []struct {
+		name  string
+		group bool
+
+		// whether the child of the entrypoint script gets the signal
+		want bool
+	}{
+		{name: "process", group: false, want: false},
+		{name: "group", group: true, want: true},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		marker := filepath.Join(dir, "terminated")
+
+		// an entrypoint script that runs the real command as a child instead of exec'ing it, so
+		// the signals that only go to the script kill it without reaching the command
+		entrypoint := filepath.Join(dir, "entrypoint.sh")
+		script := "#!/bin/sh\nsh -c 'trap \"touch " + marker + "; exit 0\" TERM; while :; do sleep 0.1; done' &\nwait\n"
+		if err := os.WriteFile(entrypoint, []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		// the entrypoint leads its process group, like the command of a container does with
+		// --kill-process-group
+		cmd := exec.Command(entrypoint)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+
+		// give the child time to set its trap up
+		time.Sleep(300 * time.Millisecond)
+
+		// the signal that focker stop sends to the container's init, i.e. to us here
+		stopForwarding := forwardSignals(cmd.Process, test.group, nil)
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		cmd.Wait()
+		stopForwarding()
+
+		// the child may still be running its trap
+		deadline := time.Now().Add(time.Second)
+		_, err := os.Stat(marker)
+		for err != nil && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			_, err = os.Stat(marker)
+		}
+
+		if got := err == nil; got != test.want {
+			t.Errorf("%s: the child of the entrypoint got SIGTERM: %v, want %v", test.name, got, test.want)
+		}
+	}
+}
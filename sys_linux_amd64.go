@@ -0,0 +1,45 @@
+package main
+
+// syscall numbers that the syscall package doesn't define for this architecture
+const sysSetns = 308
+
+// AUDIT_ARCH_X86_64, the arch that the seccomp filter expects syscalls to be made with
+const seccompAuditArch = 0xc000003e
+
+// the syscalls that the default seccomp profile blocks, with their numbers on x86_64. they change
+// the kernel or the machine (modules, kexec, reboot, swap, the clock), mount things, create or join
+// namespaces, or expose a lot of the kernel to an attacker (bpf, perf, userfaultfd, keyrings)
+var seccompBlockedSyscalls = map[string]uint32{
+	"mount":             165,
+	"umount2":           166,
+	"pivot_root":        155,
+	"swapon":            167,
+	"swapoff":           168,
+	"reboot":            169,
+	"kexec_load":        246,
+	"kexec_file_load":   320,
+	"init_module":       175,
+	"finit_module":      313,
+	"delete_module":     176,
+	"create_module":     174,
+	"iopl":              172,
+	"ioperm":            173,
+	"acct":              163,
+	"settimeofday":      164,
+	"clock_settime":     227,
+	"adjtimex":          159,
+	"clock_adjtime":     305,
+	"syslog":            103,
+	"quotactl":          179,
+	"lookup_dcookie":    212,
+	"vhangup":           153,
+	"open_by_handle_at": 304,
+	"bpf":               321,
+	"perf_event_open":   298,
+	"userfaultfd":       323,
+	"add_key":           248,
+	"request_key":       249,
+	"keyctl":            250,
+	"unshare":           272,
+	"setns":             308,
+}
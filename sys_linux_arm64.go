@@ -0,0 +1,41 @@
+package main
+
+// syscall numbers that the syscall package doesn't define for this architecture
+const sysSetns = 268
+
+// AUDIT_ARCH_AARCH64, the arch that the seccomp filter expects syscalls to be made with
+const seccompAuditArch = 0xc00000b7
+
+// the syscalls that the default seccomp profile blocks, with their numbers on arm64. see
+// sys_linux_amd64.go for why
+var seccompBlockedSyscalls = map[string]uint32{
+	"mount":             40,
+	"umount2":           39,
+	"pivot_root":        41,
+	"swapon":            224,
+	"swapoff":           225,
+	"reboot":            142,
+	"kexec_load":        104,
+	"kexec_file_load":   294,
+	"init_module":       105,
+	"finit_module":      273,
+	"delete_module":     106,
+	"acct":              89,
+	"settimeofday":      170,
+	"clock_settime":     112,
+	"adjtimex":          171,
+	"clock_adjtime":     266,
+	"syslog":            116,
+	"quotactl":          60,
+	"lookup_dcookie":    18,
+	"vhangup":           58,
+	"open_by_handle_at": 265,
+	"bpf":               280,
+	"perf_event_open":   241,
+	"userfaultfd":       282,
+	"add_key":           217,
+	"request_key":       218,
+	"keyctl":            219,
+	"unshare":           97,
+	"setns":             268,
+}
@@ -0,0 +1,275 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// user hz, which is 100 on pretty much every linux system. /proc/<pid>/stat reports cpu times in it
+const clockTicksPerSecond = 100
+
+const defaultTopFormat = "user,pid,hostpid,ppid,stat,vsz,rss,time,args"
+
+// a process inside a container, as read from the host's /proc
+type containerProcess struct {
+	hostPid int
+	pid     int // pid inside the container's pid namespace
+	ppid    int // parent's pid inside the container's pid namespace
+	uid     int
+	state   string
+	vszKiB  uint64
+	rssKiB  uint64
+	cpuTime uint64 // in clock ticks
+	comm    string
+	args    string
+}
+
+type topColumn struct {
+	header string
+	value  func(p *containerProcess, users map[int]string) string
+}
+
+var topColumns = map[string]topColumn{
+	"user": {"USER", func(p *containerProcess, users map[int]string) string {
+		if name, ok := users[p.uid]; ok {
+			return name
+		}
+
+		return strconv.Itoa(p.uid)
+	}},
+	"uid":     {"UID", func(p *containerProcess, _ map[int]string) string { return strconv.Itoa(p.uid) }},
+	"pid":     {"PID", func(p *containerProcess, _ map[int]string) string { return strconv.Itoa(p.pid) }},
+	"hostpid": {"HOSTPID", func(p *containerProcess, _ map[int]string) string { return strconv.Itoa(p.hostPid) }},
+	"ppid":    {"PPID", func(p *containerProcess, _ map[int]string) string { return strconv.Itoa(p.ppid) }},
+	"stat":    {"STAT", func(p *containerProcess, _ map[int]string) string { return p.state }},
+	"vsz":     {"VSZ", func(p *containerProcess, _ map[int]string) string { return strconv.FormatUint(p.vszKiB, 10) }},
+	"rss":     {"RSS", func(p *containerProcess, _ map[int]string) string { return strconv.FormatUint(p.rssKiB, 10) }},
+	"time": {"TIME", func(p *containerProcess, _ map[int]string) string {
+		seconds := p.cpuTime / clockTicksPerSecond
+		return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}},
+	"comm": {"COMMAND", func(p *containerProcess, _ map[int]string) string { return p.comm }},
+	"args": {"COMMAND", func(p *containerProcess, _ map[int]string) string { return p.args }},
+}
+
+// top lists the processes running inside a container. like ps, it accepts a format with -o
+// (or -eo, since all of the container's processes are always listed anyway)
+func top(containerId string, options []string) {
+	format := defaultTopFormat
+	for i := 0; i < len(options); i++ {
+		switch options[i] {
+		case "-e":
+			// all processes are listed by default
+		case "-o", "-eo":
+			if i+1 >= len(options) {
+				log.Fatalf("top: %s requires a format", options[i])
+			}
+
+			i++
+			format = options[i]
+		default:
+			log.Fatalf("top: unknown option %s", options[i])
+		}
+	}
+
+	var columns []topColumn
+	for _, name := range strings.Split(format, ",") {
+		column, ok := topColumns[name]
+		if !ok {
+			log.Fatalf("top: unknown format specifier %q", name)
+		}
+
+		columns = append(columns, column)
+	}
+
+	containerId, err := resolveContainerId(containerId)
+	exitIfError(err, "top")
+
+	config, err := readContainerConfig(containerId)
+	exitIfError(err, "top")
+	if !isContainerRunning(config) {
+		log.Fatalf("top: container %s is not running", containerId)
+	}
+
+	processes, err := listContainerProcesses(config.Pid)
+	exitIfError(err, "top")
+
+	// the rootfs is only mounted in the container's mount namespace, so go through its init
+	users := readPasswdUsers(fmt.Sprintf("/proc/%d/root/etc/passwd", config.Pid))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = column.header
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+	for _, p := range processes {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = column.value(p, users)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+}
+
+// listContainerProcesses reads the host's /proc & returns the process tree rooted at initPid
+// (the container's init process) with their pids translated to the container's pid namespace
+func listContainerProcesses(initPid int) ([]*containerProcess, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	children := map[int][]int{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		// the process may exit while we're reading /proc, so just skip it
+		p, err := readContainerProcess(pid)
+		if err != nil {
+			continue
+		}
+
+		// at this point ppid is still the host pid, it gets translated below
+		children[p.ppid] = append(children[p.ppid], pid)
+	}
+
+	var processes []*containerProcess
+	hostToNsPid := map[int]int{}
+
+	queue := []int{initPid}
+	for len(queue) > 0 {
+		hostPid := queue[0]
+		queue = queue[1:]
+
+		p, err := readContainerProcess(hostPid)
+		if err != nil {
+			continue
+		}
+
+		nsPids, err := readNsPids(hostPid)
+		if err != nil {
+			continue
+		}
+
+		p.pid = nsPids[len(nsPids)-1]
+		hostToNsPid[hostPid] = p.pid
+
+		processes = append(processes, p)
+		queue = append(queue, children[hostPid]...)
+	}
+
+	for _, p := range processes {
+		// the init's parent is outside the container, so it's 0 just like in ps
+		p.ppid = hostToNsPid[p.ppid]
+	}
+
+	return processes, nil
+}
+
+func readContainerProcess(hostPid int) (*containerProcess, error) {
+	procDir := fmt.Sprintf("/proc/%d", hostPid)
+
+	stat, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// comm is in parentheses & may itself contain spaces & parentheses, so we split on the
+	// last closing parenthesis. see proc(5) for the fields
+	statStr := string(stat)
+	commStart := strings.IndexByte(statStr, '(')
+	commEnd := strings.LastIndexByte(statStr, ')')
+	if commStart < 0 || commEnd < commStart {
+		return nil, fmt.Errorf("bad %s/stat", procDir)
+	}
+
+	fields := strings.Fields(statStr[commEnd+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("bad %s/stat", procDir)
+	}
+
+	p := &containerProcess{
+		hostPid: hostPid,
+		comm:    statStr[commStart+1 : commEnd],
+		state:   fields[0],
+	}
+
+	p.ppid, _ = strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	p.cpuTime = utime + stime
+
+	vsz, _ := strconv.ParseUint(fields[20], 10, 64)
+	p.vszKiB = vsz / 1024
+	rssPages, _ := strconv.ParseUint(fields[21], 10, 64)
+	p.rssKiB = rssPages * uint64(os.Getpagesize()) / 1024
+
+	cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+
+	// kernel threads & zombies have an empty cmdline
+	p.args = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	if len(p.args) == 0 {
+		p.args = "[" + p.comm + "]"
+	}
+
+	status, err := os.ReadFile(filepath.Join(procDir, "status"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "Uid:" {
+			// the uid is the one on the host, which is shifted with user namespaces
+			uid, _ := strconv.Atoi(fields[1])
+			p.uid = translateUid(hostPid, uid)
+			break
+		}
+	}
+
+	return p, nil
+}
+
+// readPasswdUsers maps uids to user names from a passwd file. it's best effort, so we just
+// return whatever we could read
+func readPasswdUsers(passwdPath string) map[int]string {
+	users := map[int]string{}
+
+	file, err := os.Open(passwdPath)
+	if err != nil {
+		return users
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+
+		if uid, err := strconv.Atoi(fields[2]); err == nil {
+			users[uid] = fields[0]
+		}
+	}
+
+	return users
+}
@@ -0,0 +1,147 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// the terminal of a container that's run with -t. the command gets the slave side as its stdio &
+// controlling terminal, & we pass the master side on to & from our own stdio
+type containerTty struct {
+	master *os.File
+	slave  *os.File
+
+	// closed once all of the command's output has been copied
+	outputDone chan struct{}
+
+	// the terminal state of our stdin from before it was put in raw mode, nil if it wasn't
+	savedState *syscall.Termios
+
+	stopResizing func()
+}
+
+// newContainerTty allocates a pseudo-terminal, which lives in the host's devpts. the container
+// doesn't need to see it in its /dev since the command inherits it as an open fd
+func newContainerTty() (*containerTty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var unlock int32
+	if err := ioctl(int(master.Fd()), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unlock pty: %w", err)
+	}
+
+	var number uint32
+	if err := ioctl(int(master.Fd()), syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("get pty number: %w", err)
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	return &containerTty{master: master, slave: slave, outputDone: make(chan struct{})}, nil
+}
+
+// start passes the command's output on to output & our stdin on to the command if input is true.
+// it must be called once the container has been started
+func (tty *containerTty) start(output io.Writer, input bool) {
+	// only the container keeps the slave side open, so we get EIO once it's gone
+	tty.slave.Close()
+
+	go func() {
+		io.Copy(output, tty.master)
+		close(tty.outputDone)
+	}()
+
+	if input {
+		go io.Copy(tty.master, os.Stdin)
+	}
+}
+
+// makeRaw puts our stdin in raw mode if it's a terminal, so that everything that's typed
+// (including Ctrl-C) goes to the container's terminal as is, & makes the container's terminal
+// follow its size
+func (tty *containerTty) makeRaw() error {
+	stdin := int(os.Stdin.Fd())
+	var state syscall.Termios
+	if ioctl(stdin, syscall.TCGETS, unsafe.Pointer(&state)) != nil {
+		return nil
+	}
+
+	raw := state
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(stdin, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return fmt.Errorf("put the terminal in raw mode: %w", err)
+	}
+
+	tty.savedState = &state
+
+	tty.resize()
+	resizes := make(chan os.Signal, 1)
+	signal.Notify(resizes, syscall.SIGWINCH)
+	go func() {
+		for range resizes {
+			tty.resize()
+		}
+	}()
+
+	tty.stopResizing = func() { signal.Stop(resizes) }
+	return nil
+}
+
+// resize gives the container's terminal the size of ours
+func (tty *containerTty) resize() {
+	var size struct{ rows, cols, xpixel, ypixel uint16 }
+	if ioctl(int(os.Stdin.Fd()), syscall.TIOCGWINSZ, unsafe.Pointer(&size)) == nil {
+		ioctl(int(tty.master.Fd()), syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+	}
+}
+
+// stop waits for the rest of the command's output & restores our terminal. it must be called
+// once the container has exited
+func (tty *containerTty) stop() {
+	<-tty.outputDone
+	tty.master.Close()
+	if tty.savedState != nil {
+		tty.stopResizing()
+		ioctl(int(os.Stdin.Fd()), syscall.TCSETS, unsafe.Pointer(tty.savedState))
+	}
+}
+
+// mountConsole bind mounts the container's terminal (our stdin) at /dev/console of the minimal
+// /dev, like docker does. it's in the host's devpts, so it has no name in the container otherwise
+// & e.g. tty can't tell what it is. it must be called before pivot_root
+func mountConsole(rootfsDir string) error {
+	// the fd itself was opened in the parent's mount namespace, which can't be bind mounted from
+	terminal, err := os.Readlink("/proc/self/fd/0")
+	if err != nil {
+		return err
+	}
+
+	console := filepath.Join(rootfsDir, "dev", "console")
+	if err := os.WriteFile(console, nil, 0620); err != nil {
+		return err
+	}
+
+	return syscall.Mount(terminal, console, "", syscall.MS_BIND, "")
+}
@@ -0,0 +1,131 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RLIMIT_NPROC, which the syscall package doesn't define. it's the same on every arch we support
+const rlimitNproc = 6
+
+// the resource limits that --ulimit can set, by name
+var ulimitResources = map[string]int{
+	// the max number of open files
+	"nofile": syscall.RLIMIT_NOFILE,
+	// the max number of processes of the command's user, across the host
+	"nproc": rlimitNproc,
+	// the max size of a file that the command can write, in bytes
+	"fsize": syscall.RLIMIT_FSIZE,
+}
+
+// a resource limit of the command from --ulimit, in the format of --ulimit
+type ulimit struct {
+	name string
+	soft uint64
+	hard uint64
+}
+
+// parseUlimit parses the value of --ulimit, i.e. <name>=<soft>[:<hard>], where the limits are
+// numbers or "unlimited" & the hard limit is the soft one if it isn't given
+func parseUlimit(spec string) (ulimit, error) {
+	name, values, ok := strings.Cut(spec, "=")
+	if !ok {
+		return ulimit{}, fmt.Errorf("invalid ulimit %q, expected <name>=<soft>[:<hard>]", spec)
+	}
+
+	if _, ok := ulimitResources[name]; !ok {
+		return ulimit{}, fmt.Errorf("unknown ulimit %s, expected nofile, nproc or fsize", name)
+	}
+
+	softValue, hardValue, hasHard := strings.Cut(values, ":")
+	if !hasHard {
+		hardValue = softValue
+	}
+
+	limit := ulimit{name: name}
+	var err error
+	if limit.soft, err = parseUlimitValue(softValue); err != nil {
+		return ulimit{}, fmt.Errorf("ulimit %s: %w", name, err)
+	}
+
+	if limit.hard, err = parseUlimitValue(hardValue); err != nil {
+		return ulimit{}, fmt.Errorf("ulimit %s: %w", name, err)
+	}
+
+	if limit.soft > limit.hard {
+		return ulimit{}, fmt.Errorf("ulimit %s: the soft limit %s is more than the hard limit %s", name, softValue, hardValue)
+	}
+
+	return limit, nil
+}
+
+// parseUlimitValue parses a limit of --ulimit, which is a number or "unlimited"
+func parseUlimitValue(value string) (uint64, error) {
+	if value == "unlimited" {
+		return math.MaxUint64, nil
+	}
+
+	limit, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid limit %q, expected a number or unlimited", value)
+	}
+
+	return limit, nil
+}
+
+// String formats the limit for --ulimit, e.g. for the child
+func (limit ulimit) String() string {
+	format := func(value uint64) string {
+		if value == math.MaxUint64 {
+			return "unlimited"
+		}
+
+		return strconv.FormatUint(value, 10)
+	}
+
+	return fmt.Sprintf("%s=%s:%s", limit.name, format(limit.soft), format(limit.hard))
+}
+
+// raiseUlimits raises our hard limits to those of ulimits where they're higher, keeping our soft
+// limits. only root of the host's user namespace can raise a hard limit, so the child does this
+// for the helper, which sets the limits themselves with applyUlimits
+func raiseUlimits(ulimits []ulimit) error {
+	for _, limit := range ulimits {
+		resource := ulimitResources[limit.name]
+
+		var rlimit syscall.Rlimit
+		if err := syscall.Getrlimit(resource, &rlimit); err != nil {
+			return fmt.Errorf("get ulimit %s: %w", limit.name, err)
+		}
+
+		if limit.hard <= rlimit.Max {
+			continue
+		}
+
+		rlimit.Max = limit.hard
+		if err := syscall.Setrlimit(resource, &rlimit); err != nil {
+			return fmt.Errorf("raise the hard limit of %s: %w", limit, err)
+		}
+	}
+
+	return nil
+}
+
+// applyUlimits sets the resource limits of the process, which the command keeps. the later of
+// two limits with the same name wins. the limits are for the whole process, so nproc has to be
+// set once we don't need any more threads, since they count as processes
+func applyUlimits(ulimits []ulimit) error {
+	for _, limit := range ulimits {
+		rlimit := syscall.Rlimit{Cur: limit.soft, Max: limit.hard}
+		if err := syscall.Setrlimit(ulimitResources[limit.name], &rlimit); err != nil {
+			return fmt.Errorf("set ulimit %s: %w", limit, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// the user that the command runs as, from -u
+type containerUser struct {
+	uid    int
+	gid    int
+	groups []int
+
+	// the home directory from /etc/passwd, or / for a uid that isn't in it
+	home string
+}
+
+// lookupContainerUser resolves the value of -u, i.e. <user>[:<group>] where both are names or
+// ids, using the /etc/passwd & /etc/group of the container whose root is root, i.e. "/" after
+// pivot_root or /proc/<pid>/root from the host (see resolveInRoot). like docker, a uid that isn't
+// in /etc/passwd is fine (with gid 0 unless a group is given), but a name has to be, & the user
+// also gets the supplementary groups that list it in /etc/group
+func lookupContainerUser(root string, spec string) (containerUser, error) {
+	userName, groupName, hasGroup := strings.Cut(spec, ":")
+
+	passwdPath, err := resolveInRoot(root, "/etc/passwd")
+	if err != nil {
+		return containerUser{}, err
+	}
+
+	groupPath, err := resolveInRoot(root, "/etc/group")
+	if err != nil {
+		return containerUser{}, err
+	}
+
+	user := containerUser{home: "/"}
+	var entry []string
+	if uid, err := strconv.Atoi(userName); err == nil {
+		user.uid = uid
+		entry, _ = findDbEntry(passwdPath, 2, userName)
+	} else {
+		entry, err = findDbEntry(passwdPath, 0, userName)
+		if err != nil {
+			return containerUser{}, err
+		}
+
+		if entry == nil {
+			return containerUser{}, fmt.Errorf("no user %s in /etc/passwd of the container", userName)
+		}
+	}
+
+	if entry != nil {
+		user.uid, _ = strconv.Atoi(entry[2])
+		user.gid, _ = strconv.Atoi(entry[3])
+		user.home = entry[5]
+
+		groups, err := findSupplementaryGroups(groupPath, entry[0])
+		if err != nil {
+			return containerUser{}, err
+		}
+
+		user.groups = groups
+	}
+
+	if hasGroup {
+		if gid, err := strconv.Atoi(groupName); err == nil {
+			user.gid = gid
+		} else {
+			group, err := findDbEntry(groupPath, 0, groupName)
+			if err != nil {
+				return containerUser{}, err
+			}
+
+			if group == nil {
+				return containerUser{}, fmt.Errorf("no group %s in /etc/group of the container", groupName)
+			}
+
+			user.gid, _ = strconv.Atoi(group[2])
+		}
+	}
+
+	return user, nil
+}
+
+// findDbEntry returns the fields of the first line of a colon separated file like /etc/passwd
+// whose field at index is value, or nil if there's none. a missing file has no entries
+func findDbEntry(path string, index int, value string) ([]string, error) {
+	var found []string
+	err := scanDbFile(path, func(fields []string) bool {
+		if len(fields) > index && fields[index] == value {
+			found = fields
+			return false
+		}
+
+		return true
+	})
+
+	return found, err
+}
+
+// findSupplementaryGroups returns the gids of the groups in groupPath (an /etc/group) that list
+// userName as a member
+func findSupplementaryGroups(groupPath string, userName string) ([]int, error) {
+	var groups []int
+	err := scanDbFile(groupPath, func(fields []string) bool {
+		if len(fields) < 4 {
+			return true
+		}
+
+		for _, member := range strings.Split(fields[3], ",") {
+			if member == userName {
+				if gid, err := strconv.Atoi(fields[2]); err == nil {
+					groups = append(groups, gid)
+				}
+
+				break
+			}
+		}
+
+		return true
+	})
+
+	return groups, err
+}
+
+// scanDbFile calls f with the fields of each line of a colon separated file like /etc/passwd,
+// until f returns false. comments & lines with less than 4 fields are skipped
+func scanDbFile(path string, f func(fields []string) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) >= 4 && !f(fields) {
+			break
+		}
+	}
+
+	return scanner.Err()
+}
+
+// String formats the user for the --user flag of the _exec helper, i.e. <uid>:<gid>[:<gid>,...]
+func (user containerUser) String() string {
+	spec := fmt.Sprintf("%d:%d", user.uid, user.gid)
+	if len(user.groups) > 0 {
+		var groups []string
+		for _, gid := range user.groups {
+			groups = append(groups, strconv.Itoa(gid))
+		}
+
+		spec += ":" + strings.Join(groups, ",")
+	}
+
+	return spec
+}
+
+// parseHelperUser parses the --user flag of the _exec helper, see String
+func parseHelperUser(spec string) (containerUser, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return containerUser{}, fmt.Errorf("invalid user %q", spec)
+	}
+
+	var user containerUser
+	var err error
+	if user.uid, err = strconv.Atoi(parts[0]); err != nil {
+		return containerUser{}, fmt.Errorf("invalid uid %q", parts[0])
+	}
+
+	if user.gid, err = strconv.Atoi(parts[1]); err != nil {
+		return containerUser{}, fmt.Errorf("invalid gid %q", parts[1])
+	}
+
+	if len(parts) == 3 {
+		for _, group := range strings.Split(parts[2], ",") {
+			gid, err := strconv.Atoi(group)
+			if err != nil {
+				return containerUser{}, fmt.Errorf("invalid gid %q", group)
+			}
+
+			user.groups = append(user.groups, gid)
+		}
+	}
+
+	return user, nil
+}
+
+// switchUser sets the groups, gid & uid of the calling thread. the syscall package's versions
+// change them for every thread of the process, but only the thread that executes the command
+// matters, & its capabilities (which it loses with the uid) have to stay until it's done with
+// what needs them
+func switchUser(user containerUser) error {
+	groups := make([]uint32, len(user.groups))
+	for i, gid := range user.groups {
+		groups[i] = uint32(gid)
+	}
+
+	var groupsPtr unsafe.Pointer
+	if len(groups) > 0 {
+		groupsPtr = unsafe.Pointer(&groups[0])
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(groups)), uintptr(groupsPtr), 0); errno != 0 {
+		return fmt.Errorf("setgroups: %w", errno)
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETRESGID, uintptr(user.gid), uintptr(user.gid), uintptr(user.gid)); errno != 0 {
+		return fmt.Errorf("setresgid %d: %w", user.gid, errno)
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETRESUID, uintptr(user.uid), uintptr(user.uid), uintptr(user.uid)); errno != 0 {
+		return fmt.Errorf("setresuid %d: %w", user.uid, errno)
+	}
+
+	return nil
+}
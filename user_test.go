@@ -0,0 +1,58 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLookupContainerUserInRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"passwd.real": "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/home/app:/bin/sh\n",
+		"group":       "root:x:0:\napp:x:1000:\nwheel:x:10:app\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, "etc", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the container's files are used, not the host's, even through an absolute symlink
+	if err := os.Symlink("/etc/passwd.real", filepath.Join(root, "etc", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec string
+		want containerUser
+	}{
+		{"app", containerUser{uid: 1000, gid: 1000, groups: []int{10}, home: "/home/app"}},
+		{"1000:wheel", containerUser{uid: 1000, gid: 10, groups: []int{10}, home: "/home/app"}},
+		{"4242", containerUser{uid: 4242, home: "/"}},
+	}
+
+	for _, test := range tests {
+		got, err := lookupContainerUser(root, test.spec)
+		if err != nil {
+			t.Errorf("lookupContainerUser(%q) = %v", test.spec, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("lookupContainerUser(%q) = %+v, want %+v", test.spec, got, test.want)
+		}
+	}
+
+	if _, err := lookupContainerUser(root, "nobody-here"); err == nil {
+		t.Error("lookupContainerUser() succeeded for a user that isn't in the container's /etc/passwd")
+	}
+}
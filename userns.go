@@ -0,0 +1,188 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// with user namespaces, the uids & gids 0-65535 of the container are mapped to these on the host,
+// so root in the container is an unprivileged user on the host. it's the usual start of the
+// subordinate ids in /etc/subuid & /etc/subgid
+const (
+	usernsHostId = 100000
+	usernsSize   = 65536
+)
+
+// containerRootId returns the host uid (& gid) of root in the container, which is 0 with
+// --userns=host
+func (options *runOptions) containerRootId() int {
+	if options.userns == "host" {
+		return 0
+	}
+
+	return usernsHostId
+}
+
+// usernsSysProcAttr returns the attributes that start the command in a new user namespace, with
+// the container's ids mapped to the host ids from rootId on, as root.
+//
+// only the command is put in the user namespace. the other namespaces of the container are
+// created (& the mounts & pivot_root are done) by the _child process as real root, so the command
+// gets no privileges over them: root in the container can't mount things or change the hostname,
+// & its capabilities only apply to its own user namespace
+func usernsSysProcAttr(rootId int) *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: rootId, Size: usernsSize}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: rootId, Size: usernsSize}},
+		// so that root in the container can drop to other users with their groups
+		GidMappingsEnableSetgroups: true,
+		Credential:                 &syscall.Credential{Uid: 0, Gid: 0},
+	}
+}
+
+// shiftOwnership adds shift to the uid & gid of every file under dir, so that an image extracted
+// as root is owned by the mapped ids of the container. chown clears the setuid & setgid bits, so
+// the mode is restored afterwards. file capabilities (which are cleared too) aren't restored
+func shiftOwnership(dir string, shift int) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		stat := info.Sys().(*syscall.Stat_t)
+		if err := os.Lchown(path, int(stat.Uid)+shift, int(stat.Gid)+shift); err != nil {
+			return err
+		}
+
+		if info.Mode()&(fs.ModeSetuid|fs.ModeSetgid) != 0 && info.Mode()&fs.ModeSymlink == 0 {
+			return os.Chmod(path, info.Mode())
+		}
+
+		return nil
+	})
+}
+
+// translateUid maps a uid as seen on the host to the uid in the user namespace of the process
+// pid, using its uid_map. the uid is returned as is if the process isn't in a user namespace of
+// its own or the uid isn't mapped
+func translateUid(pid int, uid int) int {
+	mappings, err := readIdMap(pid, "uid_map")
+	if err != nil {
+		return uid
+	}
+
+	for _, m := range mappings {
+		if uid >= m.outside && uid < m.outside+m.count {
+			return m.inside + uid - m.outside
+		}
+	}
+
+	return uid
+}
+
+// hostId maps an id in the user namespace of the process pid to the id on the host, using its
+// uid_map or gid_map (mapFile). ok is false if the id isn't mapped
+func hostId(pid int, mapFile string, id int) (int, bool) {
+	mappings, err := readIdMap(pid, mapFile)
+	if err != nil {
+		return 0, false
+	}
+
+	for _, m := range mappings {
+		if id >= m.inside && id < m.inside+m.count {
+			return m.outside + id - m.inside, true
+		}
+	}
+
+	return 0, false
+}
+
+// a line of a uid_map or gid_map, see user_namespaces(7)
+type idMapping struct {
+	// the first id in the namespace, the id it's mapped to outside of it & how many ids follow
+	inside, outside, count int
+}
+
+// readIdMap reads /proc/<pid>/<mapFile>, i.e. its uid_map or gid_map
+func readIdMap(pid int, mapFile string) ([]idMapping, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/%s", pid, mapFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// each line is <first id in the namespace> <first id outside of it> <count>
+	var mappings []idMapping
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+
+		inside, err1 := strconv.Atoi(fields[0])
+		outside, err2 := strconv.Atoi(fields[1])
+		count, err3 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+
+		mappings = append(mappings, idMapping{inside, outside, count})
+	}
+
+	return mappings, scanner.Err()
+}
+
+// findContainerUsernsPid returns the host pid of a process of the container that's in the
+// container's user namespace, or 0 if its processes are in the same user namespace as its init
+// (--userns=host)
+func findContainerUsernsPid(initPid int) (int, error) {
+	initUserns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/user", initPid))
+	if err != nil {
+		return 0, err
+	}
+
+	processes, err := listContainerProcesses(initPid)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range processes {
+		if userns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/user", p.hostPid)); err == nil && userns != initUserns {
+			return p.hostPid, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// containerRootOwner returns the host uid & gid of root in a running container, i.e. the ids
+// that its user namespace maps 0 to, or 0 with --userns=host
+func containerRootOwner(initPid int) (int, int, error) {
+	pid, err := findContainerUsernsPid(initPid)
+	if err != nil || pid == 0 {
+		return 0, 0, err
+	}
+
+	uid, uidMapped := hostId(pid, "uid_map", 0)
+	gid, gidMapped := hostId(pid, "gid_map", 0)
+	if !uidMapped || !gidMapped {
+		return 0, 0, fmt.Errorf("root of the container isn't mapped to a user of the host")
+	}
+
+	return uid, gid, nil
+}
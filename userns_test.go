@@ -0,0 +1,122 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startUidMappedContainer starts a process tree like that of a container: an init in our user
+// namespace & a command in a user namespace of its own, whose ids 0-65535 are mapped to the host
+// ids from hostId on. it returns the pids of both
+func startUidMappedContainer(t *testing.T, hostId int) (int, int) {
+	init := exec.Command("sh", "-c", "unshare --user sleep 60 & wait")
+	init.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := init.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(-init.Process.Pid, syscall.SIGKILL)
+		init.Wait()
+	})
+
+	var pid int
+	for i := 0; pid == 0 && i < 50; i++ {
+		time.Sleep(20 * time.Millisecond)
+		var err error
+		if pid, err = findContainerUsernsPid(init.Process.Pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pid == 0 {
+		t.Fatal("the command didn't get a user namespace")
+	}
+
+	// like newuidmap would, which focker does through usernsSysProcAttr
+	mapping := fmt.Sprintf("0 %d %d\n", hostId, usernsSize)
+	for _, mapFile := range []string{"uid_map", "gid_map"} {
+		if err := os.WriteFile(fmt.Sprintf("/proc/%d/%s", pid, mapFile), []byte(mapping), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return init.Process.Pid, pid
+}
+
+func TestCopyIntoUidMappedContainer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mapping the ids of a user namespace needs root")
+	}
+
+	initPid, commandPid := startUidMappedContainer(t, usernsHostId)
+
+	uid, gid, err := containerRootOwner(initPid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uid != usernsHostId || gid != usernsHostId {
+		t.Fatalf("containerRootOwner() = %d, %d, want %d, %d", uid, gid, usernsHostId, usernsHostId)
+	}
+
+	// a host file that isn't owned by root, which shouldn't keep its owner
+	src := filepath.Join(t.TempDir(), "app")
+	if err := os.MkdirAll(filepath.Join(src, "conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "conf", "app.conf"), []byte("port=80\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chown(filepath.Join(src, "conf", "app.conf"), 1234, 1234); err != nil {
+		t.Fatal(err)
+	}
+
+	// the container shares our root, so a directory of ours is a directory in it too
+	dest := t.TempDir()
+	if err := copyIntoContainer(src, fmt.Sprintf("/proc/%d/root", initPid), dest, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rel := range []string{"app", "app/conf", "app/conf/app.conf"} {
+		info, err := os.Lstat(filepath.Join(dest, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stat := info.Sys().(*syscall.Stat_t)
+		if stat.Uid != usernsHostId || stat.Gid != usernsHostId {
+			t.Errorf("%s is owned by %d:%d on the host, want %d:%d", rel, stat.Uid, stat.Gid, usernsHostId, usernsHostId)
+		}
+
+		// which is root in the container
+		if got := translateUid(commandPid, int(stat.Uid)); got != 0 {
+			t.Errorf("%s is owned by uid %d in the container, want 0", rel, got)
+		}
+	}
+}
+
+func TestContainerRootOwnerWithoutUserns(t *testing.T) {
+	// --userns=host, where nothing of the container is in a user namespace of its own
+	init := exec.Command("sleep", "60")
+	if err := init.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		init.Process.Kill()
+		init.Wait()
+	})
+
+	uid, gid, err := containerRootOwner(init.Process.Pid)
+	if err != nil || uid != 0 || gid != 0 {
+		t.Errorf("containerRootOwner() = %d, %d, %v, want 0, 0", uid, gid, err)
+	}
+}
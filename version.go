@@ -0,0 +1,52 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// set when building with e.g. go build -ldflags "-X main.version=v0.3.0 -X main.commit=$(git rev-parse HEAD)".
+// without them, they're taken from the build info that go build embeds, see printVersion
+var (
+	version string
+	commit  string
+)
+
+// printVersion prints the version & commit of focker & the Go version it was built with. the
+// build info has the module's version when it was installed with go install <module>@<version>,
+// & the commit when it was built in a git checkout
+func printVersion() {
+	v, c, modified := version, commit, false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if len(v) == 0 && len(info.Main.Version) > 0 && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if len(c) == 0 {
+					c = setting.Value
+				}
+			case "vcs.modified":
+				// only if the commit is that of the build info, since -X overrides it
+				modified = setting.Value == "true" && len(commit) == 0
+			}
+		}
+	}
+
+	if len(v) == 0 {
+		v = "unknown"
+	}
+
+	if len(c) == 0 {
+		c = "unknown"
+	} else if modified {
+		c += " (with uncommitted changes)"
+	}
+
+	fmt.Printf("focker %s\ncommit: %s\ngo: %s\n", v, c, runtime.Version())
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// how often wait checks whether the container has exited
+const waitPollInterval = 100 * time.Millisecond
+
+// how long wait gives focker run to record the exit code once the container's init is gone,
+// before it assumes that focker run was killed & won't record it
+const exitCodeGracePeriod = 2 * time.Second
+
+// wait waits until a container exits, prints its exit code & returns it as the exit code that
+// focker should exit with. args are the container id. it returns right away if the container has
+// already exited
+func wait(args []string) int {
+	if len(args) != 1 {
+		log.Print("usage: focker wait <containerId>")
+		return 1
+	}
+
+	containerId, err := resolveContainerId(args[0])
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	config, err := waitForExitCode(containerId)
+	if err != nil {
+		log.Printf("wait: %v", err)
+		return 1
+	}
+
+	if config.ExitCode == nil {
+		log.Printf("wait: container %s exited without focker recording its exit code (e.g. because its setup failed or focker was killed)", containerId)
+		return 1
+	}
+
+	fmt.Println(*config.ExitCode)
+	return *config.ExitCode
+}
+
+// waitForExitCode waits until focker run has recorded that a container exited & returns its
+// config then. the exit code is recorded after the container's init is gone, so its pid isn't
+// enough to tell
+func waitForExitCode(containerId string) (*containerConfig, error) {
+	var goneSince time.Time
+	for {
+		config, err := readContainerConfig(containerId)
+		if err != nil {
+			// e.g. it was run with --rm
+			return nil, fmt.Errorf("%w (it may have been removed once it exited)", err)
+		}
+
+		if config.State == stateExited {
+			return config, nil
+		}
+
+		// a container that's still being set up doesn't have a pid yet
+		if isContainerRunning(config) || config.State == stateCreated {
+			goneSince = time.Time{}
+		} else if goneSince.IsZero() {
+			goneSince = time.Now()
+		} else if time.Since(goneSince) > exitCodeGracePeriod {
+			refreshContainerState(config)
+			return config, nil
+		}
+
+		time.Sleep(waitPollInterval)
+	}
+}